@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// gitActor is the author or committer of a commit of the Git Data API.
+type gitActor struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+// treeEntry is an entry of a tree of the Git Data API, a nil SHA deletes
+// the path from the base tree.
+type treeEntry struct {
+	Path string  `json:"path"`
+	Mode string  `json:"mode"`
+	Type string  `json:"type"`
+	SHA  *string `json:"sha"`
+}
+
+// publishViaAPI creates the rewrite commit of rw and the tag tagName,
+// annotated like the local one, on a GitHub target with the Git Data API instead of git push, for
+// networks only allowing HTTPS API calls. Only the files the rewrite changed
+// are uploaded, so the upstream commit must already be on the target, like
+// on a fork of the upstream repository.
+func publishViaAPI(ctx context.Context, r *gogit.Repository, rw *rewrite, tagName string) error {
+	g := getGitHubAPI()
+	if g == nil {
+		return fmt.Errorf("--publish-via=github-api needs an HTTPS GitHub target")
+	}
+	commit, err := r.CommitObject(rw.commit)
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	sourceTree, err := rw.source.Tree()
+	if err != nil {
+		return err
+	}
+	changes, err := object.DiffTree(sourceTree, tree)
+	if err != nil {
+		return fmt.Errorf("failed to diff the rewrite of %s: %v", tagName, err)
+	}
+	repo := "/repos/" + targetRepoPath() + "/git"
+	var entries []treeEntry
+	for _, c := range changes {
+		if c.To.Name == "" {
+			entries = append(entries, treeEntry{Path: c.From.Name, Mode: apiMode(c.From.TreeEntry.Mode), Type: "blob"})
+			continue
+		}
+		sha, err := g.createBlob(ctx, r, repo, c.To.TreeEntry.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %v", c.To.Name, err)
+		}
+		entries = append(entries, treeEntry{Path: c.To.Name, Mode: apiMode(c.To.TreeEntry.Mode), Type: "blob", SHA: &sha})
+	}
+
+	var created struct {
+		SHA string `json:"sha"`
+	}
+	err = g.do(ctx, http.MethodPost, repo+"/trees", map[string]any{"base_tree": sourceTree.Hash.String(), "tree": entries}, &created)
+	if err != nil {
+		return fmt.Errorf("failed to create the tree of %s, the upstream commit must be on the target: %v", tagName, err)
+	}
+	if created.SHA != tree.Hash.String() {
+		return fmt.Errorf("tree of %s created as %s, want %s", tagName, created.SHA, tree.Hash)
+	}
+	var parents []string
+	for _, p := range commit.ParentHashes {
+		parents = append(parents, p.String())
+	}
+	err = g.do(ctx, http.MethodPost, repo+"/commits", map[string]any{
+		"message":   commit.Message,
+		"tree":      created.SHA,
+		"parents":   parents,
+		"author":    signatureActor(commit.Author),
+		"committer": signatureActor(commit.Committer),
+	}, &created)
+	if err != nil {
+		return fmt.Errorf("failed to create the commit of %s: %v", tagName, err)
+	}
+	if created.SHA != rw.commit.String() {
+		logrus.Warnf("Commit of %s created as %s instead of %s, it can't be reproduced", tagName, created.SHA, rw.commit)
+	}
+	if tag, err := r.Tag(tagName); err == nil {
+		if to, err := r.TagObject(tag.Hash()); err == nil {
+			err = g.do(ctx, http.MethodPost, repo+"/tags", map[string]any{
+				"tag":     tagName,
+				"message": to.Message,
+				"object":  created.SHA,
+				"type":    "commit",
+				"tagger":  signatureActor(to.Tagger),
+			}, &created)
+			if err != nil {
+				return fmt.Errorf("failed to create the tag object of %s: %v", tagName, err)
+			}
+		}
+	}
+	ref := *targetRefPrefix + tagName
+	err = g.do(ctx, http.MethodPost, repo+"/refs", map[string]string{"ref": ref, "sha": created.SHA}, nil)
+	if err != nil {
+		// told apart from the other failures by the ref on the target
+		var existing struct {
+			Object struct {
+				SHA string `json:"sha"`
+			} `json:"object"`
+		}
+		if gerr := g.do(ctx, http.MethodGet, repo+"/ref/"+strings.TrimPrefix(ref, "refs/"), nil, &existing); gerr == nil && existing.Object.SHA != created.SHA {
+			return fmt.Errorf("failed to create tag %s: %w: %v", tagName, ErrTagAlreadyPublished, err)
+		}
+		return fmt.Errorf("failed to create tag %s: %v", tagName, err)
+	}
+	return nil
+}
+
+// createBlob uploads the blob h of r and returns its hash on the target.
+func (g *githubAPI) createBlob(ctx context.Context, r *gogit.Repository, repo string, h plumbing.Hash) (string, error) {
+	blob, err := r.BlobObject(h)
+	if err != nil {
+		return "", err
+	}
+	b, err := blobContents(blob)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		SHA string `json:"sha"`
+	}
+	err = g.do(ctx, http.MethodPost, repo+"/blobs", map[string]string{"content": base64.StdEncoding.EncodeToString(b), "encoding": "base64"}, &created)
+	return created.SHA, err
+}
+
+// apiMode returns m without the leading zero of filemode.FileMode.String,
+// like 100644.
+func apiMode(m filemode.FileMode) string {
+	return strings.TrimPrefix(m.String(), "0")
+}
+
+func signatureActor(s object.Signature) gitActor {
+	return gitActor{Name: s.Name, Email: s.Email, Date: s.When.Format(time.RFC3339)}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+// auditEntry records a push to the target for change-management audits.
+type auditEntry struct {
+	Time       time.Time         `json:"time"`
+	Target     string            `json:"target"`
+	Via        string            `json:"via"`
+	Actor      string            `json:"actor"`
+	ConfigHash string            `json:"configHash"`
+	Version    map[string]string `json:"version"`
+	Refs       []string          `json:"refs"`
+	Error      string            `json:"error,omitempty"`
+}
+
+var (
+	auditMu      sync.Mutex
+	auditEntries []auditEntry
+)
+
+// recordPush records a push of refs via git or the API to --audit-log and for
+// the metadata branch, failed ones included.
+func recordPush(via string, refs []string, err error) {
+	e := auditEntry{
+		Time:       time.Now().UTC(),
+		Target:     *targetRepo,
+		Via:        via,
+		Actor:      auditActor(),
+		ConfigHash: configHash(),
+		Version:    toolVersion(),
+		Refs:       refs,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	b, merr := json.Marshal(e)
+	if merr != nil {
+		logrus.Warnf("Failed to encode audit entry: %v", merr)
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditEntries = append(auditEntries, e)
+	if *auditLog == "" {
+		return
+	}
+	f, ferr := os.OpenFile(*auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		logrus.Warnf("Failed to open audit log: %v", ferr)
+		return
+	}
+	defer f.Close()
+	if _, ferr = f.Write(append(b, '\n')); ferr != nil {
+		logrus.Warnf("Failed to write audit log: %v", ferr)
+	}
+}
+
+// takeAuditEntries returns the recorded entries as JSON lines and forgets
+// them.
+func takeAuditEntries() []byte {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	var lines []byte
+	for _, e := range auditEntries {
+		b, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		lines = append(append(lines, b...), '\n')
+	}
+	auditEntries = nil
+	return lines
+}
+
+// auditActor returns the identity of the target credentials without the
+// secret: the username and the last characters of a token, or the
+// fingerprint of an SSH key.
+func auditActor() string {
+	auth, err := getAuth()
+	if err != nil {
+		return "unknown"
+	}
+	switch a := auth.(type) {
+	case *http.BasicAuth:
+		return "token " + a.Username + ":" + maskSecret(a.Password)
+	case *gitssh.PublicKeys:
+		sum := sha256.Sum256(a.Signer.PublicKey().Marshal())
+		return "ssh " + a.User + " SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	}
+	return "ambient credentials"
+}
+
+// maskSecret keeps the last 4 characters of long secrets only.
+func maskSecret(s string) string {
+	if len(s) < 12 {
+		return "****"
+	}
+	return "****" + s[len(s)-4:]
+}
+
+// configHash returns the SHA-256 of the values of all flags, to tell the
+// configurations of runs apart. Flags only name secret files, never hold
+// secrets.
+func configHash() string {
+	h := sha256.New()
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(h, "%s=%s\n", f.Name, strings.ReplaceAll(f.Value.String(), "\n", " "))
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	authMu      sync.Mutex
+	auth        transport.AuthMethod
+	authFetched time.Time
+)
+
+// readSecret reads a secret from a file, like a mounted Kubernetes or Docker
+// secret, without the trailing newline.
+func readSecret(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// getAuth returns the auth of the target from --target-token-file,
+// --target-token-source or --ssh-key-file, or nil to use the credentials of
+// the URL, the SSH agent or the environment. Secrets are only read from files
+// and secret stores, flags and environment variables show up in process
+// listings and pod specs. Tokens are fetched again once older than
+// --target-token-ttl, as GitHub App and OIDC exchanged tokens expire hourly.
+func getAuth() (transport.AuthMethod, error) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	if authFetched.IsZero() {
+		// a failed fetch is retried on the next call, like a service
+		// waiting out an outage of the secret store
+		a, err := newAuth()
+		if err != nil {
+			return nil, withExitCode(exitAuth, err)
+		}
+		auth, authFetched = a, time.Now()
+		return auth, nil
+	}
+	if _, ok := auth.(*http.BasicAuth); ok && *tokenTTL > 0 && time.Since(authFetched) >= *tokenTTL {
+		// a failed refresh is retried on the next call, the expired token
+		// would fail anyway
+		logrus.Infof("Refreshing the target token fetched %s ago", time.Since(authFetched).Round(time.Second))
+		token, err := fetchToken()
+		if err != nil {
+			return nil, withExitCode(exitAuth, err)
+		}
+		auth, authFetched = &http.BasicAuth{Username: tokenUsername(), Password: token}, time.Now()
+	}
+	return auth, nil
+}
+
+// newAuth reads the auth of the target, see getAuth.
+func newAuth() (transport.AuthMethod, error) {
+	switch {
+	case *targetTokenFile != "" && *tokenSource != "":
+		return nil, errors.New("--target-token-file and --target-token-source are mutually exclusive")
+	case (*targetTokenFile != "" || *tokenSource != "") && *sshKeyFile != "":
+		return nil, errors.New("a target token and --ssh-key-file are mutually exclusive")
+	case (*targetTokenFile != "" || *tokenSource != "") && *targetUsername == "" && targetForge() == forgeGitee:
+		return nil, errors.New("a Gitee target takes the token as the password of the account, set --target-username to its name")
+	case *targetTokenFile != "" || *tokenSource != "":
+		token, err := fetchToken()
+		if err != nil {
+			return nil, err
+		}
+		return &http.BasicAuth{Username: tokenUsername(), Password: token}, nil
+	case *sshKeyFile != "":
+		var passphrase string
+		if *sshPassFile != "" {
+			p, err := readSecret(*sshPassFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read SSH key passphrase: %v", err)
+			}
+			passphrase = p
+		}
+		keys, err := gitssh.NewPublicKeysFromFile("git", *sshKeyFile, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key %s: %v", *sshKeyFile, err)
+		}
+		return keys, nil
+	}
+	return nil, nil
+}
+
+// fetchToken fetches the target token from --target-token-file or
+// --target-token-source.
+func fetchToken() (string, error) {
+	source := *tokenSource
+	if *targetTokenFile != "" {
+		source = "file:" + *targetTokenFile
+	}
+	p, err := newTokenProvider(source)
+	if err != nil {
+		return "", err
+	}
+	token, err := p.fetch()
+	if err != nil {
+		return "", fmt.Errorf("failed to get target token: %v", err)
+	}
+	return token, nil
+}
+
+// remoteAuth returns the auth of remote name, only the target has one.
+func remoteAuth(name string) (transport.AuthMethod, error) {
+	if name != targetRemote {
+		return nil, nil
+	}
+	return getAuth()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/semver"
+)
+
+// releaseBranch returns the release series branch of upstream tag name, like
+// release-1.30-mod for v1.30.2, or "" for prereleases.
+func releaseBranch(name string) string {
+	if !semver.IsValid(name) || semver.Prerelease(name) != "" {
+		return ""
+	}
+	return "release-" + strings.TrimPrefix(semver.MajorMinor(name), "v") + "-mod"
+}
+
+// updateReleaseBranches moves the release series branches of the upstream
+// tags published in this run to the newest published patch release of their
+// minor. The rewrite commits of two patch releases don't share history, so
+// the branches are force pushed, but never to an older release.
+func updateReleaseBranches(ctx context.Context, r *gogit.Repository, published []string) error {
+	branches := map[string]bool{}
+	for _, name := range published {
+		if b := releaseBranch(name); b != "" {
+			branches[b] = true
+		}
+	}
+	if len(branches) == 0 {
+		return nil
+	}
+	auth, err := getAuth()
+	if err != nil {
+		return err
+	}
+	// the workdir doesn't have the tags the workers just published
+	err = r.FetchContext(ctx, &gogit.FetchOptions{
+		RemoteName:   targetRemote,
+		Auth:         auth,
+		RefSpecs:     []config.RefSpec{config.RefSpec(remoteRefPrefix(targetRemote) + "*:refs/tags/" + targetRemote + "/*")},
+		ProxyOptions: transferProxy(targetRemote),
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch %s: %v", targetRemote, err)
+	}
+	target, err := remoteTags(r, targetRemote)
+	if err != nil {
+		return err
+	}
+	newest := map[string]string{}
+	for tag := range target {
+		name, ok := strings.CutSuffix(tag, "-mod")
+		b := releaseBranch(name)
+		if !ok || !branches[b] {
+			continue
+		}
+		if cur, ok := newest[b]; !ok || semver.Compare(name, cur) > 0 {
+			newest[b] = name
+		}
+	}
+	for b, name := range newest {
+		commit, err := tagCommit(r, target[name+"-mod"])
+		if err != nil {
+			return fmt.Errorf("failed to get commit of %s-mod: %v", name, err)
+		}
+		logrus.Infof("Moving branch %s to %s-mod", b, name)
+		if *publishVia == "github-api" {
+			err = updateBranchViaAPI(ctx, b, commit.Hash)
+			recordPush("github-api", []string{"refs/heads/" + b}, err)
+		} else {
+			err = pushBranch(ctx, r, b, commit.Hash)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update branch %s: %v", b, err)
+		}
+	}
+	return nil
+}
+
+func pushBranch(ctx context.Context, r *gogit.Repository, branch string, h plumbing.Hash) error {
+	ref := plumbing.NewBranchReferenceName(branch)
+	if err := r.Storer.SetReference(plumbing.NewHashReference(ref, h)); err != nil {
+		return err
+	}
+	return pushRefs(ctx, r, branch, []config.RefSpec{config.RefSpec("+" + ref + ":" + ref)})
+}
+
+// updateBranchViaAPI points branch at h with the GitHub Git Data API,
+// creating it if needed.
+func updateBranchViaAPI(ctx context.Context, branch string, h plumbing.Hash) error {
+	g := getGitHubAPI()
+	if g == nil {
+		return fmt.Errorf("--publish-via=github-api needs an HTTPS GitHub target")
+	}
+	repo := "/repos/" + targetRepoPath() + "/git"
+	err := g.do(ctx, http.MethodPatch, repo+"/refs/heads/"+branch, map[string]any{"sha": h.String(), "force": true}, nil)
+	if err == nil {
+		return nil
+	}
+	// updating a missing ref fails, it is created instead
+	return g.do(ctx, http.MethodPost, repo+"/refs", map[string]string{"ref": "refs/heads/" + branch, "sha": h.String()}, nil)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// canaryRepo is a consumer of --canary-repos, the owner/repo on the host of
+// the target and the directory of its go.mod.
+type canaryRepo struct {
+	repo, dir string
+}
+
+// canaryConsumers returns the consumers of --canary-repos, like
+// you/scheduler or you/tools:cmd/plugin.
+func canaryConsumers() []canaryRepo {
+	var repos []canaryRepo
+	for _, s := range strings.Split(*canaryRepos, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		repo, dir, _ := strings.Cut(s, ":")
+		repos = append(repos, canaryRepo{repo: strings.Trim(repo, "/"), dir: strings.Trim(dir, "/")})
+	}
+	return repos
+}
+
+// checkCanaryRepos validates --canary-repos.
+func checkCanaryRepos() error {
+	for _, c := range canaryConsumers() {
+		if owner, repo, ok := strings.Cut(c.repo, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return fmt.Errorf("invalid canary repo %q, want <owner>/<repo>[:<go.mod directory>]", c.repo)
+		}
+	}
+	return nil
+}
+
+// openCanaryPRs opens a pull request on each of --canary-repos bumping the
+// replaces with the target to the newest of the published upstream tags,
+// so downstreams try the new version in their CI. The tags are already
+// published, so failures are only logged.
+func openCanaryPRs(ctx context.Context, published []string) {
+	if len(published) == 0 {
+		return
+	}
+	g := getGitHubAPI()
+	if g == nil {
+		logrus.Warnf("Not opening canary pull requests, the target %s is not on GitHub", targetHost())
+		return
+	}
+	// the newest whatever the --order of the tags
+	name := slices.MaxFunc(published, compareTags)
+	for _, c := range canaryConsumers() {
+		if err := openCanaryPR(ctx, g, c, name); err != nil {
+			logrus.Warnf("Failed to open the canary pull request of %s on %s: %v", name+"-mod", c.repo, err)
+		}
+	}
+}
+
+// openCanaryPR opens the pull request of upstream tag name on c, unless it
+// doesn't replace a module with the target at an older version, or the
+// branch of the pull request exists.
+func openCanaryPR(ctx context.Context, g *githubAPI, c canaryRepo, name string) error {
+	tagName := name + "-mod"
+	branch := "kksyncer/bump-" + tagName
+	err := g.do(ctx, http.MethodGet, "/repos/"+c.repo+"/branches/"+url.PathEscape(branch), nil, nil)
+	if err == nil {
+		logrus.Infof("Canary branch %s exists on %s, not opening another pull request", branch, c.repo)
+		return nil
+	}
+	if !strings.Contains(err.Error(), "404 Not Found") {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "kksyncer-canary-")
+	if err != nil {
+		return err
+	}
+	defer removeAll(dir)
+	auth, err := getAuth()
+	if err != nil {
+		return err
+	}
+	repoURL := "https://" + targetHost() + "/" + c.repo + ".git"
+	r, err := gogit.PlainCloneContext(ctx, dir, false, &gogit.CloneOptions{URL: repoURL, Auth: auth, ProxyOptions: transferProxyURL(repoURL)})
+	if err != nil {
+		return fmt.Errorf("failed to clone: %v", err)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return err
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	if err = w.Checkout(&gogit.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: true}); err != nil {
+		return err
+	}
+
+	modDir := filepath.Join(dir, filepath.FromSlash(c.dir))
+	gomod := filepath.Join(modDir, "go.mod")
+	b, err := os.ReadFile(gomod)
+	if err != nil {
+		return err
+	}
+	f, err := modfile.Parse(gomod, b, nil)
+	if err != nil {
+		return err
+	}
+	bumped, err := bumpReplaces(f, name, tagName)
+	if err != nil {
+		return err
+	}
+	if len(bumped) == 0 {
+		logrus.Infof("%s doesn't replace a module with %s older than %s, not opening a canary pull request", c.repo, targetModulePath(), tagName)
+		return nil
+	}
+	f.Cleanup()
+	if b, err = f.Format(); err != nil {
+		return err
+	}
+	if err = os.WriteFile(gomod, b, 0644); err != nil {
+		return err
+	}
+	// for the go.sum of the new version and its dependencies
+	cmd := commandContext(ctx, "go", "mod", "tidy")
+	cmd.Dir = modDir
+	cmd.Env = goEnv(tagEnv(name))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return &ErrTidyFailed{Output: strings.TrimSpace(string(out)), Err: err}
+	}
+	for _, file := range []string{"go.mod", "go.sum"} {
+		if err = stageFile(w, path.Join(c.dir, file)); err != nil {
+			return err
+		}
+	}
+	msg := fmt.Sprintf("Bump %s to %s", targetModulePath(), tagName)
+	_, err = w.Commit(msg, &gogit.CommitOptions{Author: &object.Signature{Name: "kksyncer", When: time.Now()}})
+	if err != nil {
+		return err
+	}
+	err = r.PushContext(ctx, &gogit.PushOptions{
+		RemoteName:   "origin",
+		Auth:         auth,
+		RefSpecs:     []config.RefSpec{config.RefSpec("refs/heads/" + branch + ":refs/heads/" + branch)},
+		ProxyOptions: transferProxyURL(repoURL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push %s: %v", branch, err)
+	}
+	var pr struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err = g.do(ctx, http.MethodPost, "/repos/"+c.repo+"/pulls", map[string]any{
+		"title": msg,
+		"head":  branch,
+		"base":  head.Name().Short(),
+		"body": fmt.Sprintf("Bumps %s to %s, the Go module release of upstream tag %s published by kksyncer.\n\n"+
+			"This is a canary: merge it if CI passes, or close it to stay on the current version.", strings.Join(bumped, ", "), tagName, name),
+	}, &pr)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Opened the canary pull request of %s on %s: %s", tagName, c.repo, pr.HTMLURL)
+	return nil
+}
+
+// targetModulePath returns the module path of the target, the path of its
+// published modules in the replaces of consumers.
+func targetModulePath() string {
+	return targetHost() + "/" + targetRepoPath()
+}
+
+// bumpReplaces sets the replaces of f with modules of the target at versions
+// older than tagName to tagName, and the requires of the replaced modules
+// older than the upstream tag name to it, and returns the replaced modules
+// it bumped.
+func bumpReplaces(f *modfile.File, name, tagName string) ([]string, error) {
+	target := targetModulePath()
+	var bumped []string
+	for _, r := range f.Replace {
+		if r.New.Path != target && !strings.HasPrefix(r.New.Path, target+"/") || r.New.Version == "" || semver.Compare(tagName, r.New.Version) <= 0 {
+			continue
+		}
+		// a replace of a single version would no longer apply to the bumped
+		// require
+		if r.Old.Version != "" {
+			logrus.Warnf("Not bumping the replace of %s@%s, it only replaces that version", r.Old.Path, r.Old.Version)
+			continue
+		}
+		if err := f.AddReplace(r.Old.Path, r.Old.Version, r.New.Path, tagName); err != nil {
+			return nil, err
+		}
+		for _, req := range f.Require {
+			if req.Mod.Path == r.Old.Path && semver.Compare(name, req.Mod.Version) > 0 {
+				if err := f.AddRequire(req.Mod.Path, name); err != nil {
+					return nil, err
+				}
+			}
+		}
+		bumped = append(bumped, r.Old.Path)
+	}
+	return bumped, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how long a git or go command killed by a cancel is waited for, with its
+// children still holding its output
+const cancelWaitDelay = 5 * time.Second
+
+// signalContext returns a context canceled on SIGINT or SIGTERM. A second
+// signal exits right away.
+func signalContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, func() {
+		logrus.Warn("Interrupted, stopping, interrupt again to exit right away")
+		stop()
+	})
+	return ctx
+}
+
+// commandContext returns the command name with args, killed when ctx is
+// canceled.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = cancelWaitDelay
+	return cmd
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+	modzip "golang.org/x/mod/zip"
+)
+
+// releaseAsset is a file attached to a release.
+type releaseAsset struct {
+	name string
+	data []byte
+}
+
+// moduleChecksums returns the release assets with the checksums of the
+// rewritten modules of modFiles of upstream tag name published from commit
+// of the tree at root, the root module as version and the nested ones with
+// the versions of their --module-tags: checksums.txt with the SHA-256 of
+// their .zip and .mod files at their proxy paths, go.sum with their lines for
+// the go command, and with --cosign-key checksums.txt.sig, the cosign
+// signature of checksums.txt.
+func moduleChecksums(ctx context.Context, root string, modFiles []string, name, version, commit string) ([]releaseAsset, error) {
+	var sums, goSum bytes.Buffer
+	for _, modFile := range modFiles {
+		gomod, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(modFile)))
+		if err != nil {
+			return nil, err
+		}
+		m := module.Version{Path: modfile.ModulePath(gomod), Version: version}
+		if modFile != "go.mod" {
+			// a nested module only has a version with a tag of its own
+			tags, err := moduleTags(root, name, []string{modFile})
+			if err != nil {
+				return nil, err
+			}
+			if len(tags) == 0 {
+				continue
+			}
+			m.Version = path.Base(tags[0])
+		}
+		// like a module at v2 or above without a /v2 suffix, which the go
+		// command only gets as +incompatible
+		if err = module.Check(m.Path, m.Version); err != nil {
+			logrus.Warnf("Not publishing the checksums of %s: %v", modFile, err)
+			continue
+		}
+		escaped, err := module.EscapePath(m.Path)
+		if err != nil {
+			return nil, err
+		}
+		zipSum, zipHash, err := moduleZipHash(root, modFile, m, commit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the module zip of %s: %v", modFile, err)
+		}
+		modHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(gomod)), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&sums, "%s  %s/@v/%s.zip\n", zipSum, escaped, m.Version)
+		fmt.Fprintf(&sums, "%x  %s/@v/%s.mod\n", sha256.Sum256(gomod), escaped, m.Version)
+		fmt.Fprintf(&goSum, "%s %s %s\n%s %s/go.mod %s\n", m.Path, m.Version, zipHash, m.Path, m.Version, modHash)
+	}
+	if sums.Len() == 0 {
+		return nil, nil
+	}
+	assets := []releaseAsset{{"checksums.txt", sums.Bytes()}, {"go.sum", goSum.Bytes()}}
+	if *cosignKey != "" {
+		sig, err := cosignSign(ctx, sums.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign the checksums: %v", err)
+		}
+		assets = append(assets, releaseAsset{"checksums.txt.sig", sig})
+	}
+	return assets, nil
+}
+
+// moduleZipHash creates the zip of module m of modFile at commit like the
+// module proxy, and returns its SHA-256 and go.sum hash.
+func moduleZipHash(root, modFile string, m module.Version, commit string) (string, string, error) {
+	f, err := os.CreateTemp("", "kksyncer-*.zip")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	subdir := path.Dir(modFile)
+	if subdir == "." {
+		subdir = ""
+	}
+	h := sha256.New()
+	if err = modzip.CreateFromVCS(io.MultiWriter(f, h), m, root, commit, subdir); err != nil {
+		return "", "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", "", err
+	}
+	zipHash, err := dirhash.HashZip(f.Name(), dirhash.Hash1)
+	if err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), zipHash, nil
+}
+
+// checkModuleZips checks the modules of modFiles in the tree at root against
+// the constraints of module zips, like case-insensitive path collisions,
+// invalid file names and size limits, so a published version can't fail to
+// download with go get.
+func checkModuleZips(root string, modFiles []string) error {
+	for _, modFile := range modFiles {
+		cf, err := modzip.CheckDir(filepath.Join(root, filepath.FromSlash(path.Dir(modFile))))
+		if err == nil {
+			continue
+		}
+		var problems []string
+		for _, f := range cf.Invalid {
+			rel, _ := filepath.Rel(root, f.Path)
+			problems = append(problems, fmt.Sprintf("%s: %v", filepath.ToSlash(rel), f.Err))
+		}
+		if cf.SizeError != nil {
+			problems = append(problems, cf.SizeError.Error())
+		}
+		if len(problems) == 0 {
+			return fmt.Errorf("failed to check the module zip of %s: %v", modFile, err)
+		}
+		return fmt.Errorf("the module of %s can't be zipped: %s", modFile, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// cosignSign returns the signature of b by cosign sign-blob with
+// --cosign-key, its password in COSIGN_PASSWORD.
+func cosignSign(ctx context.Context, b []byte) ([]byte, error) {
+	cmd := commandContext(ctx, "cosign", "sign-blob", "--yes", "--key", *cosignKey, "-")
+	cmd.Stdin = bytes.NewReader(b)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	sig, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return sig, nil
+}
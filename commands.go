@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type command struct {
+	name    string
+	summary string
+	// positional arguments, for the usage line
+	args     string
+	examples []string
+	run      func(ctx context.Context) error
+	// run without loading and validating the config
+	bare bool
+}
+
+var commands []*command
+
+// the commands are set in init, help and completion refer to them
+func init() {
+	commands = []*command{
+		{
+			name:    "sync",
+			summary: "Rewrite and publish upstream tags missing on the target (default)",
+			examples: []string{
+				"kksyncer --target-repo https://github.com/you/kubernetes.git --target-token-file /run/secrets/token",
+				"kksyncer sync --config kksyncer.json --workers 4 --keep-going",
+				"kksyncer sync --config kksyncer.json --interval 1h",
+			},
+			run: syncCommand,
+		},
+		{
+			name:    "list",
+			summary: "List upstream tags with their published tag and status, without writing anything",
+			examples: []string{
+				"kksyncer list --target-repo https://github.com/you/kubernetes.git",
+				"kksyncer list --config kksyncer.json --output json",
+			},
+			run: runList,
+		},
+		{
+			name:     "rewrite-preview",
+			summary:  "Print the go.mod diff of rewriting an upstream tag, without committing or pushing",
+			args:     "<tag>",
+			examples: []string{"kksyncer rewrite-preview v1.30.0", "kksyncer rewrite-preview --rewrite-rules 'go 1.22.3' v1.30.0"},
+			run:      runRewritePreview,
+		},
+		{
+			name:    "rewrite",
+			summary: "Rewrite the go.mod on stdin for an upstream tag to stdout, or the go.mod files of a local checkout in place, without git",
+			args:    "--tag <tag>",
+			examples: []string{
+				"kksyncer rewrite --profile kubernetes --tag v1.29.0 < go.mod > go.mod.rewritten",
+				"kksyncer rewrite --tag v1.29.0 --dir ./kubernetes",
+			},
+			run: runRewrite,
+		},
+		{
+			name:     "reproduce",
+			summary:  "Rewrite the upstream tag of a published tag again and check the result matches it",
+			args:     "[<tag>-mod]",
+			examples: []string{"kksyncer reproduce --target-repo https://github.com/you/kubernetes.git --tag v1.30.0-mod"},
+			run:      runReproduce,
+		},
+		{
+			name:     "history",
+			summary:  "Print the recent runs recorded in --history-db, or the runs of a tag with its outcome, duration and commit",
+			args:     "[<tag>]",
+			examples: []string{"kksyncer history --history-db /var/lib/kksyncer/history.db", "kksyncer history --history-db /var/lib/kksyncer/history.db v1.28.9"},
+			run:      runHistoryCommand,
+		},
+		{
+			name:     "validate-config",
+			summary:  "Check the flags and the --config file without touching git, or print its JSON schema with --schema",
+			examples: []string{"kksyncer validate-config --config kksyncer.json", "kksyncer validate-config --schema > kksyncer.schema.json"},
+			run:      runValidateConfig,
+		},
+		{
+			name:     "doctor",
+			summary:  "Check the environment and print diagnostics",
+			examples: []string{"kksyncer doctor --target-repo https://github.com/you/kubernetes.git"},
+			run:      runDoctor,
+		},
+		{
+			name:     "help",
+			summary:  "Print the help of a command",
+			args:     "[<command>]",
+			examples: []string{"kksyncer help sync"},
+			run:      runHelp,
+			bare:     true,
+		},
+		{
+			name:     "completion",
+			summary:  "Print the completion script of a shell: bash, zsh or fish",
+			args:     "<shell>",
+			examples: []string{"source <(kksyncer completion bash)", "kksyncer completion fish > ~/.config/fish/completions/kksyncer.fish"},
+			run:      runCompletion,
+			bare:     true,
+		},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-16s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun %s help <command> for the examples of a command.\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// commandUsage prints the help of c, with its examples.
+func commandUsage(c *command) {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s\n\n%s.\n", strings.TrimSpace(os.Args[0]+" "+c.name+" [flags] "+c.args), c.summary)
+	if len(c.examples) > 0 {
+		fmt.Fprintf(out, "\nExamples:\n")
+		for _, e := range c.examples {
+			fmt.Fprintf(out, "  %s\n", e)
+		}
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func runHelp(context.Context) error {
+	if flag.NArg() == 0 {
+		usage()
+		return nil
+	}
+	c := findCommand(flag.Arg(0))
+	if c == nil {
+		return withExitCode(exitConfig, fmt.Errorf("unknown command %q", flag.Arg(0)))
+	}
+	commandUsage(c)
+	return nil
+}
+
+func runCompletion(context.Context) error {
+	var names, flags []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f.Name)
+	})
+	switch flag.Arg(0) {
+	case "bash":
+		fmt.Printf(bashCompletion, strings.Join(names, " "), "--"+strings.Join(flags, " --"))
+	case "zsh":
+		// zsh runs bash completions with bashcompinit
+		fmt.Printf("autoload -U +X bashcompinit && bashcompinit\n"+bashCompletion, strings.Join(names, " "), "--"+strings.Join(flags, " --"))
+	case "fish":
+		for _, c := range commands {
+			fmt.Printf("complete -c kksyncer -n __fish_use_subcommand -a %s -d %q\n", c.name, c.summary)
+		}
+		flag.VisitAll(func(f *flag.Flag) {
+			usage, _, _ := strings.Cut(f.Usage, ",")
+			fmt.Printf("complete -c kksyncer -l %s -d %q\n", f.Name, usage)
+		})
+	default:
+		return withExitCode(exitConfig, fmt.Errorf("unknown shell %q, want bash, zsh or fish", flag.Arg(0)))
+	}
+	return nil
+}
+
+// bashCompletion completes the command as the first argument and the flags
+// anywhere, formatted with the commands and the flags.
+const bashCompletion = `_kksyncer() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%[2]s" -- "$cur"))
+	elif [[ $COMP_CWORD == 1 ]]; then
+		COMPREPLY=($(compgen -W "%[1]s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -f -- "$cur"))
+	fi
+}
+complete -F _kksyncer kksyncer
+`
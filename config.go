@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envName returns the environment variable of the flag name, like
+// KKSYNCER_TARGET_REPO for target-repo.
+func envName(name string) string {
+	return "KKSYNCER_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyConfig sets the flags not given on the command line from their
+// environment variables, and then the ones still unset from the --config file.
+func applyConfig() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if err != nil || set[f.Name] || !ok {
+			return
+		}
+		if err = f.Value.Set(v); err != nil {
+			err = fmt.Errorf("invalid %s: %v", envName(f.Name), err)
+		}
+		set[f.Name] = true
+	})
+	if err != nil || *configFile == "" {
+		return err
+	}
+
+	b, err := os.ReadFile(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+	var values map[string]any
+	if err = json.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", *configFile, err)
+	}
+	for name, v := range values {
+		// for editors validating the file
+		if name == "$schema" {
+			continue
+		}
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown flag %q in config %s", name, *configFile)
+		}
+		if set[name] {
+			continue
+		}
+		// JSON numbers and booleans are set like on the command line
+		if err = f.Value.Set(fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("invalid %s in config %s: %v", name, *configFile, err)
+		}
+	}
+	return nil
+}
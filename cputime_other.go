@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package main
+
+import "time"
+
+// cpuTime returns 0, the CPU time isn't known on this platform.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// longest run of a secret manager CLI, the token is fetched on the git
+// transport without the context of the run
+const secretCommandTimeout = time.Minute
+
+// tokenProvider fetches the token of the target from a secret store.
+type tokenProvider interface {
+	fetch() (string, error)
+}
+
+// newTokenProvider returns the provider of a --target-token-source, like
+// vault:secret/kksyncer#token.
+func newTokenProvider(source string) (tokenProvider, error) {
+	kind, ref, ok := strings.Cut(source, ":")
+	if !ok || ref == "" {
+		return nil, fmt.Errorf("invalid token source %q, want <provider>:<secret>", source)
+	}
+	ref, field, _ := strings.Cut(ref, "#")
+	switch kind {
+	case "file":
+		return fileToken(ref), nil
+	case "vault":
+		if field == "" {
+			field = "token"
+		}
+		return vaultToken{ref, field}, nil
+	case "aws":
+		return awsToken{ref, field}, nil
+	case "gcp":
+		return gcpToken(ref), nil
+	}
+	return nil, fmt.Errorf("unknown token provider %q, known providers are file, vault, aws and gcp", kind)
+}
+
+type fileToken string
+
+func (f fileToken) fetch() (string, error) {
+	return readSecret(string(f))
+}
+
+// vaultToken is a field of a HashiCorp Vault KV secret, read with the vault
+// CLI, which takes VAULT_ADDR, VAULT_TOKEN and friends from the environment.
+type vaultToken struct{ path, field string }
+
+func (v vaultToken) fetch() (string, error) {
+	return secretCommand("vault", "kv", "get", "-field="+v.field, v.path)
+}
+
+// awsToken is an AWS Secrets Manager secret, or a key of a JSON secret, read
+// with the aws CLI and its usual credential chain.
+type awsToken struct{ id, key string }
+
+func (a awsToken) fetch() (string, error) {
+	s, err := secretCommand("aws", "secretsmanager", "get-secret-value", "--secret-id", a.id, "--query", "SecretString", "--output", "text")
+	if err != nil || a.key == "" {
+		return s, err
+	}
+	var values map[string]string
+	if err = json.Unmarshal([]byte(s), &values); err != nil {
+		return "", fmt.Errorf("failed to parse secret %s: %v", a.id, err)
+	}
+	v, ok := values[a.key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", a.id, a.key)
+	}
+	return v, nil
+}
+
+// gcpToken is a GCP Secret Manager secret, by name or as
+// projects/<project>/secrets/<secret>[/versions/<version>], read with gcloud.
+type gcpToken string
+
+func (g gcpToken) fetch() (string, error) {
+	name := string(g)
+	if !strings.Contains(name, "/") {
+		return secretCommand("gcloud", "secrets", "versions", "access", "latest", "--secret="+name)
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	return secretCommand("gcloud", "secrets", "versions", "access", name)
+}
+
+// secretCommand runs a secret manager CLI and returns its output without the
+// trailing newline.
+func secretCommand(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := commandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %v\n%s", name, err, stderr.Bytes())
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
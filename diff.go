@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lines of context around changes in unified diffs
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+	// line indexes in a and b before the op
+	ai, bi int
+}
+
+// unifiedDiff returns the unified diff between a and b, or an empty string if
+// they are equal. It uses a plain LCS, which is fine for files like go.mod.
+func unifiedDiff(nameA, nameB string, a, b string) string {
+	al, bl := splitLines(a), splitLines(b)
+	// lcs[i][j] is the length of the longest common subsequence of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(al) || j < len(bl) {
+		switch {
+		case i < len(al) && j < len(bl) && al[i] == bl[j]:
+			ops = append(ops, diffOp{' ', al[i], i, j})
+			i, j = i+1, j+1
+		case j == len(bl) || (i < len(al) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', al[i], i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', bl[j], i, j})
+			j++
+		}
+	}
+
+	var sb strings.Builder
+	for start := 0; start < len(ops); {
+		// find the next change and the end of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		// a change after 2*diffContext unchanged lines still joins the hunk,
+		// their contexts touch
+		for k := first; k < len(ops) && k <= last+2*diffContext+1; k++ {
+			if ops[k].kind != ' ' {
+				last = k
+			}
+		}
+		from, to := max(first-diffContext, start), min(last+diffContext+1, len(ops))
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		var aLen, bLen int
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(ops[from].ai, aLen), hunkRange(ops[from].bi, bLen))
+		for _, op := range ops[from:to] {
+			sb.WriteString(string(op.kind) + op.text + "\n")
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lines returns the lines l1 to ln, with the ones in changed as x<i>.
+func lines(n int, changed ...int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		prefix := "l"
+		for _, c := range changed {
+			if c == i {
+				prefix = "x"
+			}
+		}
+		fmt.Fprintf(&sb, "%s%d\n", prefix, i)
+	}
+	return sb.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		changed []int
+		want    string
+	}{
+		{
+			// 2*diffContext unchanged lines between, their contexts touch
+			name:    "one hunk",
+			changed: []int{1, 2 + 2*diffContext},
+			want: "--- a\n+++ b\n@@ -1,11 +1,11 @@\n" +
+				"-l1\n+x1\n l2\n l3\n l4\n l5\n l6\n l7\n-l8\n+x8\n l9\n l10\n l11\n",
+		},
+		{
+			name:    "two hunks",
+			changed: []int{1, 3 + 2*diffContext},
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n" +
+				"-l1\n+x1\n l2\n l3\n l4\n" +
+				"@@ -6,7 +6,7 @@\n l6\n l7\n l8\n-l9\n+x9\n l10\n l11\n l12\n",
+		},
+	} {
+		if got := unifiedDiff("a", "b", lines(16), lines(16, c.changed...)); got != c.want {
+			t.Errorf("%s: diff is\n%s\nwant\n%s", c.name, got, c.want)
+		}
+	}
+	if got := unifiedDiff("a", "b", lines(5), lines(5)); got != "" {
+		t.Errorf("diff of equal files is\n%s", got)
+	}
+}
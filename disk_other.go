@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("not supported on this platform")
+}
+
+func removeAll(path string) error {
+	return os.RemoveAll(path)
+}
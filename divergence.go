@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// checkDivergence rewrites the upstream tags of the published tags of plan
+// again and compares the trees with the published ones, which differ when
+// the target was changed by hand or the rewrite flags changed. The diverged
+// tags are recorded in the summary and logged with --tag-divergence=report,
+// returned as an error wrapping ErrTagDiverged with fail, and returned by
+// name to publish again with force.
+func checkDivergence(ctx context.Context, r *gogit.Repository, plan []*tagInfo, summary *runSummary) (map[string]plumbing.Hash, error) {
+	if *tagDivergence == "off" {
+		return nil, nil
+	}
+	republish := map[string]plumbing.Hash{}
+	for _, t := range plan {
+		if t.Status != statusPublished || ctx.Err() != nil {
+			continue
+		}
+		files, err := divergedFiles(ctx, r, t)
+		if err != nil {
+			logrus.Warnf("Failed to check %s for divergence: %v", t.Published, err)
+			continue
+		}
+		if len(files) == 0 {
+			continue
+		}
+		logrus.Warnf("Published tag %s diverged from the rewrite of %s, was the target changed by hand? Differs: %s", t.Published, t.Name, strings.Join(files, ", "))
+		summary.Diverged = append(summary.Diverged, t.Published)
+		if *tagDivergence == "force" {
+			republish[t.Name] = plumbing.NewHash(t.Hash)
+		}
+	}
+	if len(summary.Diverged) == 0 {
+		return nil, nil
+	}
+	switch *tagDivergence {
+	case "fail":
+		return nil, fmt.Errorf("%w: %s", ErrTagDiverged, strings.Join(summary.Diverged, ", "))
+	case "force":
+		logrus.Warnf("Publishing the diverged tags again: %s", strings.Join(summary.Diverged, ", "))
+	}
+	return republish, nil
+}
+
+// divergedFiles returns the files of the published tag of t that differ from
+// a fresh rewrite of its upstream tag, none when they match. The rewrite
+// retracts the deleted upstream tags the published go.mod retracts, a tag
+// deleted since doesn't make every published tag diverge.
+func divergedFiles(ctx context.Context, r *gogit.Repository, t *tagInfo) ([]string, error) {
+	want, err := tagCommit(r, plumbing.NewHash(t.PublishedHash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit of %s: %v", t.Published, err)
+	}
+	var retracted []string
+	if *propagateRetract {
+		if retracted, err = publishedRetractions(want); err != nil {
+			return nil, fmt.Errorf("failed to read the retractions of %s: %v", t.Published, err)
+		}
+	}
+	got, remove, err := rebuildTag(ctx, t.Name, plumbing.NewHash(t.Hash), want, retracted)
+	if err != nil {
+		return nil, err
+	}
+	defer remove()
+	wantTree, err := publishedTree(want)
+	if err != nil {
+		return nil, err
+	}
+	gotTree, err := got.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if wantTree.Hash == gotTree.Hash {
+		return nil, nil
+	}
+	changes, err := object.DiffTree(wantTree, gotTree)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, c := range changes {
+		name := c.To.Name
+		if name == "" {
+			name = c.From.Name
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
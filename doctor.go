@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// free disk space below which doctor warns, a Kubernetes clone with a
+// checkout and a module cache takes about this much
+const minFreeDisk = 10 << 30
+
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "OK"
+	checkWarn checkStatus = "WARN"
+	checkFail checkStatus = "FAIL"
+)
+
+type checkResult struct {
+	status checkStatus
+	detail string
+	hint   string
+}
+
+type check struct {
+	name string
+	run  func() checkResult
+}
+
+func runDoctor(ctx context.Context) error {
+	checks := []check{
+		{"git", checkGit},
+		{"go", checkGo},
+		{"source remote", func() checkResult { return checkRemote(sourceRemote, "source-repo", *sourceRepo) }},
+	}
+	for _, u := range sourceURLs()[1:] {
+		checks = append(checks, check{"source mirror " + redactURL(u), func() checkResult { return checkRemote(sourceRemote, "source-mirrors", u) }})
+	}
+	checks = append(checks, []check{
+		{"target remote", func() checkResult { return checkRemote(targetRemote, "target-repo", *targetRepo) }},
+		{"GitHub API", func() checkResult { return checkGitHubAPI(ctx) }},
+		{"module proxy", checkModProxy},
+		{"govulncheck", checkGovulncheck},
+		{"workdir", checkWorkdir},
+		{"disk space", checkDisk},
+	}...)
+	failed := 0
+	for _, c := range checks {
+		res := c.run()
+		fmt.Printf("[%-4s] %s: %s\n", res.status, c.name, res.detail)
+		if res.hint != "" {
+			fmt.Printf("       %s\n", res.hint)
+		}
+		if res.status == checkFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return withExitCode(exitEnvironment, fmt.Errorf("%d of %d checks failed", failed, len(checks)))
+	}
+	return nil
+}
+
+func checkGit() checkResult {
+	out, err := exec.Command("git", "version").Output()
+	if err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to run git: %v", err), "Install git and make sure it is in PATH, it is used to clone the workdir"}
+	}
+	return checkResult{checkOK, strings.TrimSpace(string(out)), ""}
+}
+
+func checkGo() checkResult {
+	out, err := exec.Command("go", "env", "-json", "GOVERSION", "GOTOOLCHAIN", "GOMODCACHE").Output()
+	if err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to run go: %v", err), "Install Go and make sure it is in PATH, it is used to tidy go.mod"}
+	}
+	var env struct{ GOVERSION, GOTOOLCHAIN, GOMODCACHE string }
+	if err = json.Unmarshal(out, &env); err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to parse go env: %v", err), ""}
+	}
+	detail := fmt.Sprintf("%s, GOTOOLCHAIN=%s, GOMODCACHE=%s", env.GOVERSION, env.GOTOOLCHAIN, env.GOMODCACHE)
+	// go 1.21 is the first version switching toolchains for newer go.mod files
+	if semver.Compare("v"+strings.TrimPrefix(env.GOVERSION, "go"), "v1.21.0") < 0 {
+		return checkResult{checkFail, detail, "Upgrade Go to 1.21 or later, older versions can't tidy go.mod files of recent Kubernetes releases"}
+	}
+	if env.GOTOOLCHAIN == "local" {
+		return checkResult{checkWarn, detail, "GOTOOLCHAIN=local prevents switching to the toolchain required by newer tags"}
+	}
+	return checkResult{checkOK, detail, ""}
+}
+
+func checkRemote(name, flagName, url string) checkResult {
+	if url == "" {
+		return checkResult{checkFail, "URL is empty", "Set it with --" + flagName}
+	}
+	auth, err := remoteAuth(name)
+	if err != nil {
+		return checkResult{checkFail, err.Error(), "Check --target-token-file and --ssh-key-file"}
+	}
+	rm := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: name, URLs: []string{url}})
+	refs, err := rm.List(&gogit.ListOptions{Auth: auth})
+	if err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to list %s: %v", url, err), "Check network access to the remote and the credentials for it"}
+	}
+	tags := 0
+	for _, ref := range refs {
+		if ref.Name().IsTag() {
+			tags++
+		}
+	}
+	return checkResult{checkOK, fmt.Sprintf("%s is reachable, %d refs, %d tags", url, len(refs), tags), ""}
+}
+
+func checkGitHubAPI(ctx context.Context) checkResult {
+	g := getGitHubAPI()
+	if g == nil {
+		return checkResult{checkOK, "not used, the target is not on GitHub", ""}
+	}
+	remaining, limit, reset, err := g.rateLimit(ctx)
+	if err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to get the rate limit of %s: %v", g.baseURL, err), "Check network access to the API and the target token"}
+	}
+	detail := fmt.Sprintf("%d of %d requests left until %s", remaining, limit, reset.Format(time.RFC3339))
+	if remaining < limit/10 {
+		return checkResult{checkWarn, detail, "Releases and other API calls wait for the reset, or are deferred to the next run"}
+	}
+	return checkResult{checkOK, detail, ""}
+}
+
+func checkModProxy() checkResult {
+	p, err := newModProxy()
+	if err != nil {
+		return checkResult{checkFail, err.Error(), "Check GOPROXY and GOSUMDB, go mod tidy needs them to resolve dependencies"}
+	}
+	if _, err = httpGet(p.proxyURL + "/golang.org/x/mod/@v/list"); err != nil {
+		return checkResult{checkFail, fmt.Sprintf("%s is unreachable: %v", p.proxyURL, err), "Check network access to GOPROXY"}
+	}
+	if _, err = p.Sums(module.Version{Path: "golang.org/x/mod", Version: "v0.21.0"}); err != nil {
+		return checkResult{checkFail, fmt.Sprintf("%s is unreachable: %v", p.sumdbURL, err), "Check network access to GOSUMDB"}
+	}
+	return checkResult{checkOK, fmt.Sprintf("%s and %s are reachable", p.proxyURL, p.sumdbURL), ""}
+}
+
+func checkGovulncheck() checkResult {
+	if !*vulnCheck {
+		return checkResult{checkOK, "not used without --vuln-check", ""}
+	}
+	out, err := exec.Command("govulncheck", "-version").Output()
+	if err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to run govulncheck: %v", err), "Install it with go install golang.org/x/vuln/cmd/govulncheck@latest"}
+	}
+	for _, l := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(l, "Scanner: "); ok {
+			return checkResult{checkOK, v, ""}
+		}
+	}
+	return checkResult{checkOK, "installed", ""}
+}
+
+func checkWorkdir() checkResult {
+	if _, err := os.Stat(*workdir); errors.Is(err, os.ErrNotExist) {
+		return checkResult{checkOK, fmt.Sprintf("%s doesn't exist yet, it will be cloned", *workdir), ""}
+	}
+	r, err := gogit.PlainOpen(*workdir)
+	if errors.Is(err, gogit.ErrRepositoryNotExists) {
+		return checkResult{checkOK, fmt.Sprintf("%s is not a repository yet, it will be cloned", *workdir), ""}
+	}
+	if err != nil {
+		return checkResult{checkFail, fmt.Sprintf("failed to open %s: %v", *workdir, err), "Remove the workdir to start from a fresh clone"}
+	}
+	w, err := r.Worktree()
+	bare := errors.Is(err, gogit.ErrIsBareRepository)
+	if bare != *bareWorkdir {
+		return checkResult{checkFail, fmt.Sprintf("%s doesn't match --bare-workdir=%t", *workdir, *bareWorkdir), "Remove the workdir to start from a fresh clone"}
+	}
+	if err != nil && !bare {
+		return checkResult{checkFail, fmt.Sprintf("failed to get worktree: %v", err), ""}
+	}
+	head, err := r.Head()
+	if err != nil {
+		return checkResult{checkWarn, fmt.Sprintf("%s has no HEAD: %v", *workdir, err), ""}
+	}
+	if bare {
+		return checkResult{checkOK, fmt.Sprintf("%s is a bare repository at %s", *workdir, head.Hash()), ""}
+	}
+	return checkResult{checkOK, fmt.Sprintf("%s is a repository at %s", w.Filesystem.Root(), head.Hash()), ""}
+}
+
+func checkDisk() checkResult {
+	dir, err := filepath.Abs(*workdir)
+	if err != nil {
+		return checkResult{checkFail, err.Error(), ""}
+	}
+	// the workdir may not exist yet
+	for {
+		if _, err = os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		return checkResult{checkWarn, fmt.Sprintf("failed to get free space of %s: %v", dir, err), ""}
+	}
+	detail := fmt.Sprintf("%.1f GiB free at %s", float64(free)/(1<<30), dir)
+	if free < minFreeDisk {
+		return checkResult{checkWarn, detail, fmt.Sprintf("At least %d GiB are recommended for the clone, checkouts and module cache", minFreeDisk>>30)}
+	}
+	return checkResult{checkOK, detail, ""}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/modfile"
+)
+
+// checkTidyDrift compares the requires of the tidied go.mod in dir with the
+// ones of the rewritten go.mod before tidy, and reports the modules tidy
+// added or changed that don't match --tidy-drift-allow: logged with
+// --tidy-drift=warn, failing the tag with fail.
+func checkTidyDrift(dir, tag string, before []byte) error {
+	if *tidyDrift == "off" {
+		return nil
+	}
+	pre, err := modfile.ParseLax("go.mod", before, nil)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return fmt.Errorf("failed to read tidied go.mod: %v", err)
+	}
+	post, err := modfile.ParseLax("go.mod", b, nil)
+	if err != nil {
+		return err
+	}
+	required := map[string]string{}
+	for _, r := range pre.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	var drift []string
+	for _, r := range post.Require {
+		v, ok := required[r.Mod.Path]
+		if v == r.Mod.Version || driftAllowed(r.Mod.Path) {
+			continue
+		}
+		if ok {
+			drift = append(drift, fmt.Sprintf("%s %s -> %s", r.Mod.Path, v, r.Mod.Version))
+		} else {
+			drift = append(drift, fmt.Sprintf("%s %s added", r.Mod.Path, r.Mod.Version))
+		}
+	}
+	if len(drift) == 0 {
+		return nil
+	}
+	module := "go.mod"
+	if post.Module != nil {
+		module = post.Module.Mod.Path
+	}
+	if *tidyDrift == "warn" {
+		logrus.Warnf("Tidy changed the requires of %s in %s: %s", module, tag, strings.Join(drift, ", "))
+		return nil
+	}
+	return fmt.Errorf("tidy changed the requires of %s beyond --tidy-drift-allow: %s", module, strings.Join(drift, ", "))
+}
+
+// driftAllowed returns whether tidy may add or change the require of modPath.
+func driftAllowed(modPath string) bool {
+	for _, pattern := range strings.Split(*tidyDriftAllow, ",") {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), modPath); ok {
+			return true
+		}
+	}
+	return false
+}
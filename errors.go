@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// ErrTagAlreadyPublished is the cause of a failed push of a tag that exists
+// on the target with another commit, like one pushed by a concurrent run.
+// Like ErrAuth and ErrTidyFailed, it is kept through the handling of a tag by
+// wrapping with %w, so callers branch on errors.Is and errors.As rather than
+// on messages.
+var ErrTagAlreadyPublished = errors.New("tag already published")
+
+// ErrTagDiverged is the cause of a run failed with --tag-divergence=fail, a
+// published tag whose tree differs from a fresh rewrite of its upstream tag.
+var ErrTagDiverged = errors.New("published tags diverged from the rewrite")
+
+// ErrTidyFailed is a failed go mod tidy of a rewritten go.mod, with its
+// output.
+type ErrTidyFailed struct {
+	Output string
+	Err    error
+}
+
+func (e *ErrTidyFailed) Error() string {
+	return fmt.Sprintf("failed to tidy go.mod: %v: %s", e.Err, e.Output)
+}
+
+func (e *ErrTidyFailed) Unwrap() error { return e.Err }
+
+// takenRefs returns the refs of a failed push of refSpecs from r that the
+// target has with another object than the local ones, which the push without
+// force couldn't update. They are read from the target rather than from the
+// error, whose words differ between go-git, git and the hooks of the target,
+// which can decline a new ref for other reasons.
+func takenRefs(ctx context.Context, r *gogit.Repository, refSpecs []config.RefSpec) ([]string, error) {
+	auth, err := getAuth()
+	if err != nil {
+		return nil, err
+	}
+	rm := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: targetRemote, URLs: []string{*targetRepo}})
+	refs, err := rm.ListContext(ctx, &gogit.ListOptions{Auth: auth, ProxyOptions: transferProxy(targetRemote)})
+	if err != nil {
+		return nil, err
+	}
+	remote := map[plumbing.ReferenceName]plumbing.Hash{}
+	for _, ref := range refs {
+		remote[ref.Name()] = ref.Hash()
+	}
+	var taken []string
+	for _, rs := range refSpecs {
+		if rs.IsForceUpdate() || rs.IsDelete() {
+			continue
+		}
+		h, ok := remote[rs.Dst("")]
+		if !ok {
+			continue
+		}
+		local, err := r.Reference(plumbing.ReferenceName(rs.Src()), true)
+		if err != nil {
+			return nil, err
+		}
+		if local.Hash() != h {
+			taken = append(taken, rs.Dst("").String())
+		}
+	}
+	return taken, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/sirupsen/logrus"
+)
+
+// exit codes, documented in the README for wrapping scripts
+const (
+	exitFailure     = 1
+	exitConfig      = 2
+	exitNothingToDo = 3
+	exitPartial     = 4
+	exitAuth        = 5
+	exitEnvironment = 6
+)
+
+var errNothingToDo = errors.New("nothing to do, all upstream tags are published or skipped")
+
+// ErrAuth matches the errors with exitAuth, credentials that couldn't be read
+// or that the target rejected.
+var ErrAuth = errors.New("authentication failed")
+
+// exitError is an error with the exit code it should end kksyncer with.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// Is reports the errors with exitAuth as ErrAuth.
+func (e *exitError) Is(target error) bool { return target == ErrAuth && e.code == exitAuth }
+
+// withExitCode returns err with the exit code, or nil if err is nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code, err}
+}
+
+// exitCode returns the exit code of the error of a command: the one given
+// with withExitCode, exitAuth for credentials the remote rejected, or
+// exitFailure.
+func exitCode(err error) int {
+	var ee *exitError
+	switch {
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.Is(err, transport.ErrAuthenticationRequired), errors.Is(err, transport.ErrAuthorizationFailed):
+		return exitAuth
+	}
+	return exitFailure
+}
+
+// fatalf logs the error and exits with code.
+func fatalf(code int, format string, args ...any) {
+	fatal(withExitCode(code, fmt.Errorf(format, args...)))
+}
+
+// fatal logs err and exits with its exit code.
+func fatal(err error) {
+	code := exitCode(err)
+	if code == exitNothingToDo {
+		logrus.Info(err)
+	} else {
+		logrus.Error(err)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sourceURLs returns --source-repo and the --source-mirrors, in the order
+// they are tried.
+func sourceURLs() []string {
+	urls := []string{*sourceRepo}
+	for _, u := range strings.Split(*sourceMirrors, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// withSourceFailover calls fetch with each of the sourceURLs until it
+// succeeds, and returns the error of the last one. The upstream tags and
+// their commits are the same on every mirror, so a fetch can continue from
+// what another one got. No other URL is tried once ctx is canceled.
+func withSourceFailover(ctx context.Context, what string, fetch func(url string) error) error {
+	urls := sourceURLs()
+	var err error
+	for i, u := range urls {
+		if err = fetch(u); err == nil || ctx.Err() != nil {
+			return err
+		}
+		if i+1 < len(urls) {
+			logrus.Warnf("Failed to %s from %s, trying %s: %v", what, redactURL(u), redactURL(urls[i+1]), err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/sirupsen/logrus"
+)
+
+// fetchPatterns returns the patterns of --fetch-tags, or nil to fetch all
+// tags.
+func fetchPatterns() []string {
+	var patterns []string
+	for _, p := range strings.Split(*fetchTags, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// checkFetchPatterns returns an error if a pattern of --fetch-tags can't be
+// used in a refspec.
+func checkFetchPatterns() error {
+	for _, p := range fetchPatterns() {
+		if strings.Count(p, "*") != 1 || strings.ContainsAny(p, ": ^~?[\\") {
+			return fmt.Errorf("invalid --fetch-tags pattern %q, want a tag name with one *, like v1.*", p)
+		}
+	}
+	return nil
+}
+
+// fetchRefSpecs returns the refspecs fetching the tags of remote name into
+// refs/tags/<name>/, the ones matching --fetch-tags if set. The published
+// tags of the target match the patterns with -mod added.
+func fetchRefSpecs(name string) []config.RefSpec {
+	patterns := fetchPatterns()
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+	var refSpecs []config.RefSpec
+	for _, p := range patterns {
+		if name == targetRemote && !strings.HasSuffix(p, "*") {
+			p += "-mod"
+		}
+		refSpecs = append(refSpecs, config.RefSpec(remoteRefPrefix(name)+p+":refs/tags/"+name+"/"+p))
+	}
+	return append(refSpecs, extraRefSpecs(name)...)
+}
+
+// extraRefSpecs returns the --fetch-refspecs of the upstream, or the
+// --target-fetch-refspecs of the target.
+func extraRefSpecs(name string) []config.RefSpec {
+	flagValue := *fetchRefSpecList
+	if name == targetRemote {
+		flagValue = *targetRefSpecs
+	}
+	var refSpecs []config.RefSpec
+	for _, s := range strings.Split(flagValue, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			refSpecs = append(refSpecs, config.RefSpec(s))
+		}
+	}
+	return refSpecs
+}
+
+// checkExtraRefSpecs returns an error if a refspec of --fetch-refspecs or
+// --target-fetch-refspecs is invalid, or would write to the tags the
+// upstream and published tags are fetched to.
+func checkExtraRefSpecs() error {
+	for _, name := range []string{sourceRemote, targetRemote} {
+		for _, rs := range extraRefSpecs(name) {
+			if err := rs.Validate(); err != nil || rs.IsDelete() {
+				return fmt.Errorf("invalid refspec %q, want [+]<src>:<dst> like +refs/heads/*:refs/remotes/%s/*", rs, name)
+			}
+			if _, dst, _ := strings.Cut(string(rs), ":"); strings.HasPrefix(dst, "refs/tags/") {
+				return fmt.Errorf("invalid refspec %q, the destinations under refs/tags/ are kksyncer's", rs)
+			}
+		}
+	}
+	return nil
+}
+
+// initWorkdir creates an empty workdir in dir and fetches the upstream tags
+// of --fetch-tags into it with git, whose protocol v2 only asks the upstream
+// for the matching refs, unlike a clone of everything. With
+// --max-transfer-rate they are left to the throttled fetch of openWorkdir.
+func initWorkdir(ctx context.Context, dir string) error {
+	args := []string{"init", "--quiet"}
+	if *bareWorkdir {
+		args = append(args, "--bare")
+	}
+	if out, err := commandContext(ctx, "git", append(args, dir)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to init %s: %v: %s", dir, err, out)
+	}
+	if *maxTransferRate != "" {
+		return nil
+	}
+	logrus.Infof("Fetching the upstream tags matching %s to %s", *fetchTags, dir)
+	pw := newProgress("clone")
+	verbosity := "--quiet"
+	if pw != nil {
+		verbosity = "--progress"
+	}
+	return withSourceFailover(ctx, "fetch", func(url string) error {
+		args := []string{"-C", dir, "-c", "protocol.version=2", "fetch", "--no-tags", verbosity, url}
+		for _, rs := range fetchRefSpecs(sourceRemote) {
+			args = append(args, string(rs))
+		}
+		cmd := commandContext(ctx, "git", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if pw != nil {
+			cmd.Stderr = pw
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to fetch %s: %v", url, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// tagFilter decides which upstream tags are handled.
+//
+// Tags in the denylist are always skipped. If there is an allowlist, only the
+// tags in it are handled, regardless of the other filters.
+type tagFilter struct {
+	allow, deny map[string]bool
+	versions    versionRange
+}
+
+func newTagFilter() (*tagFilter, error) {
+	f := &tagFilter{}
+	var err error
+	if *allowlistFile != "" {
+		if f.allow, err = readTagList(*allowlistFile); err != nil {
+			return nil, fmt.Errorf("failed to read tags allowlist: %v", err)
+		}
+	}
+	if *denylistFile != "" {
+		if f.deny, err = readTagList(*denylistFile); err != nil {
+			return nil, fmt.Errorf("failed to read tags denylist: %v", err)
+		}
+	}
+	if f.versions, err = parseVersionRange(*versionRangeExpr); err != nil {
+		return nil, fmt.Errorf("invalid version range: %v", err)
+	}
+	return f, nil
+}
+
+// skipReason returns why the tag should be skipped, or an empty string if it
+// should be handled.
+func (f *tagFilter) skipReason(name string, annotated bool) string {
+	if f.deny[name] {
+		return "in denylist"
+	}
+	if f.allow != nil {
+		if !f.allow[name] {
+			return "not in allowlist"
+		}
+		return ""
+	}
+	// ignore non-annotated tags
+	// this logic is from publishing-bot
+	if !*lightTags && !annotated {
+		return "not an annotated tag"
+	}
+	if !semver.IsValid(name) {
+		return "not a semantic version"
+	}
+	if min := activeProfile.MinVersion; min != "" && semver.Compare(name, min) < 0 {
+		return "older than " + min
+	}
+	if !f.versions.match(name) {
+		return "outside version range " + *versionRangeExpr
+	}
+	return ""
+}
+
+// readTagList reads a file with a tag name per line. Empty lines and lines
+// starting with # are ignored.
+func readTagList(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tags := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			tags[line] = true
+		}
+	}
+	return tags, sc.Err()
+}
+
+type versionConstraint struct {
+	op, version string
+}
+
+// versionRange is a semver range like ">=1.28.0 <1.31.0 || 1.32.1". Space
+// separated constraints must all match, || separates alternatives. An empty
+// range matches everything.
+type versionRange [][]versionConstraint
+
+func parseVersionRange(s string) (versionRange, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var r versionRange
+	for _, alt := range strings.Split(s, "||") {
+		// allow a space between the operator and the version
+		fields := strings.Fields(alt)
+		var group []versionConstraint
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if strings.TrimLeft(field, "<>=!") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			version := strings.TrimLeft(field, "<>=!")
+			c := versionConstraint{op: field[:len(field)-len(version)], version: "v" + strings.TrimPrefix(version, "v")}
+			switch c.op {
+			case "":
+				c.op = "="
+			case "==":
+				c.op = "="
+			case "=", "!=", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator %q in %q", c.op, field)
+			}
+			if !semver.IsValid(c.version) {
+				return nil, fmt.Errorf("invalid version %q", version)
+			}
+			group = append(group, c)
+		}
+		if len(group) == 0 {
+			return nil, fmt.Errorf("empty alternative in %q", s)
+		}
+		r = append(r, group)
+	}
+	return r, nil
+}
+
+func (r versionRange) match(v string) bool {
+	if r == nil {
+		return true
+	}
+	for _, group := range r {
+		ok := true
+		for _, c := range group {
+			if !c.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c versionConstraint) match(v string) bool {
+	cmp := semver.Compare(v, c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
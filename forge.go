@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// forge is the kind of git host of the target, for its auth quirks, limits
+// and API.
+type forge string
+
+const (
+	forgeGeneric   forge = "generic"
+	forgeGitHub    forge = "github"
+	forgeGitee     forge = "gitee"
+	forgeAzure     forge = "azure-devops"
+	forgeBitbucket forge = "bitbucket"
+)
+
+// targetForge returns the forge of --target-repo from its host.
+func targetForge() forge {
+	host := targetHost()
+	switch {
+	case host == "github.com" || strings.HasPrefix(host, "github."):
+		return forgeGitHub
+	case host == "gitee.com":
+		return forgeGitee
+	case host == "dev.azure.com" || host == "ssh.dev.azure.com" || strings.HasSuffix(host, ".visualstudio.com"):
+		return forgeAzure
+	case host == "bitbucket.org" || strings.HasPrefix(host, "bitbucket."):
+		return forgeBitbucket
+	}
+	return forgeGeneric
+}
+
+// the capabilities go-git filters out by default, restored by setupForge for a
+// config reloaded with another target
+var defaultUnsupportedCaps = transport.UnsupportedCapabilities
+
+// setupForge works around the quirks of the target forge.
+func setupForge() {
+	transport.UnsupportedCapabilities = defaultUnsupportedCaps
+	if targetForge() == forgeAzure {
+		// Azure DevOps only serves clients with multi_ack, which go-git
+		// filters out by default
+		transport.UnsupportedCapabilities = []capability.Capability{capability.ThinPack}
+	}
+}
+
+// tokenUsername returns the username to send with the target token. Azure
+// DevOps takes any username with a PAT, Bitbucket Cloud wants x-token-auth
+// with repository and workspace access tokens, and Bitbucket Data Center the
+// name of the account of its HTTP access token.
+func tokenUsername() string {
+	if *targetUsername != "" {
+		return *targetUsername
+	}
+	if targetHost() == "bitbucket.org" {
+		return "x-token-auth"
+	}
+	return "x-access-token"
+}
+
+// targetHost returns the host of --target-repo.
+func targetHost() string {
+	return repoHost(*targetRepo)
+}
+
+// targetRepoPath returns the owner/repo of --target-repo.
+func targetRepoPath() string {
+	return repoPath(*targetRepo)
+}
+
+// repoHost returns the host of repo, for both URLs and scp-like SSH addresses
+// like git@gitee.com:you/kubernetes.git.
+func repoHost(repo string) string {
+	if u, err := url.Parse(repo); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	host, _, _ := strings.Cut(repo, ":")
+	if _, h, ok := strings.Cut(host, "@"); ok {
+		host = h
+	}
+	return host
+}
+
+// repoPath returns the owner/repo of repo.
+func repoPath(repo string) string {
+	p := repo
+	if u, err := url.Parse(p); err == nil && u.Host != "" {
+		p = u.Path
+	} else if _, rest, ok := strings.Cut(p, ":"); ok {
+		p = rest
+	}
+	return strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	gohttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// Gitee rejects pushes with files above 50 MiB on free plans
+const giteeMaxFileSize = 50 << 20
+
+// giteeAPI calls the v5 API of gitee.com with the target token.
+type giteeAPI struct{}
+
+// createRelease creates a release of tag on the target.
+func (giteeAPI) createRelease(ctx context.Context, tag, target, body string, assets []releaseAsset) error {
+	auth, err := getAuth()
+	if err != nil {
+		return err
+	}
+	basic, ok := auth.(*gohttp.BasicAuth)
+	if !ok {
+		return fmt.Errorf("the Gitee API needs --target-token-file or --target-token-source")
+	}
+	payload, err := json.Marshal(map[string]any{
+		"access_token":     basic.Password,
+		"tag_name":         tag,
+		"name":             tag,
+		"body":             body,
+		"target_commitish": target,
+	})
+	if err != nil {
+		return err
+	}
+	u := "https://gitee.com/api/v5/repos/" + targetRepoPath() + "/releases"
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err = giteePost(ctx, u, "application/json", payload, &created); err != nil {
+		return err
+	}
+	for _, a := range assets {
+		var form bytes.Buffer
+		mw := multipart.NewWriter(&form)
+		_ = mw.WriteField("access_token", basic.Password)
+		fw, err := mw.CreateFormFile("file", a.name)
+		if err != nil {
+			return err
+		}
+		_, _ = fw.Write(a.data)
+		if err = mw.Close(); err != nil {
+			return err
+		}
+		if err = giteePost(ctx, fmt.Sprintf("%s/%d/attach_files", u, created.ID), mw.FormDataContentType(), form.Bytes(), nil); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", a.name, err)
+		}
+	}
+	return nil
+}
+
+// giteePost posts payload of contentType to u and decodes the response into
+// out, which may be nil.
+func giteePost(ctx context.Context, u, contentType string, payload []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s: %s", u, resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+// checkFileSizes fails if the tree of commit has files above the file size
+// limit of the target, which would otherwise be rejected late in the push.
+func checkFileSizes(r *gogit.Repository, commit plumbing.Hash) error {
+	if targetForge() != forgeGitee {
+		return nil
+	}
+	c, err := r.CommitObject(commit)
+	if err != nil {
+		return err
+	}
+	files, err := c.Files()
+	if err != nil {
+		return err
+	}
+	var large []string
+	err = files.ForEach(func(f *object.File) error {
+		if f.Size > giteeMaxFileSize {
+			large = append(large, fmt.Sprintf("%s (%d MiB)", f.Name, f.Size>>20))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(large) > 0 {
+		return fmt.Errorf("files above the %d MiB limit of Gitee, prune them with --prune-paths: %s", giteeMaxFileSize>>20, strings.Join(large, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gohttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/sirupsen/logrus"
+)
+
+// longest wait for the GitHub rate limit to reset, callers defer their work
+// to the next run on errRateLimited instead of blocking longer
+const maxRateLimitWait = 15 * time.Minute
+
+// errRateLimited is returned by githubAPI when the rate limit resets later
+// than maxRateLimitWait.
+var errRateLimited = errors.New("GitHub API rate limit exceeded")
+
+// githubAPI calls the REST API of a GitHub or GitHub Enterprise target with
+// the target token, tracking the rate limit headers to wait for the reset
+// instead of failing when the quota runs out.
+type githubAPI struct {
+	baseURL string
+	// without the target token, for the API of the upstream
+	anonymous bool
+
+	mu        sync.Mutex
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+var (
+	githubOnce     sync.Once
+	githubClient   *githubAPI
+	upstreamOnce   sync.Once
+	upstreamClient *githubAPI
+)
+
+// getGitHubAPI returns the API of --target-repo, or nil if it is not on
+// github.com or a host named like github.example.com.
+func getGitHubAPI() *githubAPI {
+	githubOnce.Do(func() {
+		if targetForge() != forgeGitHub || !strings.HasPrefix(*targetRepo, "http") {
+			return
+		}
+		githubClient = &githubAPI{baseURL: githubBaseURL(targetHost()), remaining: -1}
+	})
+	return githubClient
+}
+
+// getUpstreamAPI returns the API of --source-repo, or nil if it is not on
+// GitHub. The target token is only sent to the host of the target.
+func getUpstreamAPI() *githubAPI {
+	upstreamOnce.Do(func() {
+		host := repoHost(*sourceRepo)
+		if host != "github.com" && !strings.HasPrefix(host, "github.") || !strings.HasPrefix(*sourceRepo, "http") {
+			return
+		}
+		upstreamClient = &githubAPI{baseURL: githubBaseURL(host), anonymous: host != targetHost(), remaining: -1}
+	})
+	return upstreamClient
+}
+
+// githubBaseURL returns the API URL of github.com or a GitHub Enterprise
+// host.
+func githubBaseURL(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com"
+	}
+	return "https://" + host + "/api/v3"
+}
+
+// do sends a request with body as JSON and decodes the response into out,
+// either may be nil. A request hitting the rate limit is retried once after
+// the reset.
+func (g *githubAPI) do(ctx context.Context, method, path string, body, out any) error {
+	if body == nil {
+		return g.send(ctx, method, g.baseURL+path, "", nil, out)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return g.send(ctx, method, g.baseURL+path, "application/json", b, out)
+}
+
+// send sends a request with payload of contentType to u, which is outside of
+// the API for uploads, and decodes the response into out like do.
+func (g *githubAPI) send(ctx context.Context, method, u, contentType string, payload []byte, out any) error {
+	path := strings.TrimPrefix(u, g.baseURL)
+	for attempt := 0; ; attempt++ {
+		if err := g.wait(ctx); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if !g.anonymous {
+			auth, err := getAuth()
+			if err != nil {
+				return err
+			}
+			if basic, ok := auth.(*gohttp.BasicAuth); ok {
+				req.Header.Set("Authorization", "Bearer "+basic.Password)
+			}
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		limited := g.update(resp)
+		if limited && attempt == 0 {
+			continue
+		}
+		if resp.StatusCode/100 != 2 {
+			if limited {
+				return fmt.Errorf("%w: %s %s", errRateLimited, method, path)
+			}
+			return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+		}
+		if out == nil || len(b) == 0 {
+			return nil
+		}
+		return json.Unmarshal(b, out)
+	}
+}
+
+// update records the rate limit headers of resp, and returns whether resp
+// was rejected by the primary or secondary rate limit.
+func (g *githubAPI) update(resp *http.Response) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if v, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		g.limit = v
+	}
+	if v, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		g.remaining = v
+	}
+	if v, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		g.reset = time.Unix(v, 0)
+	}
+	logrus.WithFields(logrus.Fields{"remaining": g.remaining, "limit": g.limit, "reset": g.reset.Format(time.RFC3339)}).Debug("GitHub API rate limit")
+	if g.limit > 0 && g.remaining >= 0 && g.remaining < g.limit/10 {
+		logrus.Warnf("Only %d of %d GitHub API requests left until %s", g.remaining, g.limit, g.reset.Format(time.RFC3339))
+	}
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	// the secondary rate limit asks to retry after a number of seconds
+	if v, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		g.remaining, g.reset = 0, time.Now().Add(time.Duration(v)*time.Second)
+		return true
+	}
+	return g.remaining == 0
+}
+
+// wait sleeps until the rate limit resets if the quota ran out, or returns
+// errRateLimited if that is too far away.
+func (g *githubAPI) wait(ctx context.Context) error {
+	g.mu.Lock()
+	remaining, reset := g.remaining, g.reset
+	g.mu.Unlock()
+	d := time.Until(reset)
+	if remaining != 0 || d <= 0 {
+		return nil
+	}
+	if d > maxRateLimitWait {
+		return fmt.Errorf("%w until %s", errRateLimited, reset.Format(time.RFC3339))
+	}
+	logrus.Warnf("GitHub API rate limit exceeded, waiting %s for the reset", d.Round(time.Second))
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimit returns the remaining and total requests of the current window.
+func (g *githubAPI) rateLimit(ctx context.Context) (int, int, time.Time, error) {
+	var res struct {
+		Resources struct {
+			Core struct {
+				Limit     int   `json:"limit"`
+				Remaining int   `json:"remaining"`
+				Reset     int64 `json:"reset"`
+			} `json:"core"`
+		} `json:"resources"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/rate_limit", nil, &res); err != nil {
+		return 0, 0, time.Time{}, err
+	}
+	core := res.Resources.Core
+	return core.Remaining, core.Limit, time.Unix(core.Reset, 0), nil
+}
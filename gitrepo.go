@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// gitRepo is the git operations of a sync on a repository: fetching and
+// listing the tags of the remotes, and checking out, committing, tagging and
+// pushing the rewrites. The workdir and the workers use goGitRepo, a
+// repository in memory from newMemoryRepo runs the sync logic without a disk.
+type gitRepo interface {
+	// fetch fetches the tags of remote from url into refs/tags/<remote>/
+	fetch(ctx context.Context, remote, url string) error
+	// tags returns the fetched tags of remote by name
+	tags(remote string) (map[string]plumbing.Hash, error)
+	// checkout checks out commit h in the worktree
+	checkout(h plumbing.Hash) error
+	// commit commits the staged changes of the worktree
+	commit(msg string, opts *gogit.CommitOptions) (plumbing.Hash, error)
+	// tag creates the tag name of commit h, annotated with opts if not nil
+	tag(name string, h plumbing.Hash, opts *gogit.CreateTagOptions) error
+	// push pushes refSpecs to the target, logging the progress as name
+	push(ctx context.Context, name string, refSpecs []config.RefSpec) error
+}
+
+// goGitRepo is the gitRepo of a go-git repository.
+type goGitRepo struct {
+	r *gogit.Repository
+}
+
+// newMemoryRepo returns a gitRepo with its objects in memory and its
+// worktree on a memfs, with the remotes of the upstream and the target.
+func newMemoryRepo() (gitRepo, error) {
+	r, err := gogit.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{sourceRemote, targetRemote} {
+		if err = ensureRemote(r, name, remoteURL(name)); err != nil {
+			return nil, err
+		}
+	}
+	return goGitRepo{r}, nil
+}
+
+func (g goGitRepo) fetch(ctx context.Context, remote, url string) error {
+	auth, err := remoteAuth(remote)
+	if err != nil {
+		return err
+	}
+	err = g.r.FetchContext(ctx, &gogit.FetchOptions{
+		RemoteName: remote,
+		RemoteURL:  url,
+		Auth:       auth,
+		Prune:      true,
+		Progress:   newProgress("fetch " + remote),
+		RefSpecs:   fetchRefSpecs(remote),
+		// only the tags of the refspecs, not the other tags of their
+		// history
+		Tags:         gogit.NoTags,
+		ProxyOptions: transferProxyURL(url),
+	})
+	if errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
+
+func (g goGitRepo) tags(remote string) (map[string]plumbing.Hash, error) {
+	return remoteTags(g.r, remote)
+}
+
+func (g goGitRepo) checkout(h plumbing.Hash) error {
+	w, err := g.r.Worktree()
+	if err != nil {
+		return err
+	}
+	return w.Checkout(&gogit.CheckoutOptions{Hash: h})
+}
+
+func (g goGitRepo) commit(msg string, opts *gogit.CommitOptions) (plumbing.Hash, error) {
+	w, err := g.r.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return w.Commit(msg, opts)
+}
+
+func (g goGitRepo) tag(name string, h plumbing.Hash, opts *gogit.CreateTagOptions) error {
+	_, err := g.r.CreateTag(name, h, opts)
+	return err
+}
+
+func (g goGitRepo) push(ctx context.Context, name string, refSpecs []config.RefSpec) error {
+	return pushRefs(ctx, g.r, name, refSpecs)
+}
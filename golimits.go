@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// format of GOMEMLIMIT, see runtime/debug.SetMemoryLimit
+var memLimitRe = regexp.MustCompile(`^([0-9]+(B|KiB|MiB|GiB|TiB)?|off)$`)
+
+// goSlots bounds the go commands running at once across the workers, nil
+// not bounding them.
+var goSlots chan struct{}
+
+// setupGoLimits checks the limits of the go commands.
+func setupGoLimits() error {
+	if *goMemLimit != "" && !memLimitRe.MatchString(*goMemLimit) {
+		return fmt.Errorf("invalid go memory limit %q, want a size like 2GiB", *goMemLimit)
+	}
+	if *goMaxProcs < 0 || *goMaxParallel < 0 {
+		return fmt.Errorf("--go-max-procs and --go-max-parallel can't be negative")
+	}
+	goSlots = nil
+	if *goMaxParallel > 0 {
+		goSlots = make(chan struct{}, *goMaxParallel)
+	}
+	return nil
+}
+
+// goLimitEnv returns the environment limiting the resources of a go command.
+func goLimitEnv() []string {
+	var env []string
+	if *goMemLimit != "" {
+		env = append(env, "GOMEMLIMIT="+*goMemLimit)
+	}
+	if *goMaxProcs > 0 {
+		env = append(env, "GOMAXPROCS="+strconv.Itoa(*goMaxProcs))
+	}
+	return env
+}
+
+// acquireGo waits for a slot to run a go command in, and returns the function
+// releasing it.
+func acquireGo() func() {
+	slots := goSlots
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() { <-slots }
+}
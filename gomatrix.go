@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Go versions of --go-versions, the first with a toolchain GOTOOLCHAIN can
+// download being 1.21.0
+var goVersionRe = regexp.MustCompile(`^1\.([0-9]+)(\.[0-9]+)?$`)
+
+// goVersionsList returns the versions of --go-versions.
+func goVersionsList() []string {
+	var versions []string
+	for _, v := range strings.Split(*goVersions, ",") {
+		if v = strings.TrimPrefix(strings.TrimSpace(v), "go"); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// checkGoVersions returns an error if a version of --go-versions has no
+// toolchain.
+func checkGoVersions() error {
+	for _, v := range goVersionsList() {
+		m := goVersionRe.FindStringSubmatch(v)
+		if m == nil {
+			return fmt.Errorf("invalid Go version %q in --go-versions, want 1.21 or later like 1.22 or 1.22.5", v)
+		}
+		if minor, _ := strconv.Atoi(m[1]); minor < 21 {
+			return fmt.Errorf("invalid Go version %q in --go-versions, want 1.21 or later like 1.22 or 1.22.5", v)
+		}
+	}
+	if *goVersionsGate && *goVersions == "" {
+		return fmt.Errorf("--go-versions-gate needs --go-versions")
+	}
+	return nil
+}
+
+// toolchain returns the GOTOOLCHAIN of Go version v, the first release of a
+// minor if v has no patch version.
+func toolchain(v string) string {
+	if strings.Count(v, ".") == 1 {
+		v += ".0"
+	}
+	return "go" + v
+}
+
+// buildMatrix builds the rewritten modules of modFiles in the tree at root
+// with the toolchain of each Go version of --go-versions, and returns the
+// outcome by version: ok, or why the build failed.
+func buildMatrix(ctx context.Context, root string, modFiles []string, env []string) map[string]string {
+	results := map[string]string{}
+	for _, v := range goVersionsList() {
+		results[v] = "ok"
+		for _, modFile := range modFiles {
+			cmd := commandContext(ctx, "go", append(append([]string{"build"}, goModFlags()...), "./...")...)
+			cmd.Dir = filepath.Join(root, filepath.FromSlash(path.Dir(modFile)))
+			cmd.Env = append(goEnv(env), "GOTOOLCHAIN="+toolchain(v))
+			release := acquireGo()
+			out, err := cmd.CombinedOutput()
+			release()
+			if err != nil {
+				// the error comes after the downloads of the toolchain
+				lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+				reason := lines[len(lines)-1]
+				if reason == "" {
+					reason = err.Error()
+				}
+				results[v] = modFile + ": " + reason
+				break
+			}
+		}
+	}
+	return results
+}
+
+// logMatrix logs the Go versions tag builds and fails with, and returns an
+// error listing the failing ones.
+func logMatrix(tag string, results map[string]string) error {
+	var ok, failed []string
+	for _, v := range goVersionsList() {
+		if results[v] == "ok" {
+			ok = append(ok, v)
+		} else {
+			failed = append(failed, v)
+			logrus.Warnf("Tag %s doesn't build with Go %s: %s", tag, v, results[v])
+		}
+	}
+	if len(failed) == 0 {
+		logrus.Infof("Tag %s builds with Go %s", tag, strings.Join(ok, ", "))
+		return nil
+	}
+	return fmt.Errorf("tag %s doesn't build with Go %s", tag, strings.Join(failed, ", "))
+}
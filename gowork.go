@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	gogit "github.com/go-git/go-git/v5"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// workspace files in the root of the tree
+var goWorkFiles = []string{"go.work", "go.work.sum"}
+
+// goEnv returns the environment of go commands run on the tree, env being
+// added to the current one with the resource limits.
+func goEnv(env []string) []string {
+	env = append(append(os.Environ(), env...), goLimitEnv()...)
+	if *goWork == "off" {
+		env = append(env, "GOWORK=off")
+	}
+	return env
+}
+
+// goModFlags returns the flags making go commands ignore the vendor directory,
+// whose modules.txt no longer matches the rewritten go.mod. -mod can't be set
+// in workspace mode.
+func goModFlags() []string {
+	if *goWork == "rewrite" {
+		return nil
+	}
+	return []string{"-mod=mod"}
+}
+
+// prepareGoWork applies --go-work to the workspace files of the tree, after
+// the go.mod files are rewritten, and returns the ones it changed.
+func prepareGoWork(fileSystem billy.Filesystem) ([]string, error) {
+	var changed []string
+	switch *goWork {
+	case "remove":
+		for _, name := range goWorkFiles {
+			if !fileExists(filepath.Join(fileSystem.Root(), name)) {
+				continue
+			}
+			if err := fileSystem.Remove(name); err != nil {
+				return nil, fmt.Errorf("failed to remove %s: %v", name, err)
+			}
+			changed = append(changed, name)
+		}
+	case "rewrite":
+		ok, err := rewriteGoWork(fileSystem)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			changed = append(changed, "go.work")
+		}
+	}
+	return changed, nil
+}
+
+// rewriteGoWork drops the replaces of go.work like prepareModFile does for
+// go.mod, and raises its go version to the highest of the used modules, which
+// the go command requires.
+func rewriteGoWork(fileSystem billy.Filesystem) (bool, error) {
+	b, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.work"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read go.work: %v", err)
+	}
+	workFile, err := modfile.ParseWork("go.work", b, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse go.work: %v", err)
+	}
+	for _, replace := range workFile.Replace {
+		_ = workFile.DropReplace(replace.Old.Path, replace.Old.Version)
+	}
+	goVersion := ""
+	if workFile.Go != nil {
+		goVersion = workFile.Go.Version
+	}
+	for _, use := range workFile.Use {
+		name := filepath.Join(fileSystem.Root(), filepath.FromSlash(path.Join(use.Path, "go.mod")))
+		mb, err := os.ReadFile(name)
+		if err != nil {
+			return false, fmt.Errorf("failed to read go.mod of %s: %v", use.Path, err)
+		}
+		modFile, err := modfile.ParseLax(name, mb, nil)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse go.mod of %s: %v", use.Path, err)
+		}
+		if modFile.Go != nil && semver.Compare("v"+modFile.Go.Version, "v"+goVersion) > 0 {
+			goVersion = modFile.Go.Version
+		}
+	}
+	if goVersion != "" && (workFile.Go == nil || goVersion != workFile.Go.Version) {
+		if err = workFile.AddGoStmt(goVersion); err != nil {
+			return false, fmt.Errorf("failed to set go version of go.work: %v", err)
+		}
+	}
+	workFile.Cleanup()
+	out := modfile.Format(workFile.Syntax)
+	if string(out) == string(b) {
+		return false, nil
+	}
+	return true, writeFile(fileSystem, "go.work", out)
+}
+
+// stageFile adds name to the index of w, or removes it if it was deleted.
+func stageFile(w *gogit.Worktree, name string) error {
+	if fileExists(filepath.Join(w.Filesystem.Root(), filepath.FromSlash(name))) {
+		_, err := w.Add(name)
+		return err
+	}
+	_, err := w.Remove(name)
+	return err
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// buckets of the --history-db, runs keyed by run id and tags by tag and run
+// id, so the runs of a tag are next to each other in run order
+var (
+	runsBucket = []byte("runs")
+	tagsBucket = []byte("tags")
+)
+
+// layout of the run ids, sorting like the start times
+const runIDLayout = "20060102T150405.000Z"
+
+// how long to wait for another kksyncer holding the --history-db
+const historyLockTimeout = 30 * time.Second
+
+// runHistory is a sync run in the --history-db.
+type runHistory struct {
+	ID              string    `json:"id"`
+	StartedAt       time.Time `json:"startedAt"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Version         string    `json:"version"`
+	ConfigHash      string    `json:"configHash"`
+	Published       []string  `json:"published"`
+	Failed          []string  `json:"failed"`
+	Deferred        []string  `json:"deferred,omitempty"`
+	Skipped         int       `json:"skipped"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// tagHistory is the outcome of a tag in a sync run in the --history-db.
+type tagHistory struct {
+	Tag             string    `json:"tag"`
+	Run             string    `json:"run"`
+	Outcome         string    `json:"outcome"`
+	StartedAt       time.Time `json:"startedAt"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	SourceCommit    string    `json:"sourceCommit,omitempty"`
+	Commit          string    `json:"commit,omitempty"`
+	PublishedTag    string    `json:"publishedTag,omitempty"`
+	// phase and error of failed tags
+	Phase string `json:"phase,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// recordHistory stores the run started at started, which returned runErr,
+// and the outcome of its tags in the --history-db.
+func recordHistory(summary *runSummary, started time.Time, runErr error) error {
+	if err := os.MkdirAll(filepath.Dir(*historyDB), 0755); err != nil {
+		return err
+	}
+	db, err := bolt.Open(*historyDB, 0644, &bolt.Options{Timeout: historyLockTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	id := started.UTC().Format(runIDLayout)
+	run := runHistory{
+		ID:              id,
+		StartedAt:       started.UTC(),
+		DurationSeconds: time.Since(started).Seconds(),
+		Version:         toolVersion()["kksyncer"],
+		ConfigHash:      configHash(),
+		Published:       summary.Published,
+		Failed:          summary.Failed,
+		Deferred:        summary.Deferred,
+		Skipped:         len(summary.Skipped),
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		runs, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		tags, err := tx.CreateBucketIfNotExists(tagsBucket)
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		if err = runs.Put([]byte(id), b); err != nil {
+			return err
+		}
+		for _, t := range summary.tagHistory() {
+			t.Run = id
+			if b, err = json.Marshal(t); err != nil {
+				return err
+			}
+			if err = tags.Put([]byte(t.Tag+"\x00"+id), b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// runHistoryCommand prints the last --history-limit runs, or the runs of the
+// tag given as argument.
+func runHistoryCommand(context.Context) error {
+	if *historyDB == "" {
+		return withExitCode(exitConfig, errors.New("history needs --history-db"))
+	}
+	if *output != "table" && *output != "json" {
+		return fmt.Errorf("invalid output format %q", *output)
+	}
+	if *stateBackendFlag != "" {
+		state, err := openStateOnly()
+		if err != nil {
+			return err
+		}
+		if err = loadHistory(state); err != nil {
+			return fmt.Errorf("failed to load the history from the state: %v", err)
+		}
+	}
+	if _, err := os.Stat(*historyDB); err != nil {
+		return err
+	}
+	db, err := bolt.Open(*historyDB, 0644, &bolt.Options{Timeout: historyLockTimeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tag := flag.Arg(0)
+	runs, tags := []runHistory{}, []tagHistory{}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tag != "" {
+			b := tx.Bucket(tagsBucket)
+			if b == nil {
+				return nil
+			}
+			prefix := []byte(tag + "\x00")
+			c := b.Cursor()
+			for k, v := c.Seek(prefix); bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				var t tagHistory
+				if err := json.Unmarshal(v, &t); err != nil {
+					return err
+				}
+				tags = append(tags, t)
+			}
+			return nil
+		}
+		b := tx.Bucket(runsBucket)
+		if b == nil {
+			return nil
+		}
+		// newest first
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && (*historyLimit <= 0 || len(runs) < *historyLimit); k, v = c.Prev() {
+			var r runHistory
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			runs = append(runs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if tag != "" {
+			return enc.Encode(tags)
+		}
+		return enc.Encode(runs)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if tag != "" {
+		if len(tags) == 0 {
+			logrus.Infof("No run handled %s", tag)
+		}
+		fmt.Fprintln(tw, "RUN\tSTARTED\tDURATION\tOUTCOME\tCOMMIT\tERROR")
+		for _, t := range tags {
+			commit, reason := "-", "-"
+			if len(t.Commit) >= 12 {
+				commit = t.Commit[:12]
+			}
+			if t.Error != "" {
+				reason = t.Phase + ": " + t.Error
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Run, t.StartedAt.Local().Format(time.DateTime), seconds(t.DurationSeconds), t.Outcome, commit, reason)
+		}
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "RUN\tSTARTED\tDURATION\tPUBLISHED\tFAILED\tDEFERRED\tSKIPPED")
+	for _, r := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", r.ID, r.StartedAt.Local().Format(time.DateTime), seconds(r.DurationSeconds), len(r.Published), len(r.Failed), len(r.Deferred), r.Skipped)
+	}
+	return tw.Flush()
+}
+
+// seconds formats a duration in seconds rounded to the second.
+func seconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).Round(time.Second).String()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hook points of --hook-pre-rewrite, --hook-post-rewrite and --hook-pre-push
+const (
+	hookPreRewrite  = "pre-rewrite"
+	hookPostRewrite = "post-rewrite"
+	hookPrePush     = "pre-push"
+)
+
+// hookInfo is the tag metadata passed to hooks as KKSYNCER_HOOK_*
+// environment variables.
+type hookInfo struct {
+	worker int
+	tag    string
+	source string
+	// rewrite commit, only known to pre-push
+	commit string
+}
+
+// hookCommand returns the executable of the hook point, or "".
+func hookCommand(point string) string {
+	switch point {
+	case hookPreRewrite:
+		return *preRewriteHook
+	case hookPostRewrite:
+		return *postRewriteHook
+	case hookPrePush:
+		return *prePushHook
+	}
+	return ""
+}
+
+// runHook runs the executable of the hook point in the worktree at dir, if
+// any. Its output goes to ours, and a failure fails the tag.
+func runHook(ctx context.Context, point, dir string, info hookInfo) error {
+	name := hookCommand(point)
+	if name == "" {
+		return nil
+	}
+	cmd := commandContext(ctx, name)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"KKSYNCER_HOOK="+point,
+		"KKSYNCER_HOOK_TAG="+info.tag,
+		"KKSYNCER_HOOK_PUBLISHED_TAG="+info.tag+"-mod",
+		"KKSYNCER_HOOK_SOURCE_COMMIT="+info.source,
+		"KKSYNCER_HOOK_COMMIT="+info.commit,
+		"KKSYNCER_HOOK_WORKER="+strconv.Itoa(info.worker),
+		"KKSYNCER_HOOK_TARGET_REPO="+*targetRepo,
+	)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %s failed: %v", point, name, err)
+	}
+	return nil
+}
+
+// stageHookChanges stages the changes of the rewrite hooks and plugins in the
+// worktree at dir, so they are part of the rewrite commit. Only the unstaged
+// changes in the directories of the rewritten modFiles are staged, a change
+// elsewhere fails the tag rather than being published.
+func stageHookChanges(dir string, modFiles []string) error {
+	if *preRewriteHook == "" && *postRewriteHook == "" && *rewritePlugins == "" {
+		return nil
+	}
+	cmd := exec.Command("git", "status", "--porcelain", "-z", "--untracked-files=all")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list the changes of hooks: %v", err)
+	}
+	paths, outside := hookChanges(out, modFiles)
+	if len(outside) > 0 {
+		return fmt.Errorf("hooks changed files outside of the rewritten modules: %s", strings.Join(outside, ", "))
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	cmd = exec.Command("git", append([]string{"add", "--all", "--"}, paths...)...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stage the changes of hooks: %v: %s", err, out)
+	}
+	return nil
+}
+
+// hookChanges returns the literal pathspecs of the unstaged changes of the
+// git status --porcelain -z output status inside the directories of
+// modFiles, and the paths of the ones outside of them.
+func hookChanges(status []byte, modFiles []string) (paths, outside []string) {
+	entries := strings.Split(string(status), "\x00")
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		if len(e) < 4 {
+			continue
+		}
+		// renames and copies are followed by their source
+		if e[0] == 'R' || e[0] == 'C' {
+			i++
+		}
+		// only the staged changes of the rewrite itself
+		if e[1] == ' ' {
+			continue
+		}
+		p := e[3:]
+		inside := slices.ContainsFunc(modFiles, func(modFile string) bool {
+			d := path.Dir(modFile)
+			return d == "." || p == d || strings.HasPrefix(p, d+"/")
+		})
+		if inside {
+			paths = append(paths, ":(literal)"+p)
+		} else {
+			outside = append(outside, p)
+		}
+	}
+	return paths, outside
+}
+
+// publishPayload is the JSON sent to --hook-post-publish.
+type publishPayload struct {
+	Tag             string  `json:"tag"`
+	PublishedTag    string  `json:"publishedTag"`
+	TagHash         string  `json:"tagHash"`
+	SourceCommit    string  `json:"sourceCommit"`
+	Commit          string  `json:"commit"`
+	Target          string  `json:"target"`
+	Ref             string  `json:"ref"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	PublishedAt     string  `json:"publishedAt"`
+}
+
+// postPublish sends p to --hook-post-publish, POSTed to an http(s) URL or on
+// the stdin of a command, to trigger downstream automation. The tag is
+// already pushed, so failures are only logged.
+func postPublish(p publishPayload) {
+	hook := *postPublishHook
+	if hook == "" {
+		return
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		logrus.Warnf("Failed to encode the post-publish payload of %s: %v", p.PublishedTag, err)
+		return
+	}
+	if strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://") {
+		err = postJSON(hook, b)
+	} else {
+		cmd := exec.Command(hook)
+		cmd.Stdin = bytes.NewReader(b)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+	}
+	if err != nil {
+		logrus.Warnf("Post-publish hook of %s failed: %v", p.PublishedTag, err)
+	}
+}
+
+func postJSON(url string, b []byte) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s: %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHookChanges(t *testing.T) {
+	status := strings.Join([]string{
+		" M staging/src/k8s.io/api/types.go",
+		"?? staging/src/k8s.io/api/zz_generated.go",
+		// staged by the rewrite
+		"M  staging/src/k8s.io/client-go/go.mod",
+		"D  test/e2e/e2e.go",
+		// renamed by a hook, and its source
+		"RM staging/src/k8s.io/api/new.go",
+		"staging/src/k8s.io/api/old.go",
+		" D hack/verify.sh",
+		"?? ..workers/worker-1/repo/go.mod",
+		"?? staging/src/k8s.io/api-extra/doc.go",
+	}, "\x00") + "\x00"
+	modFiles := []string{"staging/src/k8s.io/api/go.mod", "staging/src/k8s.io/client-go/go.mod"}
+	paths, outside := hookChanges([]byte(status), modFiles)
+	wantPaths := []string{
+		":(literal)staging/src/k8s.io/api/types.go",
+		":(literal)staging/src/k8s.io/api/zz_generated.go",
+		":(literal)staging/src/k8s.io/api/new.go",
+	}
+	wantOutside := []string{"hack/verify.sh", "..workers/worker-1/repo/go.mod", "staging/src/k8s.io/api-extra/doc.go"}
+	if !slices.Equal(paths, wantPaths) {
+		t.Errorf("staged %v, want %v", paths, wantPaths)
+	}
+	if !slices.Equal(outside, wantOutside) {
+		t.Errorf("refused %v, want %v", outside, wantOutside)
+	}
+	// the root module holds every path
+	if paths, outside = hookChanges([]byte(status), []string{"go.mod"}); len(paths) != 6 || len(outside) != 0 {
+		t.Errorf("with the root module staged %v and refused %v", paths, outside)
+	}
+}
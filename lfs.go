@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// first line of a Git LFS pointer file, which are at most lfsPointerMax bytes
+const (
+	lfsPointerHeader = "version https://git-lfs.github.com/spec/v1\n"
+	lfsPointerMax    = 1024
+)
+
+// the modes of --lfs
+var lfsModes = []string{"warn", "fail", "fetch", "prune", "off"}
+
+// checkLFSMode validates --lfs.
+func checkLFSMode() error {
+	for _, m := range lfsModes {
+		if *lfsMode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid --lfs %q, want one of %s", *lfsMode, strings.Join(lfsModes, ", "))
+}
+
+// findLFSPointers returns the slash separated paths of the Git LFS pointer
+// files in the tree at root, the files tracked by LFS upstream whose
+// content isn't in git.
+func findLFSPointers(root string) ([]string, error) {
+	var pointers []string
+	header := make([]byte, len(lfsPointerHeader))
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < int64(len(header)) || info.Size() > lfsPointerMax {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadFull(f, header)
+		f.Close()
+		if err != nil || !bytes.Equal(header, []byte(lfsPointerHeader)) {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		pointers = append(pointers, filepath.ToSlash(rel))
+		return nil
+	})
+	return pointers, err
+}
+
+// pullLFS replaces the LFS pointer files of the tree at root with their
+// content from the LFS server of the upstream, or of its mirrors, with the
+// extra variables env. The lfs filter is only configured for the pull, so
+// the index keeps the pointers and the published tags and module zips are
+// the same as without it.
+func pullLFS(ctx context.Context, root string, env []string) error {
+	return withSourceFailover(ctx, "fetch the LFS files", func(url string) error {
+		cmd := commandContext(ctx, "git", "-C", root,
+			"-c", "remote.kksyncer-lfs.url="+url,
+			"-c", "filter.lfs.process=git-lfs filter-process",
+			"-c", "filter.lfs.required=true",
+			"lfs", "pull", "kksyncer-lfs")
+		// never wait for credentials of a private upstream
+		cmd.Env = append(append(os.Environ(), env...), "GIT_TERMINAL_PROMPT=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to run git lfs pull: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
+
+// formatPaths returns paths joined by commas, the first few of them if
+// there are many.
+func formatPaths(paths []string) string {
+	const max = 5
+	if len(paths) <= max {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:max], ", "), len(paths)-max)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// names of the license files in the root of a module
+var licenseFiles = regexp.MustCompile(`(?i)^(licen[cs]e|copying|unlicense)(\.(md|txt|rst))?$`)
+
+// licensePatterns identify licenses by phrases of their text, the first one
+// matching wins. This is a heuristic for review, not a legal classification.
+var licensePatterns = []struct {
+	id      string
+	phrases []string
+}{
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"EPL-2.0", []string{"eclipse public license", "2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+}
+
+// moduleLicense is a module of the license report.
+type moduleLicense struct {
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	License string `json:"license"`
+	File    string `json:"file,omitempty"`
+}
+
+// detectLicense returns the license of the module in dir and the file it was
+// found in, or "unknown".
+func detectLicense(dir string) (string, string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "unknown", ""
+	}
+	for _, e := range entries {
+		if e.IsDir() || !licenseFiles.MatchString(e.Name()) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		text := strings.Join(strings.Fields(strings.ToLower(string(b))), " ")
+		for _, p := range licensePatterns {
+			if !slices.ContainsFunc(p.phrases, func(s string) bool { return !strings.Contains(text, s) }) {
+				return p.id, e.Name()
+			}
+		}
+		return "unknown", e.Name()
+	}
+	return "unknown", ""
+}
+
+// moduleDirs returns the module cache directories of mods by path,
+// downloading the ones not in the cache.
+func moduleDirs(ctx context.Context, dir string, mods []buildModule, env []string) (map[string]string, error) {
+	args := []string{"mod", "download", "-json"}
+	for _, m := range mods[1:] {
+		args = append(args, m.Path+"@"+m.Version)
+	}
+	dirs := map[string]string{}
+	if len(args) == 3 {
+		return dirs, nil
+	}
+	cmd := commandContext(ctx, "go", args...)
+	cmd.Dir, cmd.Env = dir, goEnv(env)
+	// go mod download prints the errors of modules in the JSON, and fails
+	release := acquireGo()
+	out, _ := cmd.Output()
+	release()
+	for dec := json.NewDecoder(bytes.NewReader(out)); ; {
+		var m struct{ Path, Dir, Error string }
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse go mod download output: %v", err)
+		}
+		if m.Error != "" {
+			logrus.Warnf("Failed to download %s for the license report: %s", m.Path, m.Error)
+			continue
+		}
+		dirs[m.Path] = m.Dir
+	}
+	return dirs, nil
+}
+
+// writeLicenseReport writes the licenses of the module graph of each of the
+// rewritten modules of tag to <license-report-dir>/<tag>-mod/<module dir>/licenses.<format>.
+func writeLicenseReport(ctx context.Context, root string, modFiles []string, tag string, env []string) error {
+	for _, modFile := range modFiles {
+		mods, _, err := publishedGraph(ctx, root, modFile, tag, env)
+		if err != nil {
+			return err
+		}
+		report, err := graphLicenses(ctx, root, modFile, mods, env)
+		if err != nil {
+			return err
+		}
+		unknown := 0
+		for _, l := range report {
+			if l.License == "unknown" {
+				unknown++
+			}
+		}
+
+		var out []byte
+		switch *licenseFormat {
+		case "markdown":
+			out = licenseMarkdown(mods[0], report)
+		default:
+			if out, err = json.MarshalIndent(report, "", "  "); err != nil {
+				return err
+			}
+			out = append(out, '\n')
+		}
+		ext := map[string]string{"json": "json", "markdown": "md"}[*licenseFormat]
+		name := filepath.Join(*licenseReportDir, tag+"-mod", filepath.FromSlash(path.Dir(modFile)), "licenses."+ext)
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err = os.WriteFile(name, out, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		logrus.Infof("Wrote licenses of %d modules of %s to %s, %d unknown", len(report), mods[0].Path, name, unknown)
+	}
+	return nil
+}
+
+// graphLicenses returns the licenses of mods, the module graph of the
+// rewritten module of modFile in the tree at root.
+func graphLicenses(ctx context.Context, root, modFile string, mods []buildModule, env []string) ([]moduleLicense, error) {
+	dir := filepath.Join(root, filepath.FromSlash(path.Dir(modFile)))
+	restore, err := preserveFiles(filepath.Join(dir, "go.mod"), filepath.Join(dir, "go.sum"), filepath.Join(root, "go.work.sum"))
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := moduleDirs(ctx, dir, mods, env)
+	if rerr := restore(); rerr != nil && err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	dirs[mods[0].Path] = dir
+
+	var report []moduleLicense
+	for _, m := range mods {
+		l := moduleLicense{Module: m.Path, Version: m.Version, License: "unknown"}
+		if d, ok := dirs[m.Path]; ok {
+			l.License, l.File = detectLicense(d)
+		}
+		report = append(report, l)
+	}
+	return report, nil
+}
+
+func licenseMarkdown(main buildModule, report []moduleLicense) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Licenses of %s@%s\n\n", main.Path, main.Version)
+	counts := map[string]int{}
+	for _, l := range report {
+		counts[l.License]++
+	}
+	for _, id := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(&sb, "- %s: %d\n", id, counts[id])
+	}
+	sb.WriteString("\n| Module | Version | License | File |\n| --- | --- | --- | --- |\n")
+	for _, l := range report {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s |\n", l.Module, l.Version, l.License, l.File)
+	}
+	return []byte(sb.String())
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// runList prints the state of every upstream tag on the target. It only lists
+// the remotes and never touches the workdir or the target.
+func runList(ctx context.Context) error {
+	var source map[string]plumbing.Hash
+	var annotated map[plumbing.Hash]bool
+	err := withSourceFailover(ctx, "list the tags", func(url string) error {
+		var err error
+		source, annotated, err = listRemoteTags(ctx, sourceRemote, url)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s tags: %v", sourceRemote, err)
+	}
+	target, _, err := listRemoteTags(ctx, targetRemote, *targetRepo)
+	if err != nil {
+		return fmt.Errorf("failed to list %s tags: %v", targetRemote, err)
+	}
+	filter, err := newTagFilter()
+	if err != nil {
+		return err
+	}
+	tags := planTags(source, target, filter, func(h plumbing.Hash) bool { return annotated[h] })
+
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tags)
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TAG\tUPSTREAM\tPUBLISHED\tSTATUS")
+		for _, t := range tags {
+			published, status := t.Published, string(t.Status)
+			if published == "" {
+				published = "-"
+			}
+			if t.Reason != "" {
+				status += " (" + t.Reason + ")"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, t.Hash[:12], published, status)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("invalid output format %q", *output)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// layout of the time in the names of rotated log files
+const rotatedLayout = "20060102T150405.000"
+
+// rotatingFile is a log file renamed to <name>-<time><ext> once it reaches
+// maxSize, removing rotated files older than maxAge or beyond the maxBackups
+// newest ones, zero keeping them.
+type rotatingFile struct {
+	name       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(name string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{name: name, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	rf.cleanup()
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(b []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(rf.name)
+	rotated := strings.TrimSuffix(rf.name, ext) + "-" + time.Now().Format(rotatedLayout) + ext
+	if err := os.Rename(rf.name, rotated); err != nil {
+		return err
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.cleanup()
+	return nil
+}
+
+// cleanup removes the rotated files beyond maxAge and maxBackups.
+func (rf *rotatingFile) cleanup() {
+	ext := filepath.Ext(rf.name)
+	prefix := strings.TrimSuffix(rf.name, ext) + "-"
+	matches, err := filepath.Glob(globEscape(prefix) + "*" + ext)
+	if err != nil {
+		return
+	}
+	type rotatedFile struct {
+		name string
+		at   time.Time
+	}
+	var files []rotatedFile
+	for _, m := range matches {
+		at, err := time.ParseInLocation(rotatedLayout, strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext), time.Local)
+		if err == nil {
+			files = append(files, rotatedFile{m, at})
+		}
+	}
+	// newest first
+	slices.SortFunc(files, func(a, b rotatedFile) int { return b.at.Compare(a.at) })
+	for i, f := range files {
+		if rf.maxBackups > 0 && i >= rf.maxBackups || rf.maxAge > 0 && time.Since(f.at) > rf.maxAge {
+			if err := os.Remove(f.name); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to remove rotated log file %s: %v\n", f.name, err)
+			}
+		}
+	}
+}
+
+// globEscape escapes the meta characters of filepath.Match in s.
+func globEscape(s string) string {
+	return strings.NewReplacer(`*`, `[*]`, `?`, `[?]`, `[`, `[[]`).Replace(s)
+}
+
+// fileHook writes all logs to a file, without colors whatever the output of
+// the console is.
+type fileHook struct {
+	w         *rotatingFile
+	formatter logrus.Formatter
+}
+
+func (h *fileHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *fileHook) Fire(entry *logrus.Entry) error {
+	b, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.w.Write(b)
+	return err
+}
+
+// setupLogFile adds the hook writing the logs to --log-file.
+func setupLogFile() error {
+	if *logFile == "" {
+		return nil
+	}
+	w, err := openRotatingFile(*logFile, *logFileMaxSize<<20, *logFileMaxAge, *logFileBackups)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	logrus.AddHook(&fileHook{w: w, formatter: &logrus.TextFormatter{DisableColors: true, FullTimestamp: true}})
+	return nil
+}
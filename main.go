@@ -1,23 +1,27 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"maps"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
 )
 
@@ -27,9 +31,123 @@ const (
 )
 
 var (
-	workdir    = flag.String("workdir", ".", "Workdir to use")
-	sourceRepo = flag.String("source-repo", "https://github.com/kubernetes/kubernetes.git", "Source repo")
-	targetRepo = flag.String("target-repo", "", "Target repo")
+	configFile       = flag.String("config", "", "JSON file with flag values by flag name, like {\"target-repo\": \"...\", \"workers\": 4}")
+	workdir          = flag.String("workdir", ".", "Workdir to use")
+	sourceRepo       = flag.String("source-repo", "", "Source repo, defaults to the profile's, https://github.com/kubernetes/kubernetes.git for kubernetes")
+	sourceMirrors    = flag.String("source-mirrors", "", "Comma separated mirrors of the source repo to fetch from in order when fetching from --source-repo fails, like an internal mirror of GitHub")
+	targetRepo       = flag.String("target-repo", "", "Target repo")
+	targetTokenFile  = flag.String("target-token-file", "", "File with the token to push to an HTTPS target with, like a mounted secret")
+	tokenSource      = flag.String("target-token-source", "", "Secret store to get the target token from: vault:<path>[#field], aws:<secret id>[#json key], gcp:<secret> or file:<path>")
+	tokenTTL         = flag.Duration("target-token-ttl", 50*time.Minute, "Fetch the target token again once it is this old, before fetches and pushes, 0 never does")
+	targetUsername   = flag.String("target-username", "", "Username to send with the token of --target-token-file, defaults to x-access-token, or x-token-auth for Bitbucket Cloud")
+	sshKeyFile       = flag.String("ssh-key-file", "", "File with the SSH private key to push to an SSH target with")
+	sshPassFile      = flag.String("ssh-key-passphrase-file", "", "File with the passphrase of --ssh-key-file")
+	numWorkers       = flag.Int("workers", 1, "Number of tags to handle in parallel")
+	workerDir        = flag.String("worker-dir", "", "Directory for per-worker workdirs, defaults to <workdir>.workers")
+	sumMode          = flag.String("sum-mode", "tidy", "How to compute go.mod and go.sum after the rewrite: tidy runs go mod tidy, mvs resolves them from the module proxy and checksum database (falling back to tidy), verify does both and reports differences")
+	skipTidy         = flag.Bool("skip-tidy", false, "Write the rewritten go.mod as is, without go mod tidy, adding the checksums of the changed requires to the upstream go.sum from the checksum database")
+	tidyDrift        = flag.String("tidy-drift", "off", "What to do when go mod tidy adds or changes requires of the rewritten go.mod: off, warn, or fail the tag")
+	tagDivergence    = flag.String("tag-divergence", "off", "What to do with published tags whose tree differs from a fresh rewrite of their upstream tag, like on a target changed by hand: off to skip them unchecked, report, fail the run, or force to publish them again, rewriting every published tag on each run")
+	tidyDriftAllow   = flag.String("tidy-drift-allow", "", "Comma separated patterns of the modules tidy may add or change the requires of without --tidy-drift reporting them")
+	reuseTidy        = flag.Bool("reuse-tidy", false, "Reuse the tidied go.mod and go.sum of a tag for tags with the same upstream go.mod, if the pinned modules have the same requirements")
+	lightTags        = flag.Bool("allow-lightweight-tags", false, "Also handle lightweight upstream tags, instead of only annotated ones")
+	versionRangeExpr = flag.String("version-range", "", "Only handle upstream tags in this semver range, like \">=1.28.0 <1.31.0\"")
+	maxTransferRate  = flag.String("max-transfer-rate", "", "Maximum rate of the git fetches and pushes in each direction, in bytes per second like 512KiB or 2MiB, a new workdir is then fetched instead of cloned with git")
+	fetchTags        = flag.String("fetch-tags", "", "Comma separated patterns of the upstream tags to fetch instead of all of them, like v1.*, a new workdir then only gets their history")
+	fetchRefSpecList = flag.String("fetch-refspecs", "", "Comma separated refspecs to also fetch from the upstream into the workdir, like +refs/heads/release-*:refs/remotes/upstream/release-*,+refs/notes/*:refs/notes/upstream/*")
+	targetRefSpecs   = flag.String("target-fetch-refspecs", "", "Comma separated refspecs to also fetch from the target into the workdir, like +refs/heads/*:refs/remotes/target/*")
+	allowlistFile    = flag.String("tags-allowlist-file", "", "File with upstream tags to handle, one per line, overriding the other filters")
+	denylistFile     = flag.String("tags-denylist-file", "", "File with upstream tags to never handle, one per line")
+	output           = flag.String("output", "table", "Output format of list and history: table or json")
+	historyDB        = flag.String("history-db", "", "BoltDB file to record the outcome, duration and commits of every run and tag in, for the history command")
+	historyLimit     = flag.Int("history-limit", 20, "Number of most recent runs history prints, 0 for all")
+	stateBackendFlag = flag.String("state-backend", "", "Where to keep the state of the runs for runners without a persistent workdir, a lock against concurrent runs and the --history-db: git for refs under "+stateRefPrefix+" of the target, s3://<bucket>/<prefix> or gs://<bucket>/<prefix>")
+	stateLockTTL     = flag.Duration("state-lock-ttl", 6*time.Hour, "Time after which the lock of a run in --state-backend expires, for runs that died without removing it")
+	prewarmCache     = flag.Bool("prewarm-modcache", false, "Download the dependencies of the newest tag to handle with go mod download all before handling the tags, so their go mod tidy runs mostly find the modules in the cache")
+	isolateMod       = flag.Bool("isolate-gomodcache", false, "Use a separate GOMODCACHE per worker under the worker dir")
+	goMemLimit       = flag.String("go-memory-limit", "", "GOMEMLIMIT of the go commands run on the tree, like 2GiB, a soft limit making them collect garbage harder near it")
+	goMaxProcs       = flag.Int("go-max-procs", 0, "GOMAXPROCS of the go commands run on the tree, 0 uses all CPUs")
+	goMaxParallel    = flag.Int("go-max-parallel", 0, "Maximum number of go commands running at once across the workers, 0 for no limit")
+	goWork           = flag.String("go-work", "off", "How to handle a go.work in the tree: off sets GOWORK=off for the go commands of the rewrite, remove deletes go.work and go.work.sum from the published tag, rewrite drops its replaces and raises its go version to the rewritten modules")
+	propagateRetract = flag.Bool("propagate-retractions", false, "Map the retract directives of the root go.mod to the published versions, and retract published versions whose upstream tag was deleted")
+	submodulesOn     = flag.Bool("submodules", false, "Check out the submodules of each upstream tag recursively before the rewrite, for upstreams needing them to build, they aren't part of the published modules")
+	submoduleURLs    = flag.String("submodule-url-rewrites", "", "Comma separated <prefix>=<replacement> rewrites of the submodule URLs, like https://github.com/=https://git.example.com/github/ for an internal mirror")
+	lfsMode          = flag.String("lfs", "warn", "How to handle the Git LFS pointer files of the upstream tags, which the go command doesn't resolve: warn logs them, fail fails the tag, fetch pulls their content for the verification builds, prune removes them from the published tags, off doesn't look for them")
+	prunePaths       = flag.String("prune-paths", "", "Comma separated patterns of paths to remove from the published tags, like test,hack,cluster, the rewritten modules are built to verify they still compile")
+	tagKeyring       = flag.String("tag-keyring", "", "Armored PGP keyring to verify the signatures of upstream tags with before publishing them, unsigned tags fail")
+	commitStyle      = flag.String("commit-style", "plain", "Style of the rewrite commit messages: plain like \"Prepare v1.30.0-mod\", or conventional like \"chore(release): publish v1.30.0-mod\" for targets enforcing conventional commits")
+	trailersOn       = flag.Bool("trailers", true, "Add Kksyncer-Version, Source-Repo, Source-Tag and Source-Commit trailers to the rewrite commits, and publish annotated tags with them instead of lightweight ones")
+	provenanceOn     = flag.Bool("provenance", false, "Push a SLSA provenance of each published tag to "+provenanceRefPrefix+"<tag>")
+	provenanceKey    = flag.String("provenance-key", "", "Armored PGP private key to sign the provenance with")
+	sbomDir          = flag.String("sbom-dir", "", "Directory to write an SBOM of each rewritten module to, as <tag>-mod/<module dir>/sbom.<format>.json")
+	sbomFormat       = flag.String("sbom-format", "spdx", "Format of the SBOMs: spdx or cyclonedx")
+	testPackagesFlag = flag.String("test-packages", "", "Comma separated package patterns of the upstream tests to run on the rewritten tree before publishing, relative to its root like ./pkg/util/..., failing tests fail the tag")
+	testTimeout      = flag.Duration("test-timeout", 10*time.Minute, "Timeout of the --test-packages tests of a tag")
+	goVersions       = flag.String("go-versions", "", "Comma separated Go versions to build the rewritten modules with and report for each tag, like 1.21,1.22,1.23, with the toolchains GOTOOLCHAIN downloads")
+	goVersionsGate   = flag.Bool("go-versions-gate", false, "Fail tags not building with all --go-versions before publishing them, instead of reporting them after publishing")
+	vulnCheck        = flag.Bool("vuln-check", false, "Run govulncheck on the rewritten modules and report the known vulnerabilities of each tag")
+	vulnDB           = flag.String("vuln-db", "", "Vulnerability database of govulncheck, defaults to https://vuln.go.dev")
+	vulnFailOn       = flag.String("vuln-fail-on", "none", "Fail tags with vulnerabilities at this level or above: none, required, imported or called")
+	licenseReportDir = flag.String("license-report-dir", "", "Directory to write the licenses of the module graph of each rewritten module to, as <tag>-mod/<module dir>/licenses.<format>")
+	licenseFormat    = flag.String("license-report-format", "json", "Format of the license reports: json or markdown")
+	policyFlag       = flag.String("policy", "", "Rules the module graph of each rewritten module must follow to be published, separated by ; or newlines: deny-module <module pattern>, deny-version <module pattern> <version range>, deny-license <license pattern> or allow-license <license pattern>...")
+	printSchema      = flag.Bool("schema", false, "Print the JSON schema of the --config file with validate-config")
+	tagFlag          = flag.String("tag", "", "Published tag to reproduce, like v1.30.0-mod, or upstream tag to rewrite, like v1.30.0")
+	rewriteDir       = flag.String("dir", "", "Local checkout whose go.mod files the rewrite command rewrites in place, instead of the go.mod on stdin")
+	showProgress     = flag.Bool("progress", true, "Log the progress of clones, fetches, pushes and checkouts")
+	targetRefPrefix  = flag.String("target-ref-prefix", "refs/tags/", "Namespace of the published tags on the target, like refs/tags/mirror/ or refs/staging/ for tags promoted by the server")
+	targetSubdir     = flag.String("target-subdir", "", "Directory of the default branch of the target to commit the rewritten tree of each tag under, on top of the branch, tagged as <dir>/<tag>-mod, for a mirror inside an existing monorepo, needs --workers=1")
+	signedPush       = flag.String("signed-push", "", "Push with a push certificate for targets requiring them, with git push --signed: yes, or if-asked to sign only when the target supports it")
+	pushSigningKey   = flag.String("push-signing-key", "", "Key to sign the push certificates with, as user.signingKey of git: a GPG key id, or the file of an SSH key with --push-signing-format=ssh, defaults to the committer identity")
+	pushSigningFmt   = flag.String("push-signing-format", "", "Format of the push signing key, as gpg.format of git: openpgp, x509 or ssh, defaults to the git config")
+	publishVia       = flag.String("publish-via", "git", "How to publish the tags: git pushes them, github-api creates the commits and tags with the GitHub Git Data API for networks without git push, the upstream commits must already be on the target")
+	auditLog         = flag.String("audit-log", "", "File to append a JSON line to for every push to the target, with the masked credentials identity, config hash, tool version and refs")
+	metaBranch       = flag.String("meta-branch", "", "Branch of the target to commit the report of each run, the tag manifest and the skipped tags to, like kksyncer-meta")
+	mirrorAll        = flag.Bool("mirror", false, "Also push the upstream branches and tags verbatim to the target, making it a complete fork")
+	linearHistory    = flag.Bool("linear-history", false, "Chain the rewrite commits, with the previously published one as first parent and the upstream commit as second, needs --workers=1")
+	releaseBranches  = flag.Bool("release-branches", false, "Also point a branch per release series, like release-1.30-mod, to the newest published patch release of the minor")
+	canaryRepos      = flag.String("canary-repos", "", "Comma separated <owner>/<repo>[:<go.mod directory>] of consumers on the GitHub host of the target to open a pull request on bumping their replaces with the target to the newest published tag after each run")
+	createReleases   = flag.Bool("create-releases", false, "Create a release of each published tag with the API of a GitHub or Gitee target")
+	copyReleaseNotes = flag.Bool("copy-release-notes", false, "Add the notes of the upstream GitHub release of the tag to the created releases, with a link to it")
+	releaseChecksums = flag.Bool("release-checksums", false, "Attach the checksums of the module zips of each published tag to the created releases, as checksums.txt and go.sum")
+	cosignKey        = flag.String("cosign-key", "", "Key to sign the checksums.txt of --release-checksums with cosign sign-blob, attached as checksums.txt.sig, a file or a KMS URI, its password in COSIGN_PASSWORD")
+	repairMode       = flag.Bool("repair", false, "Only reconcile the workdir and the worker repos with the target and exit: push the tags of failed pushes again and delete the local tags left by unfinished tags")
+	nothingToDoCode  = flag.Bool("exit-code", false, "Exit with 3 instead of 0 when there are no upstream tags to handle")
+	maxDuration      = flag.Duration("max-duration", 0, "Stop starting new tags this long after the start of the run, running tags are still finished and pushed, like 50m for a CI job limited to an hour")
+	uiMode           = flag.String("ui", "auto", "Output of sync: live shows a status line of the running tags below colored logs, plain only logs, auto is live on a terminal")
+	pipelineFlag     = flag.String("pipeline", "", "Comma separated order of the steps handling each tag, empty for the default order: "+stepNames(pipelineSteps))
+	skipSteps        = flag.String("skip-steps", "", "Comma separated steps of the pipeline not to run, like zip-check or pre-push, the rewrite, tag and push steps can't be skipped")
+	tagEnvFlag       = flag.String("tag-env", "", "Environment variables of the git and go commands run for the upstream tags, separated by ; or newlines: <name>=<value>, optionally followed by if <version range>, like GOFLAGS=-mod=mod if <1.20.0")
+	rewriteRulesFlag = flag.String("rewrite-rules", "", "Rules applied to each rewritten go.mod, separated by ; or newlines: drop-replace <module pattern>, pin <module pattern> <version>, require <module> <version>, exclude <module> <version>, drop-godebug <key pattern>, drop-tool <package pattern> or go <version>, optionally followed by in <module pattern> and if <version range>")
+	rewritePlugins   = flag.String("rewrite-plugins", "", "Comma separated executables to run on each rewritten go.mod before it is tidied, they get the module as JSON on stdin and print the changes to make")
+	preRewriteHook   = flag.String("hook-pre-rewrite", "", "Executable to run in the worktree after the checkout of each tag, its changes are committed with the rewrite")
+	postRewriteHook  = flag.String("hook-post-rewrite", "", "Executable to run in the worktree after the go.mod rewrite of each tag, its changes are committed with the rewrite")
+	prePushHook      = flag.String("hook-pre-push", "", "Executable to run in the worktree before pushing each tag, failing stops the push")
+	postPublishHook  = flag.String("hook-post-publish", "", "Executable to run, or http(s) URL to POST to, with a JSON payload of each published tag on stdin, to trigger downstream automation")
+	pushgatewayURL   = flag.String("pushgateway-url", "", "Prometheus Pushgateway to push the metrics of each run to, like http://pushgateway:9091")
+	pushgatewayJob   = flag.String("pushgateway-job", "kksyncer", "Job the metrics are pushed as, replacing the ones of the previous run of the job")
+	statsdAddr       = flag.String("statsd-address", "", "StatsD or Datadog agent host:port to send the metrics of each run to over UDP, like localhost:8125")
+	statsdPrefix     = flag.String("statsd-prefix", "kksyncer", "Prefix of the StatsD metric names")
+	statsdTags       = flag.Bool("statsd-tags", false, "Send the labels of the metrics as DogStatsD tags instead of appending them to the names")
+	logFile          = flag.String("log-file", "", "File to also write the logs to, without colors, for standalone deployments without a log shipper")
+	logFileMaxSize   = flag.Int64("log-file-max-size", 100, "Size in MiB at which --log-file is rotated to <name>-<time><ext>, 0 never rotates")
+	logFileMaxAge    = flag.Duration("log-file-max-age", 0, "Remove rotated log files older than this, like 720h, 0 keeps them")
+	logFileBackups   = flag.Int("log-file-max-backups", 0, "Number of rotated log files to keep, 0 keeps all")
+	syslogTarget     = flag.String("syslog", "", "Also write the logs with their priorities to local, the local syslog daemon, journald, or <network>://<address> of a remote syslog, like udp://logs.example.com:514")
+	syslogTag        = flag.String("syslog-tag", "kksyncer", "Tag, or identifier, of the logs written to --syslog")
+	sentryDSN        = flag.String("sentry-dsn", "", "Sentry DSN to report errors to, like the failures of tags with their phase, and fatal errors")
+	syncInterval     = flag.Duration("interval", 0, "Run sync as a service, again this long after each run, reloading --config on SIGHUP or when it changes, 0 runs once")
+	profileRun       = flag.Bool("profile-run", false, "Print the wall and CPU time of each phase of each tag after the run, like fetch, checkout, rewrite with go mod tidy, commit and push")
+	keepGoing        = flag.Bool("keep-going", false, "Keep handling the other tags after a tag fails")
+	tagOrder         = flag.String("order", "oldest-first", "Order to handle the tags in by version: oldest-first, or newest-first to publish the latest releases before backfilling the older ones")
+	tempWorktrees    = flag.Bool("temp-worktrees", false, "Handle each tag in a temporary clone under --worker-dir, leaving the workdir untouched")
+	bareWorkdir      = flag.Bool("bare-workdir", false, "Clone the workdir as a bare repository, implies --temp-worktrees")
+	profileName      = flag.String("profile", "kubernetes", "Upstream profile: kubernetes, openshift, monorepo or generic")
+	profileFile      = flag.String("profile-file", "", "JSON file overriding fields of the profile: sourceRepo, minVersion, pinVersion and replaces")
+	minVersionFlag   = flag.String("min-version", "", "First upstream version to handle, defaults to the profile's, v1.26.0 for kubernetes")
+	pinVersionFlag   = flag.String("pin-version", "", "Version to pin the requires of replaced modules to, defaults to the profile's: v0 maps v1.X.Y to v0.X.Y like the kubernetes staging modules, tag uses the upstream tag, offset:<major>.<minor> adds offsets to its major and minor versions, template:<template> is a text/template of .Major, .Minor, .Patch, .Prerelease and .Tag")
+	modfileGlob      = flag.String("modfile-glob", "go.mod", "Comma separated patterns of the go.mod files to rewrite, like go.mod,staging/src/k8s.io/*/go.mod, ** matches any number of directories")
+	moduleTagsFlag   = flag.String("module-tags", "", "Comma separated directories of rewritten nested modules to also tag as <dir>/<version>-mod on each rewrite commit, like staging/src/k8s.io/api, so the go command resolves them, optionally followed by =<pin version> for their version, defaults to the pin version of the profile")
 )
 
 func remoteTags(r *gogit.Repository, remote string) (map[string]plumbing.Hash, error) {
@@ -52,213 +170,867 @@ func remoteTags(r *gogit.Repository, remote string) (map[string]plumbing.Hash, e
 	return tagCommits, err
 }
 
-func ensureRepo(dir string) error {
+func ensureRepo(ctx context.Context, dir string) error {
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		return os.MkdirAll(dir, 0755)
 	}
-	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
-		logrus.Infof("Cloning %s to %s", *sourceRepo, dir)
-		cmd := exec.Command("git", "clone", *sourceRepo, dir)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to clone %s: %v", *sourceRepo, err)
+	// a bare repository has its HEAD at the top, openWorkdir checks it
+	// matches --bare-workdir
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	if _, berr := os.Stat(filepath.Join(dir, "HEAD")); berr == nil {
+		err = nil
+	}
+	if os.IsNotExist(err) && (*fetchTags != "" || *maxTransferRate != "") {
+		return initWorkdir(ctx, dir)
+	}
+	if os.IsNotExist(err) {
+		// git removes the directory it created when a clone fails
+		return withSourceFailover(ctx, "clone", func(url string) error {
+			logrus.Infof("Cloning %s to %s", url, dir)
+			cmd := commandContext(ctx, "git", "clone", "--quiet", url, dir)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if pw := newProgress("clone"); pw != nil {
+				cmd.Args = []string{"git", "clone", "--progress", url, dir}
+				cmd.Stderr = pw
+			}
+			if *bareWorkdir {
+				cmd.Args = slices.Insert(cmd.Args, 2, "--bare")
+			}
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("failed to clone %s: %v", url, err)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// ensureRemote makes sure remote name exists in r and points to url.
+func ensureRemote(r *gogit.Repository, name, url string) error {
+	if url == "" {
+		return fmt.Errorf("remote %s URL is empty", name)
+	}
+	rm, _ := r.Remote(name)
+	if rm != nil && rm.Config().URLs[0] != url {
+		logrus.Infof("Deleting invalid remote %s", name)
+		if err := r.DeleteRemote(name); err != nil {
+			return fmt.Errorf("failed to delete remote %s: %v", name, err)
+		}
+		rm = nil
+	}
+	if rm == nil {
+		_, err := r.CreateRemote(&config.RemoteConfig{
+			Name: name,
+			URLs: []string{url},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set remote %s %s: %v", name, url, err)
 		}
 	}
 	return nil
 }
 
 func main() {
-	flag.Parse()
-	err := ensureRepo(*workdir)
+	name, args := "sync", os.Args[1:]
+	flag.Usage = usage
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if len(os.Args) > 1 && name == os.Args[1] {
+		flag.Usage = func() { commandUsage(cmd) }
+	}
+	_ = flag.CommandLine.Parse(args)
+	ctx := signalContext()
+	if cmd.bare {
+		if err := cmd.run(ctx); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	if err := applyConfig(); err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+	if err := setupLogFile(); err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+	if err := setupSyslog(); err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+	if err := setupSentry(); err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+	if err := setupFlags(); err != nil {
+		fatalf(exitConfig, "%v", err)
+	}
+	if err := cmd.run(ctx); err != nil {
+		fatal(err)
+	}
+}
+
+// setupFlags validates the flags and sets up what derives from them, at the
+// start and when the service reloads its config.
+func setupFlags() error {
+	if !slices.Contains([]string{"tidy", "mvs", "verify"}, *sumMode) {
+		return fmt.Errorf("invalid sum mode %q", *sumMode)
+	}
+	if *skipTidy && (*sumMode != "tidy" || *reuseTidy) {
+		return fmt.Errorf("--skip-tidy can't be used with --sum-mode or --reuse-tidy")
+	}
+	if !slices.Contains([]string{"off", "warn", "fail"}, *tidyDrift) {
+		return fmt.Errorf("invalid tidy drift mode %q", *tidyDrift)
+	}
+	if !slices.Contains([]string{"off", "report", "fail", "force"}, *tagDivergence) {
+		return fmt.Errorf("invalid tag divergence mode %q", *tagDivergence)
+	}
+	if *tagDivergence == "force" && *publishVia == "github-api" {
+		return fmt.Errorf("--tag-divergence=force can't replace tags with --publish-via=github-api")
+	}
+	if _, err := workerBase(); err != nil {
+		return err
+	}
+	if err := checkOutputPaths(); err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"off", "remove", "rewrite"}, *goWork) {
+		return fmt.Errorf("invalid go.work mode %q", *goWork)
+	}
+	if err := checkGoVersions(); err != nil {
+		return err
+	}
+	if !slices.Contains(vulnLevels, *vulnFailOn) {
+		return fmt.Errorf("invalid vulnerability level %q", *vulnFailOn)
+	}
+	if !slices.Contains([]string{"json", "markdown"}, *licenseFormat) {
+		return fmt.Errorf("invalid license report format %q", *licenseFormat)
+	}
+	if !slices.Contains([]string{"spdx", "cyclonedx"}, *sbomFormat) {
+		return fmt.Errorf("invalid SBOM format %q", *sbomFormat)
+	}
+	if !slices.Contains([]string{"git", "github-api"}, *publishVia) {
+		return fmt.Errorf("invalid publish mode %q", *publishVia)
+	}
+	if *publishVia == "github-api" && (*provenanceOn || *mirrorAll || *metaBranch != "") {
+		return fmt.Errorf("--provenance, --mirror and --meta-branch can't be published with --publish-via=github-api")
+	}
+	if *publishVia == "github-api" && *moduleTagsFlag != "" {
+		return fmt.Errorf("--module-tags can't be published with --publish-via=github-api, which only creates the tag of the rewrite")
+	}
+	if !strings.HasPrefix(*targetRefPrefix, "refs/") || !strings.HasSuffix(*targetRefPrefix, "/") {
+		return fmt.Errorf("invalid target ref prefix %q, want refs/<namespace>/", *targetRefPrefix)
+	}
+	var err error
+	if rewriteRules, err = parseRules(*rewriteRulesFlag); err != nil {
+		return err
+	}
+	if policyRules, err = parsePolicy(*policyFlag); err != nil {
+		return err
+	}
+	if moduleTagRules, err = parseModuleTags(*moduleTagsFlag); err != nil {
+		return err
+	}
+	if tagEnvRules, err = parseTagEnv(*tagEnvFlag); err != nil {
+		return err
+	}
+	if err = checkCanaryRepos(); err != nil {
+		return err
+	}
+	if tagPipeline, err = buildPipeline(*pipelineFlag, *skipSteps); err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"auto", "live", "plain"}, *uiMode) {
+		return fmt.Errorf("invalid UI %q", *uiMode)
+	}
+	if *bareWorkdir {
+		*tempWorktrees = true
+	}
+	if err = setupTransferRate(); err != nil {
+		return err
+	}
+	if err = checkFetchPatterns(); err != nil {
+		return err
+	}
+	if err = checkExtraRefSpecs(); err != nil {
+		return err
+	}
+	if err = checkLFSMode(); err != nil {
+		return err
+	}
+	if err = checkSubmoduleRewrites(); err != nil {
+		return err
+	}
+	if *copyReleaseNotes && !*createReleases {
+		return fmt.Errorf("--copy-release-notes needs --create-releases")
+	}
+	if err = checkStateBackend(); err != nil {
+		return err
+	}
+	if *releaseChecksums && !*createReleases {
+		return fmt.Errorf("--release-checksums needs --create-releases")
+	}
+	if *cosignKey != "" && !*releaseChecksums {
+		return fmt.Errorf("--cosign-key needs --release-checksums")
+	}
+	if err = setupSignedPush(); err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"plain", "conventional"}, *commitStyle) {
+		return fmt.Errorf("invalid commit style %q", *commitStyle)
+	}
+	if !slices.Contains([]string{"oldest-first", "newest-first"}, *tagOrder) {
+		return fmt.Errorf("invalid order %q", *tagOrder)
+	}
+	if *linearHistory && *tagOrder != "oldest-first" {
+		return fmt.Errorf("--linear-history needs --order=oldest-first, to chain the tags in order")
+	}
+	if *linearHistory && (*numWorkers != 1 || *tempWorktrees) {
+		// temporary worktrees drop the commits the next tag builds on
+		return fmt.Errorf("--linear-history needs --workers=1 without --temp-worktrees, to publish the tags in order")
+	}
+	if err = checkTargetSubdir(); err != nil {
+		return err
+	}
+	if err = setupProfile(); err != nil {
+		return err
+	}
+	if err = setupGoLimits(); err != nil {
+		return err
+	}
+	setupForge()
+	return nil
+}
+
+// newestPublished returns the commit of the newest published tag of target
+// by version, the head of the linear history, or the zero hash if there is
+// none.
+func newestPublished(r *gogit.Repository, target map[string]plumbing.Hash) (plumbing.Hash, error) {
+	var newest string
+	for tag := range target {
+		name, ok := strings.CutSuffix(tag, "-mod")
+		if ok && semver.IsValid(name) && (newest == "" || semver.Compare(name, newest) > 0) {
+			newest = name
+		}
+	}
+	if newest == "" {
+		return plumbing.ZeroHash, nil
+	}
+	c, err := tagCommit(r, target[newest+"-mod"])
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get commit of %s-mod: %v", newest, err)
+	}
+	return c.Hash, nil
+}
+
+// pushRefs pushes refSpecs to the target, logging the progress as name, and
+// records the push in the audit trail.
+func pushRefs(ctx context.Context, r *gogit.Repository, name string, refSpecs []config.RefSpec) error {
+	auth, err := getAuth()
 	if err != nil {
-		logrus.Fatalf("Failed to ensure repo: %v", err)
+		return err
+	}
+	if *signedPush != "" {
+		err = pushSigned(ctx, r, auth, refSpecs)
+	} else {
+		err = r.PushContext(ctx, &gogit.PushOptions{
+			RemoteName:   targetRemote,
+			Auth:         auth,
+			RefSpecs:     refSpecs,
+			Progress:     newProgress("push " + name),
+			ProxyOptions: transferProxy(targetRemote),
+		})
+	}
+	if errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		err = nil
+	}
+	if errors.Is(err, transport.ErrAuthenticationRequired) || errors.Is(err, transport.ErrAuthorizationFailed) {
+		err = withExitCode(exitAuth, err)
+	}
+	var refs []string
+	for _, rs := range refSpecs {
+		_, dst, _ := strings.Cut(string(rs), ":")
+		refs = append(refs, dst)
+	}
+	recordPush("git", refs, err)
+	return err
+}
+
+// remoteRefPrefix returns the namespace of the tags of the remote name, the
+// ones of the target are fetched from --target-ref-prefix.
+func remoteRefPrefix(name string) string {
+	if name == sourceRemote {
+		return "refs/tags/"
+	}
+	return *targetRefPrefix
+}
+
+// remoteURL returns the URL of the remote name.
+func remoteURL(name string) string {
+	if name == sourceRemote {
+		return *sourceRepo
+	}
+	return *targetRepo
+}
+
+// openWorkdir opens the workdir, cloning it if needed, and fetches the tags
+// of the given remotes.
+func openWorkdir(ctx context.Context, remotes ...string) (*gogit.Repository, error) {
+	err := ensureRepo(ctx, *workdir)
+	if err != nil {
+		return nil, withExitCode(exitEnvironment, fmt.Errorf("failed to ensure repo: %v", err))
 	}
 	r, err := gogit.PlainOpen(*workdir)
 	if err != nil {
-		logrus.Fatalf("Failed to open repo at %s: %v", *workdir, err)
+		return nil, withExitCode(exitEnvironment, fmt.Errorf("failed to open repo at %s: %v", *workdir, err))
+	}
+	if _, err = r.Worktree(); errors.Is(err, gogit.ErrIsBareRepository) != *bareWorkdir {
+		return nil, withExitCode(exitEnvironment, fmt.Errorf("workdir %s doesn't match --bare-workdir=%t, remove it to clone it again", *workdir, *bareWorkdir))
 	}
 
-	// set remote
-	for _, remote := range []struct{ name, url string }{
-		{sourceRemote, *sourceRepo},
-		{targetRemote, *targetRepo},
-	} {
-		if remote.url == "" {
-			logrus.Fatalf("Remote %s URL is empty", remote.name)
+	repo := goGitRepo{r}
+	for _, name := range remotes {
+		if err = ensureRemote(r, name, remoteURL(name)); err != nil {
+			return nil, err
 		}
-		rm, _ := r.Remote(remote.name)
-		if rm != nil && rm.Config().URLs[0] != remote.url {
-			logrus.Infof("Deleting invalid remote %s", remote.name)
-			err = r.DeleteRemote(remote.name)
-			if err != nil {
-				logrus.Fatalf("Failed to delete remote %s: %v", remote.name, err)
-			}
-			rm = nil
+		fetch := func(url string) error {
+			return repo.fetch(ctx, name, url)
 		}
-		if rm == nil {
-			_, err = r.CreateRemote(&config.RemoteConfig{
-				Name: remote.name,
-				URLs: []string{remote.url},
-			})
-			if err != nil {
-				logrus.Fatalf("Failed to set remote %s %s: %v", remote.name, remote.url, err)
-			}
+		if name == sourceRemote {
+			err = withSourceFailover(ctx, "fetch", fetch)
+		} else {
+			err = fetch(remoteURL(name))
 		}
-		err = r.Fetch(&gogit.FetchOptions{
-			RemoteName: remote.name,
-			RefSpecs: []config.RefSpec{
-				config.RefSpec("refs/tags/*:refs/tags/" + remote.name + "/*"),
-			},
-		})
-		if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
-			logrus.Fatalf("Failed to fetch %s: %v", remote.name, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", name, err)
 		}
 	}
+	return r, nil
+}
 
-	sourceTagCommits, err := remoteTags(r, sourceRemote)
+func runSync(ctx context.Context) error {
+	started := time.Now()
+	filter, err := newTagFilter()
+	if err != nil {
+		return err
+	}
+	if _, err = getKeyring(); err != nil {
+		return err
+	}
+	if _, err = getSigner(); err != nil {
+		return err
+	}
+	if _, err = getAuth(); err != nil {
+		return err
+	}
+	endFetch := timePhase("fetch")
+	r, err := openWorkdir(ctx, sourceRemote, targetRemote)
+	endFetch()
+	if err != nil {
+		return err
+	}
+	state, err := openState(r)
 	if err != nil {
-		logrus.Fatalf("Failed to iterate through %s tags: %v", sourceRemote, err)
+		return withExitCode(exitConfig, err)
 	}
-	for name, kh := range sourceTagCommits {
-		// ignore non-annotated tags
-		// this logic is from publishing-bot
-		_, err := r.TagObject(kh)
+	if state != nil {
+		unlock, err := lockState(state)
 		if err != nil {
-			delete(sourceTagCommits, name)
-			continue
+			return withExitCode(exitEnvironment, err)
 		}
-		// after https://github.com/kubernetes/kubernetes/commit/0737e92da613568379d29db8ec18f2ecc240898d
-		if semver.Compare(name, "v1.26.0") < 0 {
-			delete(sourceTagCommits, name)
-			continue
+		defer unlock()
+		if *historyDB != "" {
+			if err = loadHistory(state); err != nil {
+				return fmt.Errorf("failed to load the history from the state: %v", err)
+			}
 		}
 	}
 
-	targetTagCommits, err := remoteTags(r, targetRemote)
+	if *repairMode {
+		return repairWorkdir(ctx, r)
+	}
+
+	repo := goGitRepo{r}
+	sourceTagCommits, err := repo.tags(sourceRemote)
 	if err != nil {
-		logrus.Fatalf("Failed to iterate through %s tags: %v", targetRemote, err)
+		return withExitCode(exitEnvironment, fmt.Errorf("failed to iterate through %s tags: %v", sourceRemote, err))
 	}
-	tagsToCopy := map[string]plumbing.Hash{}
-	for name := range sourceTagCommits {
-		if _, ok := targetTagCommits[name+"-mod"]; !ok {
-			tagsToCopy[name] = sourceTagCommits[name]
-		}
+	targetTagCommits, err := repo.tags(targetRemote)
+	if err != nil {
+		return withExitCode(exitEnvironment, fmt.Errorf("failed to iterate through %s tags: %v", targetRemote, err))
+	}
+	// the tags of failed pushes are published before planning the others
+	pushed, err := retryUnpushed(ctx, r)
+	if err != nil {
+		logrus.Warnf("Failed to push the refs of failed pushes again: %v", err)
 	}
-	logrus.Infof("%d tags to copy: %s", len(tagsToCopy), strings.Join(slices.Sorted(maps.Keys(tagsToCopy)), ", "))
+	maps.Copy(targetTagCommits, pushed)
+	plan := planTags(sourceTagCommits, targetTagCommits, filter, func(h plumbing.Hash) bool {
+		_, err := r.TagObject(h)
+		return err == nil
+	})
+	summary := newRunSummary(plan)
+	summary.logSkipped()
+	tagsToCopy := pendingTags(plan)
+	logrus.Infof("%d tags to copy: %s", len(tagsToCopy), strings.Join(orderTags(maps.Keys(tagsToCopy)), ", "))
 
-	for name, kh := range tagsToCopy {
-		err = handleTag(r, name, kh)
-		if err != nil {
-			logrus.Fatalf("Failed to handle tag %s: %v", name, err)
+	var retracted []string
+	if *propagateRetract {
+		retracted = deletedTags(sourceTagCommits, targetTagCommits)
+		if len(retracted) > 0 {
+			logrus.Infof("Retracting deleted upstream tags: %s", strings.Join(retracted, ", "))
 		}
 	}
-}
+	republish, divErr := checkDivergence(ctx, r, plan, summary)
+	maps.Copy(tagsToCopy, republish)
 
-func prepareModFile(fileSystem billy.Filesystem, tag string) error {
-	tag = "v0" + strings.TrimPrefix(tag, "v1")
-	b, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.mod"))
+	workers, err := setupWorkers(r)
 	if err != nil {
-		return fmt.Errorf("Failed to read go.mod: %v", err)
+		return withExitCode(exitEnvironment, fmt.Errorf("failed to set up workers: %v", err))
+	}
+	// the mirror goes first, so the target has the upstream commits the
+	// rewrite commits build on
+	if *mirrorAll {
+		if err = mirrorRefs(ctx, r); err != nil {
+			return err
+		}
+	}
+	if *prewarmCache {
+		prewarmModCache(ctx, r, workers, tagsToCopy)
+	}
+	run := &syncRun{retracted: retracted, summary: summary, republish: republish, workdir: r}
+	if *maxDuration > 0 {
+		run.deadline = started.Add(*maxDuration)
+	}
+	if *linearHistory {
+		if run.linearHead, err = newestPublished(r, targetTagCommits); err != nil {
+			return err
+		}
+	}
+	setupUI()
+	err = runWorkers(ctx, workers, tagsToCopy, run)
+	live.close()
+	err = errors.Join(err, divErr)
+	if *releaseBranches {
+		if berr := updateReleaseBranches(ctx, r, summary.Published); berr != nil {
+			logrus.Errorf("Failed to update release branches: %v", berr)
+			err = errors.Join(err, berr)
+		}
+	}
+	if *canaryRepos != "" && ctx.Err() == nil {
+		openCanaryPRs(ctx, summary.Published)
+	}
+	if *metaBranch != "" {
+		if merr := writeMetadata(ctx, r, plan, summary, started); merr != nil {
+			logrus.Errorf("Failed to record the run on %s: %v", *metaBranch, merr)
+			err = errors.Join(err, merr)
+		}
+	}
+	if *historyDB != "" {
+		herr := recordHistory(summary, started, err)
+		if herr == nil && state != nil {
+			herr = saveHistory(state)
+		}
+		if herr != nil {
+			logrus.Warnf("Failed to record the run in %s: %v", *historyDB, herr)
+		}
+	}
+	summary.log()
+	printRunProfile()
+	emitMetrics(summary, started, err)
+	if err != nil && len(summary.Published) > 0 {
+		return withExitCode(exitPartial, err)
+	}
+	if err == nil && len(tagsToCopy) == 0 && *nothingToDoCode {
+		return withExitCode(exitNothingToDo, errNothingToDo)
 	}
+	return err
+}
+
+// modRewrite is a go.mod rewritten by rewriteModFile.
+type modRewrite struct {
+	file *modfile.File
+	// the sorted paths of the replaced modules pinned to the version
+	pinned []string
+	// the requires pinned by the rewrite rules
+	pins []module.Version
+	// whether a module with a major version suffix isn't pinned to the
+	// version
+	otherMajor bool
+}
+
+// rewriteModFile rewrites the go.mod b of upstream tag, before the plugins
+// and tidy: the replaces of dropReplace are dropped, pinning the requires of
+// the replaced modules to version, and the rewrite rules are applied. It
+// only depends on b, tag, version and the rewrite flags, not on the tree.
+func rewriteModFile(b []byte, tag, version string) (*modRewrite, error) {
 	modFile, err := modfile.Parse("go.mod", b, nil)
 	if err != nil {
-		return fmt.Errorf("failed to parse go.mod: %v", err)
+		return nil, fmt.Errorf("failed to parse go.mod: %v", err)
 	}
 
 	requires := map[string]*modfile.Require{}
 	for _, require := range modFile.Require {
 		requires[require.Mod.Path] = require
 	}
+	var pinned []string
+	// whether a module with a major version suffix isn't pinned to version
+	otherMajor := false
 	for _, replace := range modFile.Replace {
+		if !dropReplace(replace) {
+			continue
+		}
 		if _, ok := requires[replace.Old.Path]; ok {
-			requires[replace.Old.Path].Mod.Version = tag
+			v, err := majorVersion(replace.Old.Path, version, tag)
+			if err != nil {
+				return nil, err
+			}
+			otherMajor = otherMajor || v != version
+			requires[replace.Old.Path].Mod.Version = v
 			modFile.SetRequire(slices.Collect(maps.Values(requires)))
+			pinned = append(pinned, replace.Old.Path)
 		}
 		_ = modFile.DropReplace(replace.Old.Path, replace.Old.Version)
 	}
+	slices.Sort(pinned)
+	pins, err := applyRules(modFile, tag, version)
+	if err != nil {
+		return nil, err
+	}
+	return &modRewrite{file: modFile, pinned: pinned, pins: pins, otherMajor: otherMajor}, nil
+}
+
+func prepareModFile(ctx context.Context, fileSystem billy.Filesystem, tag string, env []string) ([]module.Version, error) {
+	version, err := pinVersion(tag)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.mod"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read go.mod: %v", err)
+	}
+	rw, err := rewriteModFile(b, tag, version)
+	if err != nil {
+		return nil, err
+	}
+	modFile, pinned, pins := rw.file, rw.pinned, rw.pins
+	if err = applyPlugins(ctx, fileSystem, modFile, tag, version); err != nil {
+		return nil, err
+	}
+
+	if *skipTidy {
+		upstream, err := modfile.Parse("go.mod", b, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse go.mod: %v", err)
+		}
+		modFile.Cleanup()
+		sum, err := staticSum(fileSystem.Root(), upstream, modFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build go.sum without tidy: %v", err)
+		}
+		out, err := modFile.Format()
+		if err != nil {
+			return nil, fmt.Errorf("failed to format go.mod: %v", err)
+		}
+		if err = writeFile(fileSystem, "go.mod", out); err != nil {
+			return nil, err
+		}
+		return pins, writeFile(fileSystem, "go.sum", sum)
+	}
+
+	// the tidy results are reused by upstream go.mod, which doesn't cover
+	// the changes of plugins, rules and environments depending on the tag,
+	// nor modules pinned to another version
+	reuse := *reuseTidy && *sumMode != "verify" && *rewritePlugins == "" && !rulesVaryByTag() && !tagEnvVaries() && !rw.otherMajor
+	if reuse {
+		if mod, sum, ok := reuseTidyResult(b, version, pinned); ok {
+			if err = writeFile(fileSystem, "go.mod", mod); err != nil {
+				return nil, err
+			}
+			return pins, writeFile(fileSystem, "go.sum", sum)
+		}
+	}
 
 	modFile.Cleanup()
+	var sum []byte
+	if *sumMode != "tidy" {
+		// mvsSum raises the requires in place, tidy starts over from the
+		// rewritten go.mod
+		rewritten, err := modFile.Format()
+		if err != nil {
+			return nil, fmt.Errorf("failed to format go.mod: %v", err)
+		}
+		if sum, err = mvsSum(modFile); err != nil {
+			logrus.Warnf("Failed to resolve go.sum of %s without tidy, falling back to go mod tidy: %v", tag, err)
+			if modFile, err = modfile.Parse("go.mod", rewritten, nil); err != nil {
+				return nil, fmt.Errorf("failed to parse go.mod: %v", err)
+			}
+		}
+	}
 	out, err := modFile.Format()
 	if err != nil {
-		return fmt.Errorf("failed to format go.mod: %v", err)
+		return nil, fmt.Errorf("failed to format go.mod: %v", err)
+	}
+	if err = writeFile(fileSystem, "go.mod", out); err != nil {
+		return nil, err
+	}
+	if sum != nil && *sumMode == "mvs" {
+		if err = writeFile(fileSystem, "go.sum", sum); err != nil {
+			return nil, err
+		}
+		if reuse {
+			storeTidyResult(b, version, pinned, out, sum)
+		}
+		return pins, nil
 	}
 
-	f, err := fileSystem.OpenFile("go.mod", os.O_RDWR|os.O_TRUNC, 0644)
+	cmd := commandContext(ctx, "go", "mod", "tidy")
+	cmd.Dir = fileSystem.Root()
+	cmd.Env = goEnv(env)
+	release := acquireGo()
+	tidyOut, err := cmd.CombinedOutput()
+	release()
 	if err != nil {
-		return fmt.Errorf("failed to open go.mod: %v", err)
+		return nil, &ErrTidyFailed{Output: strings.TrimSpace(string(tidyOut)), Err: err}
 	}
-	defer f.Close()
-	_, err = f.Write(out)
+	if err = checkTidyDrift(fileSystem.Root(), tag, out); err != nil {
+		return nil, err
+	}
+	if sum != nil {
+		verifyTidy(fileSystem, tag, out, sum)
+	}
+	if reuse {
+		mod, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.mod"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tidied go.mod: %v", err)
+		}
+		sum, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.sum"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tidied go.sum: %v", err)
+		}
+		storeTidyResult(b, version, pinned, mod, sum)
+	}
+	return pins, nil
+}
+
+func writeFile(fileSystem billy.Filesystem, name string, data []byte) error {
+	f, err := fileSystem.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to write go.mod: %v", err)
+		return fmt.Errorf("failed to open %s: %v", name, err)
 	}
-	cmd := exec.Command("go", "mod", "tidy")
-	cmd.Dir = fileSystem.Root()
-	if err = cmd.Run(); err != nil {
-		return fmt.Errorf("failed to tidy go.mod: %v", err)
+	defer f.Close()
+	if _, err = f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
 	}
 	return nil
 }
 
-func handleTag(r *gogit.Repository, name string, kh plumbing.Hash) error {
-	logrus.Infof("Handling tag %s", name)
+// verifyTidy warns about differences between the go.mod and go.sum resolved
+// without tidy and the ones go mod tidy produced.
+func verifyTidy(fileSystem billy.Filesystem, tag string, mod, sum []byte) {
+	for name, want := range map[string][]byte{"go.mod": mod, "go.sum": sum} {
+		got, err := os.ReadFile(filepath.Join(fileSystem.Root(), name))
+		if err != nil {
+			logrus.Warnf("Failed to read tidied %s: %v", name, err)
+			continue
+		}
+		missing, extra := lineDiff(string(want), string(got))
+		if len(missing)+len(extra) == 0 {
+			logrus.Infof("Tidied %s of %s matches the resolved one", name, tag)
+			continue
+		}
+		logrus.Warnf("Tidied %s of %s differs from the resolved one, only resolved: %q, only tidied: %q", name, tag, missing, extra)
+	}
+}
 
-	tag, err := r.TagObject(kh)
-	if err != nil {
-		return fmt.Errorf("failed to get tag %s: %v", name, err)
+// lineDiff returns the lines only in a and the lines only in b.
+func lineDiff(a, b string) (onlyA, onlyB []string) {
+	inA, inB := map[string]bool{}, map[string]bool{}
+	for _, l := range strings.Split(a, "\n") {
+		inA[l] = true
+	}
+	for _, l := range strings.Split(b, "\n") {
+		inB[l] = true
+		if !inA[l] {
+			onlyB = append(onlyB, l)
+		}
+	}
+	for _, l := range strings.Split(a, "\n") {
+		if !inB[l] {
+			onlyA = append(onlyA, l)
+		}
 	}
-	commit, err := tag.Commit()
+	return onlyA, onlyB
+}
+
+// rewrite is the commit of the rewrite of an upstream tag.
+type rewrite struct {
+	source *object.Commit
+	commit plumbing.Hash
+	// root of the worktree
+	root string
+	// rewritten module files, the files the rewrite changed
+	modFiles, files []string
+}
+
+// rewriteTag checks out the upstream tag name at kh in the worktree of wk and
+// commits the rewrite on top of it, with prev as the first parent if it is
+// not zero. The commit only depends on the upstream tag, prev, the rewrite
+// flags and the kksyncer version of its trailers, so it can be reproduced.
+func rewriteTag(ctx context.Context, wk *worker, name string, kh plumbing.Hash, retracted []string, prev plumbing.Hash) (*rewrite, error) {
+	r := wk.repo
+	// kh is the tag object, or the commit for lightweight tags
+	commit, err := tagCommit(r, kh)
 	if err != nil {
-		return fmt.Errorf("failed to get commit %s: %v", tag.Target, err)
+		return nil, fmt.Errorf("failed to get commit of tag %s: %v", name, err)
 	}
 
 	w, err := r.Worktree()
 	if err != nil {
-		return fmt.Errorf("failed to get worktree: %v", err)
+		return nil, fmt.Errorf("failed to get worktree: %v", err)
 	}
-	err = w.Checkout(&gogit.CheckoutOptions{
-		Hash: kh,
-	})
+	// go-git doesn't report checkout progress
+	checkoutStart := time.Now()
+	wk.setPhase("checkout")
+	if err = wk.git.checkout(commit.Hash); err != nil {
+		return nil, fmt.Errorf("failed to checkout: %v", err)
+	}
+	if *showProgress {
+		logrus.Infof("Checked out %s in %s", name, time.Since(checkoutStart).Round(time.Millisecond))
+	}
+	if *submodulesOn {
+		wk.setPhase("submodules")
+		if err = updateSubmodules(ctx, w.Filesystem.Root(), wk.env); err != nil {
+			return nil, err
+		}
+	}
+	var lfsPointers []string
+	if *lfsMode != "off" {
+		wk.setPhase("lfs")
+		if lfsPointers, err = findLFSPointers(w.Filesystem.Root()); err != nil {
+			return nil, fmt.Errorf("failed to find LFS pointer files: %v", err)
+		}
+	}
+	if len(lfsPointers) > 0 {
+		switch *lfsMode {
+		case "warn":
+			logrus.Warnf("Tag %s has %d Git LFS pointer files, published as pointers: %s", name, len(lfsPointers), formatPaths(lfsPointers))
+		case "fail":
+			return nil, fmt.Errorf("tag %s has %d Git LFS pointer files: %s", name, len(lfsPointers), formatPaths(lfsPointers))
+		case "fetch":
+			if err = pullLFS(ctx, w.Filesystem.Root(), wk.env); err != nil {
+				return nil, err
+			}
+		}
+	}
+	hook := hookInfo{worker: wk.id, tag: name, source: commit.Hash.String()}
+	if err = runHook(ctx, hookPreRewrite, w.Filesystem.Root(), hook); err != nil {
+		return nil, err
+	}
+
+	pruned, err := findPrunePaths(w.Filesystem.Root())
 	if err != nil {
-		return fmt.Errorf("failed to checkout: %v", err)
+		return nil, fmt.Errorf("failed to find paths to prune: %v", err)
+	}
+	if *lfsMode == "prune" {
+		pruned = appendUnpruned(pruned, lfsPointers)
+	}
+	for _, p := range pruned {
+		if _, err = w.Remove(p); err != nil {
+			return nil, fmt.Errorf("failed to prune %s: %v", p, err)
+		}
+	}
+	if len(pruned) > 0 {
+		logrus.Infof("Pruned %d paths of %s", len(pruned), name)
 	}
 
-	err = prepareModFile(w.Filesystem, name)
+	modFiles, err := findModFiles(w.Filesystem.Root())
 	if err != nil {
-		return fmt.Errorf("failed to prepare mod file: %v", err)
+		return nil, fmt.Errorf("failed to find go.mod files: %v", err)
 	}
-	_, err = w.Add("go.mod")
+	wk.setPhase("rewrite")
+	pinned, err := prepareModFiles(ctx, w.Filesystem, modFiles, name, wk.env)
 	if err != nil {
-		return fmt.Errorf("failed to add go.mod: %v", err)
+		return nil, err
+	}
+	if *propagateRetract && slices.Contains(modFiles, "go.mod") {
+		if err = propagateRetractions(w.Filesystem, retracted); err != nil {
+			return nil, fmt.Errorf("failed to propagate retractions: %v", err)
+		}
 	}
-	_, err = w.Add("go.sum")
+	var rewritten []string
+	for _, modFile := range modFiles {
+		_, err = w.Add(modFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %v", modFile, err)
+		}
+		rewritten = append(rewritten, modFile)
+		// modules without dependencies have no go.sum
+		sumFile := path.Join(path.Dir(modFile), "go.sum")
+		if !fileExists(filepath.Join(w.Filesystem.Root(), filepath.FromSlash(sumFile))) {
+			continue
+		}
+		_, err = w.Add(sumFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s: %v", sumFile, err)
+		}
+		rewritten = append(rewritten, sumFile)
+	}
+	workFiles, err := prepareGoWork(w.Filesystem)
 	if err != nil {
-		return fmt.Errorf("failed to add go.mod: %v", err)
+		return nil, fmt.Errorf("failed to prepare go.work: %v", err)
+	}
+	for _, workFile := range workFiles {
+		if err = stageFile(w, workFile); err != nil {
+			return nil, fmt.Errorf("failed to stage %s: %v", workFile, err)
+		}
+		rewritten = append(rewritten, workFile)
+	}
+	if err = runHook(ctx, hookPostRewrite, w.Filesystem.Root(), hook); err != nil {
+		return nil, err
+	}
+	if err = stageHookChanges(w.Filesystem.Root(), modFiles); err != nil {
+		return nil, err
+	}
+	if len(pruned) > 0 {
+		if err = verifyBuild(ctx, w.Filesystem.Root(), modFiles, wk.env); err != nil {
+			return nil, err
+		}
 	}
 
-	tagName := name + "-mod"
-	newCommit, err := w.Commit("Prepare "+tagName, &gogit.CommitOptions{
+	opts := &gogit.CommitOptions{
 		Author: &object.Signature{
 			Name: "kksyncer",
 			When: commit.Author.When,
 		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to commit go.mod: %v", err)
 	}
-	_, err = r.CreateTag(tagName, newCommit, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create tag %s: %v", name, err)
+	if !prev.IsZero() {
+		opts.Parents = []plumbing.Hash{prev, commit.Hash}
 	}
-	err = r.Push(&gogit.PushOptions{
-		RemoteName: targetRemote,
-		RefSpecs: []config.RefSpec{
-			config.RefSpec("refs/tags/" + tagName + ":refs/tags/" + tagName),
-		},
-	})
+	message := commitMessage(name, commit.Hash, pinned)
+	wk.setPhase("commit")
+	newCommit, err := wk.git.commit(message, opts)
 	if err != nil {
-		return fmt.Errorf("failed to push tag %s: %v", tagName, err)
+		return nil, fmt.Errorf("failed to commit go.mod: %v", err)
 	}
-	return nil
+	return &rewrite{source: commit, commit: newCommit, root: w.Filesystem.Root(), modFiles: modFiles, files: rewritten}, nil
+}
+
+// handleTag runs the pipeline on the upstream tag name at kh in the worktree
+// of wk, until ctx is canceled.
+func handleTag(ctx context.Context, wk *worker, name string, kh plumbing.Hash, run *syncRun) error {
+	logrus.Infof("Handling tag %s on worker %d", name, wk.id)
+	wk.tag = name
+	defer wk.endPhase()
+	if env := tagEnv(name); len(env) > 0 {
+		// restored for the next tag of the worker
+		base := wk.env
+		wk.env = append(slices.Clip(base), env...)
+		defer func() { wk.env = base }()
+	}
+	return runPipeline(&tagJob{ctx: ctx, wk: wk, run: run, name: name, kh: kh, started: time.Now(), tagName: name + "-mod"})
 }
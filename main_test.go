@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/modfile"
+)
+
+var update = flag.Bool("update", false, "write the golden files of the tests")
+
+// TestRewriteModFile rewrites testdata/rewrite/<profile>/<tag>.in.mod with
+// the profile for the upstream tag and compares it with <tag>.golden.mod,
+// which go test -update writes, so changes of the rewrite are reviewed as
+// diffs of the golden files.
+func TestRewriteModFile(t *testing.T) {
+	ins, err := filepath.Glob(filepath.Join("testdata", "rewrite", "*", "*.in.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ins) == 0 {
+		t.Fatal("no go.mod files in testdata/rewrite")
+	}
+	t.Cleanup(func() { activeProfile, versionMapping = profile{}, nil })
+	for _, in := range ins {
+		name := filepath.Base(filepath.Dir(in))
+		tag := strings.TrimSuffix(filepath.Base(in), ".in.mod")
+		t.Run(name+"/"+tag, func(t *testing.T) {
+			p, ok := profiles[name]
+			if !ok {
+				t.Fatalf("unknown profile %q", name)
+			}
+			mapping, err := parseVersionMapping(p.Pin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			activeProfile, versionMapping = p, mapping
+			version, err := pinVersion(tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rw, err := rewriteModFile(b, tag, version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rw.file.Cleanup()
+			got := modfile.Format(rw.file.Syntax)
+			golden := strings.TrimSuffix(in, ".in.mod") + ".golden.mod"
+			if *update {
+				if err = os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("rewrite of %s differs from %s, run go test -update to accept it:\n%s", in, golden, got)
+			}
+		})
+	}
+}
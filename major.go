@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// checkPublishedMajor returns an error if the root module at modPath can't
+// be required at the published version of tag, like a module moved to /v2
+// while the upstream still tags v1, which would publish a tag no go command
+// accepts.
+func checkPublishedMajor(modPath, tag string) error {
+	_, pathMajor, ok := module.SplitPathVersion(modPath)
+	if !ok {
+		return fmt.Errorf("invalid module path %q", modPath)
+	}
+	if err := module.CheckPathMajor(tag+"-mod", pathMajor); err != nil {
+		return fmt.Errorf("module %s can't be published as %s-mod: %v", modPath, tag, err)
+	}
+	return nil
+}
+
+// majorVersion returns the version the replaced module at modPath is pinned
+// to. Modules with a major version suffix like /v2 are pinned to tag when
+// the pin version has another major version, upstreams moving to /vN tag
+// their modules along with the repo.
+func majorVersion(modPath, version, tag string) (string, error) {
+	_, pathMajor, ok := module.SplitPathVersion(modPath)
+	if !ok {
+		return "", fmt.Errorf("invalid module path %q", modPath)
+	}
+	if module.CheckPathMajor(version, pathMajor) == nil {
+		return version, nil
+	}
+	if module.CheckPathMajor(tag, pathMajor) == nil {
+		return tag, nil
+	}
+	return "", fmt.Errorf("neither pin version %s nor tag %s is a %s version of %s", version, tag, majorOf(pathMajor), modPath)
+}
+
+// majorOf returns the major version of a path suffix like /v2 or .v3, v0 or
+// v1 without a suffix.
+func majorOf(pathMajor string) string {
+	if pathMajor == "" {
+		return "v0 or v1"
+	}
+	return semver.Major(pathMajor[1:] + ".0.0")
+}
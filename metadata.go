@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+// runReport is the report of a sync run on the metadata branch.
+type runReport struct {
+	StartedOn  time.Time   `json:"startedOn"`
+	FinishedOn time.Time   `json:"finishedOn"`
+	Summary    *runSummary `json:"summary"`
+}
+
+// versionList is versions.json on the metadata branch, the published tags
+// in the format of a Renovate custom datasource.
+type versionList struct {
+	Releases  []versionRelease `json:"releases"`
+	SourceURL string           `json:"sourceUrl,omitempty"`
+}
+
+type versionRelease struct {
+	Version          string    `json:"version"`
+	ReleaseTimestamp time.Time `json:"releaseTimestamp"`
+}
+
+// publishedVersions returns the published tags of manifest, oldest first,
+// with the time of their upstream tag.
+func publishedVersions(r *gogit.Repository, manifest []*tagInfo) versionList {
+	var published []string
+	byName := map[string]*tagInfo{}
+	for _, t := range manifest {
+		if t.Status == statusPublished {
+			published = append(published, t.Name)
+			byName[t.Name] = t
+		}
+	}
+	list := versionList{Releases: []versionRelease{}}
+	if strings.HasPrefix(*targetRepo, "https://") {
+		list.SourceURL = strings.TrimSuffix(*targetRepo, ".git")
+	}
+	for _, name := range orderTags(slices.Values(published)) {
+		t := byName[name]
+		rel := versionRelease{Version: t.Published}
+		h := plumbing.NewHash(t.Hash)
+		if tag, err := r.TagObject(h); err == nil {
+			rel.ReleaseTimestamp = tag.Tagger.When.UTC()
+		} else if c, err := r.CommitObject(h); err == nil {
+			rel.ReleaseTimestamp = c.Committer.When.UTC()
+		}
+		list.Releases = append(list.Releases, rel)
+	}
+	return list
+}
+
+// writeMetadata commits the report of the run, the manifest of all upstream
+// tags and the skipped ones to --meta-branch on the target, on top of its
+// previous commit, or as an orphan branch for the first run. Reports are
+// kept as run-<time>.json, the manifest, skip records and version list are
+// replaced.
+func writeMetadata(ctx context.Context, r *gogit.Repository, plan []*tagInfo, summary *runSummary, started time.Time) error {
+	auth, err := getAuth()
+	if err != nil {
+		return err
+	}
+	ref := plumbing.NewBranchReferenceName(*metaBranch)
+	local := plumbing.NewRemoteReferenceName(targetRemote, *metaBranch)
+	err = r.Fetch(&gogit.FetchOptions{
+		RemoteName:   targetRemote,
+		Auth:         auth,
+		RefSpecs:     []config.RefSpec{config.RefSpec("+" + ref + ":" + local)},
+		ProxyOptions: transferProxy(targetRemote),
+	})
+	// the branch doesn't exist before the first run
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) && !errors.Is(err, gogit.NoMatchingRefSpecError{}) {
+		return fmt.Errorf("failed to fetch %s: %v", *metaBranch, err)
+	}
+
+	files := map[string][]byte{}
+	var parents []plumbing.Hash
+	if prev, err := r.Reference(local, true); err == nil {
+		if files, err = commitFiles(r, prev.Hash()); err != nil {
+			return fmt.Errorf("failed to read %s: %v", *metaBranch, err)
+		}
+		parents = append(parents, prev.Hash())
+	}
+
+	manifest := make([]*tagInfo, 0, len(plan))
+	for _, t := range plan {
+		t := *t
+		if slices.Contains(summary.Published, t.Name) {
+			t.Status, t.Published = statusPublished, t.Name+"-mod"
+		}
+		manifest = append(manifest, &t)
+	}
+	finished := time.Now().UTC()
+	docs := map[string]any{
+		"manifest.json": manifest,
+		"skipped.json":  summary.Skipped,
+		"versions.json": publishedVersions(r, manifest),
+		"run-" + finished.Format("20060102T150405Z") + ".json": runReport{StartedOn: started.UTC(), FinishedOn: finished, Summary: summary},
+	}
+	for name, v := range docs {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		files[name] = append(b, '\n')
+	}
+
+	// the pushes of this run, the push of the branch itself is only in
+	// --audit-log
+	files["audit.jsonl"] = append(files["audit.jsonl"], takeAuditEntries()...)
+
+	h, err := writeCommit(r, files, fmt.Sprintf(conventional("chore(sync): record the run of ", "Sync run of ")+"%s: %d published, %d failed, %d skipped",
+		finished.Format(time.RFC3339), len(summary.Published), len(summary.Failed), len(summary.Skipped)), finished, parents...)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", *metaBranch, err)
+	}
+	if err = r.Storer.SetReference(plumbing.NewHashReference(ref, h)); err != nil {
+		return err
+	}
+	if err = pushRefs(ctx, r, *metaBranch, []config.RefSpec{config.RefSpec(ref + ":" + ref)}); err != nil {
+		return fmt.Errorf("failed to push %s: %v", *metaBranch, err)
+	}
+	logrus.Infof("Recorded the run on %s", *metaBranch)
+	return nil
+}
+
+// commitFiles returns the contents of the top-level files of commit h.
+func commitFiles(r *gogit.Repository, h plumbing.Hash) (map[string][]byte, error) {
+	c, err := r.CommitObject(h)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{}
+	for _, e := range tree.Entries {
+		blob, err := r.BlobObject(e.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if files[e.Name], err = blobContents(blob); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
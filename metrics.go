@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metric is a sample of a run, sent to the metrics sinks once it finished.
+type metric struct {
+	name   string
+	labels [][2]string
+	value  float64
+}
+
+// help texts of the metrics, for the Prometheus exposition format
+var metricHelp = map[string]string{
+	"kksyncer_run_duration_seconds":  "Duration of the last sync run.",
+	"kksyncer_run_timestamp_seconds": "Time the last sync run finished.",
+	"kksyncer_run_success":           "Whether all tags of the last sync run were published.",
+	"kksyncer_tags":                  "Upstream tags of the last sync run by status.",
+	"kksyncer_tag_duration_seconds":  "Duration of the handling of a tag in the last sync run.",
+}
+
+// runMetrics returns the metrics of a run started at started that finished
+// with err.
+func runMetrics(s *runSummary, started time.Time, err error) []metric {
+	now := time.Now()
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	metrics := []metric{
+		{name: "kksyncer_run_duration_seconds", value: now.Sub(started).Seconds()},
+		{name: "kksyncer_run_timestamp_seconds", value: float64(now.Unix())},
+		{name: "kksyncer_run_success", value: success},
+	}
+	counts := map[string]int{
+		string(statusPublished): len(s.Published),
+		"failed":                len(s.Failed),
+		"deferred":              len(s.Deferred),
+		string(statusSkipped):   len(s.Skipped),
+	}
+	for _, status := range slices.Sorted(maps.Keys(counts)) {
+		metrics = append(metrics, metric{name: "kksyncer_tags", labels: [][2]string{{"status", status}}, value: float64(counts[status])})
+	}
+	for _, tag := range slices.Sorted(maps.Keys(s.durations)) {
+		status := string(statusPublished)
+		if slices.Contains(s.Failed, tag) {
+			status = "failed"
+		}
+		metrics = append(metrics, metric{
+			name:   "kksyncer_tag_duration_seconds",
+			labels: [][2]string{{"tag", tag}, {"status", status}},
+			value:  s.durations[tag].Seconds(),
+		})
+	}
+	return metrics
+}
+
+// emitMetrics sends the metrics of the run to the configured sinks. Sinks
+// failing only log, the run already happened.
+func emitMetrics(s *runSummary, started time.Time, err error) {
+	if *pushgatewayURL == "" && *statsdAddr == "" {
+		return
+	}
+	metrics := runMetrics(s, started, err)
+	if *pushgatewayURL != "" {
+		if perr := pushMetrics(metrics); perr != nil {
+			logrus.Warnf("Failed to push metrics to %s: %v", redactURL(*pushgatewayURL), perr)
+		}
+	}
+	if *statsdAddr != "" {
+		if serr := sendStatsd(metrics); serr != nil {
+			logrus.Warnf("Failed to send metrics to %s: %v", *statsdAddr, serr)
+		}
+	}
+}
+
+// redactURL returns s with the password of its credentials masked.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// pushMetrics replaces the metrics of --pushgateway-job on the Prometheus
+// Pushgateway of --pushgateway-url, for runs that have no scrape target.
+func pushMetrics(metrics []metric) error {
+	var b bytes.Buffer
+	typed := map[string]bool{}
+	for _, m := range metrics {
+		if !typed[m.name] {
+			typed[m.name] = true
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", m.name, metricHelp[m.name], m.name)
+		}
+		b.WriteString(m.name)
+		if len(m.labels) > 0 {
+			var labels []string
+			for _, l := range m.labels {
+				labels = append(labels, l[0]+`="`+labelEscaper.Replace(l[1])+`"`)
+			}
+			b.WriteString("{" + strings.Join(labels, ",") + "}")
+		}
+		fmt.Fprintf(&b, " %g\n", m.value)
+	}
+	u := strings.TrimSuffix(*pushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(*pushgatewayJob)
+	req, err := http.NewRequest(http.MethodPut, u, &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", redactURL(u), resp.Status)
+	}
+	logrus.Infof("Pushed %d metrics to %s", len(metrics), redactURL(*pushgatewayURL))
+	return nil
+}
+
+// largest StatsD packet, to fit in an Ethernet frame
+const maxStatsdPacket = 1432
+
+// sendStatsd sends the metrics over UDP to the StatsD server of
+// --statsd-address, durations as timings in milliseconds and the others as
+// gauges. Labels are DogStatsD tags with --statsd-tags, otherwise they are
+// appended to the name, like kksyncer.tags.published.
+func sendStatsd(metrics []metric) error {
+	conn, err := net.Dial("udp", *statsdAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var packet []byte
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+	for _, m := range metrics {
+		name := strings.TrimPrefix(m.name, "kksyncer_")
+		value, kind := m.value, "g"
+		if n, ok := strings.CutSuffix(name, "_duration_seconds"); ok {
+			name, value, kind = n+"_duration", m.value*1000, "ms"
+		}
+		if *statsdPrefix != "" {
+			name = strings.TrimSuffix(*statsdPrefix, ".") + "." + name
+		}
+		var tags []string
+		for _, l := range m.labels {
+			if *statsdTags {
+				tags = append(tags, l[0]+":"+l[1])
+			} else {
+				name += "." + statsdEscaper.Replace(l[1])
+			}
+		}
+		line := fmt.Sprintf("%s:%g|%s", name, value, kind)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		if len(packet)+len(line)+1 > maxStatsdPacket {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	if err = flush(); err != nil {
+		return err
+	}
+	logrus.Infof("Sent %d metrics to %s", len(metrics), *statsdAddr)
+	return nil
+}
+
+// statsdEscaper replaces the separators of the StatsD line format in names
+var statsdEscaper = strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", "#", "_", " ", "_", "\n", "_")
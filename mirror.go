@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/sirupsen/logrus"
+)
+
+// mirrorRefs pushes the upstream branches and tags verbatim to the target,
+// along with the published -mod tags, so it is a complete fork. Refs deleted
+// upstream are left on the target, pruning them would also drop the -mod
+// tags and release branches.
+func mirrorRefs(ctx context.Context, r *gogit.Repository) error {
+	err := withSourceFailover(ctx, "fetch the branches", func(url string) error {
+		err := r.FetchContext(ctx, &gogit.FetchOptions{
+			RemoteName:   sourceRemote,
+			RemoteURL:    url,
+			Prune:        true,
+			Progress:     newProgress("fetch " + sourceRemote + " branches"),
+			RefSpecs:     []config.RefSpec{config.RefSpec("+refs/heads/*:refs/remotes/" + sourceRemote + "/*")},
+			ProxyOptions: transferProxyURL(url),
+		})
+		if errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s branches: %v", sourceRemote, err)
+	}
+	logrus.Infof("Mirroring %s branches and tags to %s", sourceRemote, targetRemote)
+	err = pushRefs(ctx, r, "mirror", []config.RefSpec{
+		config.RefSpec("+refs/remotes/" + sourceRemote + "/*:refs/heads/*"),
+		config.RefSpec("+refs/tags/" + sourceRemote + "/*:refs/tags/*"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mirror to %s: %v", targetRemote, err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/mod/modfile"
+)
+
+// findModFiles returns the slash separated paths of the go.mod files under
+// root matching the patterns of --modfile-glob, like staging/src/k8s.io/*/go.mod.
+// ** in a pattern matches any number of directories.
+func findModFiles(root string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(*modfileGlob, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	var files []string
+	walk := false
+	for _, p := range patterns {
+		if strings.ContainsAny(p, "*?[") {
+			walk = true
+		} else if path.Base(p) == "go.mod" && fileExists(filepath.Join(root, filepath.FromSlash(p))) {
+			files = append(files, p)
+		}
+	}
+	if walk {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && (d.Name() == ".git" || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			if d.IsDir() || d.Name() != "go.mod" {
+				return nil
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			for _, pattern := range patterns {
+				if matchGlob(pattern, rel) {
+					files = append(files, rel)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// matchGlob reports whether the slash separated name matches pattern, which
+// has path.Match syntax plus ** segments matching any number of directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// prepareModFiles runs prepareModFile in the module of each of modFiles, and
+// returns the requires pinned by rules, one line each.
+func prepareModFiles(ctx context.Context, fileSystem billy.Filesystem, modFiles []string, tag string, env []string) ([]string, error) {
+	if len(modFiles) == 0 {
+		return nil, fmt.Errorf("no go.mod matches %q", *modfileGlob)
+	}
+	var pinned []string
+	for _, modFile := range modFiles {
+		modFS, err := moduleFS(fileSystem, modFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open module of %s: %v", modFile, err)
+		}
+		if modFile == "go.mod" {
+			b, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.mod"))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read go.mod: %v", err)
+			}
+			if err = checkPublishedMajor(modfile.ModulePath(b), tag); err != nil {
+				return nil, err
+			}
+		}
+		pins, err := prepareModFile(ctx, modFS, tag, env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare %s: %w", modFile, err)
+		}
+		lines, err := heldPins(fileSystem.Root(), modFile, pins)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check the pins of %s: %v", modFile, err)
+		}
+		for _, l := range lines {
+			if !slices.Contains(pinned, l) {
+				pinned = append(pinned, l)
+			}
+		}
+	}
+	slices.Sort(pinned)
+	return pinned, nil
+}
+
+// moduleFS returns the filesystem of the module whose go.mod is at modFile.
+func moduleFS(fileSystem billy.Filesystem, modFile string) (billy.Filesystem, error) {
+	dir := path.Dir(modFile)
+	if dir == "." {
+		return fileSystem, nil
+	}
+	return fileSystem.Chroot(filepath.FromSlash(dir))
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+)
+
+// known keys of the checksum databases by name, from cmd/go
+var knownSumDBs = map[string]string{
+	"sum.golang.org": "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8",
+}
+
+// modProxy fetches go.mod files from the module proxy and checksums from the
+// checksum database. Everything it fetches is cached in memory, so that tags
+// sharing most of their dependencies only pay for the differences.
+type modProxy struct {
+	proxyURL string
+	sumdbURL string
+	sumdb    *sumdb.Client
+
+	mu   sync.Mutex
+	mods map[module.Version][]byte
+	sums map[module.Version][]string
+}
+
+var (
+	proxyOnce   sync.Once
+	sharedProxy *modProxy
+	proxyErr    error
+)
+
+// getModProxy returns the modProxy configured from the go environment.
+func getModProxy() (*modProxy, error) {
+	proxyOnce.Do(func() {
+		sharedProxy, proxyErr = newModProxy()
+	})
+	return sharedProxy, proxyErr
+}
+
+func newModProxy() (*modProxy, error) {
+	out, err := exec.Command("go", "env", "-json", "GOPROXY", "GOSUMDB", "GONOSUMDB").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read go env: %v", err)
+	}
+	var env struct{ GOPROXY, GOSUMDB, GONOSUMDB string }
+	if err = json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("failed to parse go env: %v", err)
+	}
+	p := &modProxy{
+		mods: map[module.Version][]byte{},
+		sums: map[module.Version][]string{},
+	}
+	for _, u := range strings.FieldsFunc(env.GOPROXY, func(r rune) bool { return r == ',' || r == '|' }) {
+		if strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://") {
+			p.proxyURL = strings.TrimSuffix(u, "/")
+			break
+		}
+	}
+	if p.proxyURL == "" {
+		return nil, fmt.Errorf("no usable module proxy in GOPROXY=%q", env.GOPROXY)
+	}
+
+	// GOSUMDB is "name", "name+key" or "name+key url", the name of a known
+	// database standing for its key
+	gosumdb := env.GOSUMDB
+	if gosumdb == "" {
+		gosumdb = "sum.golang.org"
+	}
+	// like cmd/go, sum.golang.google.cn is sum.golang.org reachable from
+	// mainland China
+	if gosumdb == "sum.golang.google.cn" {
+		gosumdb = "sum.golang.org https://sum.golang.google.cn"
+	}
+	fields := strings.Fields(gosumdb)
+	key, url := fields[0], ""
+	if len(fields) > 1 {
+		url = strings.TrimSuffix(fields[1], "/")
+	}
+	if key == "off" {
+		return nil, fmt.Errorf("GOSUMDB=off, checksums can't be verified")
+	}
+	if known, ok := knownSumDBs[key]; ok {
+		key = known
+	}
+	name, _, ok := strings.Cut(key, "+")
+	if !ok {
+		return nil, fmt.Errorf("GOSUMDB=%q has no verifier key", env.GOSUMDB)
+	}
+	ops := &sumdbOps{key: key, name: name, url: url, config: map[string][]byte{}, cache: map[string][]byte{}}
+	if ops.url == "" {
+		// prefer the sumdb proxied by GOPROXY, like the go command does
+		ops.url = "https://" + name
+		if _, err = httpGet(p.proxyURL + "/sumdb/" + name + "/supported"); err == nil {
+			ops.url = p.proxyURL + "/sumdb/" + name
+		}
+	}
+	p.sumdbURL = ops.url
+	p.sumdb = sumdb.NewClient(ops)
+	p.sumdb.SetGONOSUMDB(env.GONOSUMDB)
+	return p, nil
+}
+
+// GoMod returns the go.mod file of m.
+func (p *modProxy) GoMod(m module.Version) ([]byte, error) {
+	p.mu.Lock()
+	b, ok := p.mods[m]
+	p.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+	path, err := module.EscapePath(m.Path)
+	if err != nil {
+		return nil, err
+	}
+	vers, err := module.EscapeVersion(m.Version)
+	if err != nil {
+		return nil, err
+	}
+	b, err = httpGet(p.proxyURL + "/" + path + "/@v/" + vers + ".mod")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch go.mod of %s: %v", m, err)
+	}
+	p.mu.Lock()
+	p.mods[m] = b
+	p.mu.Unlock()
+	return b, nil
+}
+
+// Sums returns the go.sum lines of m. If m.Version ends in /go.mod, these are
+// the lines of its go.mod only.
+func (p *modProxy) Sums(m module.Version) ([]string, error) {
+	p.mu.Lock()
+	lines, ok := p.sums[m]
+	p.mu.Unlock()
+	if ok {
+		return lines, nil
+	}
+	lines, err := p.sumdb.Lookup(m.Path, m.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up checksum of %s: %v", m, err)
+	}
+	p.mu.Lock()
+	p.sums[m] = lines
+	p.mu.Unlock()
+	return lines, nil
+}
+
+func httpGet(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s: %s", url, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// sumdbOps implements sumdb.ClientOps with an in-memory config and cache.
+type sumdbOps struct {
+	key, name, url string
+
+	mu     sync.Mutex
+	config map[string][]byte
+	cache  map[string][]byte
+}
+
+func (o *sumdbOps) ReadRemote(path string) ([]byte, error) {
+	return httpGet(o.url + path)
+}
+
+func (o *sumdbOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(o.key), nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.config[file], nil
+}
+
+func (o *sumdbOps) WriteConfig(file string, old, new []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if string(o.config[file]) != string(old) {
+		return sumdb.ErrWriteConflict
+	}
+	o.config[file] = new
+	return nil
+}
+
+func (o *sumdbOps) ReadCache(file string) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if b, ok := o.cache[file]; ok {
+		return b, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (o *sumdbOps) WriteCache(file string, data []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.cache[file] = data
+}
+
+func (o *sumdbOps) Log(msg string) {
+	logrus.Debug(msg)
+}
+
+// SecurityError logs msg, the lookup invoking it fails with
+// sumdb.ErrSecurity, which fails the tag instead of the whole process.
+func (o *sumdbOps) SecurityError(msg string) {
+	logrus.Errorf("Checksum database %s misbehaved: %s", o.name, msg)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// moduleTagRule is an entry of --module-tags, a module directory published
+// with tags of its own, which the go command looks for to resolve a module in
+// a subdirectory of the repo.
+type moduleTagRule struct {
+	dir string
+	// the pin version of the entry, nil for the one of the profile
+	mapping func(tag string) (string, error)
+	pin     string
+}
+
+// moduleTagRules are the parsed --module-tags.
+var moduleTagRules []*moduleTagRule
+
+// parseModuleTags parses comma separated module directories relative to the
+// root of the tree, each optionally followed by =<pin version>, like
+// "staging/src/k8s.io/api,tools=template:v0.{{.Minor}}.{{.Patch}}".
+func parseModuleTags(s string) ([]*moduleTagRule, error) {
+	var rules []*moduleTagRule
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		dir, pin, _ := strings.Cut(entry, "=")
+		dir = strings.Trim(strings.TrimSpace(dir), "/")
+		if dir == "" || dir == "." || path.Clean(dir) != dir || strings.HasPrefix(dir, "../") {
+			return nil, fmt.Errorf("invalid module tags entry %q, want <module dir>[=<pin version>]", entry)
+		}
+		if slices.ContainsFunc(rules, func(r *moduleTagRule) bool { return r.dir == dir }) {
+			return nil, fmt.Errorf("module directory %s is listed twice in --module-tags", dir)
+		}
+		rule := &moduleTagRule{dir: dir, pin: strings.TrimSpace(pin)}
+		if rule.pin != "" {
+			var err error
+			if rule.mapping, err = parseVersionMapping(rule.pin); err != nil {
+				return nil, fmt.Errorf("invalid pin version of %s: %v", dir, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// version returns the version of the module tag of upstream tag name.
+func (r *moduleTagRule) version(name string) (string, error) {
+	if r.mapping == nil {
+		return pinVersion(name)
+	}
+	version, err := r.mapping(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to map %s with pin version %q: %v", name, r.pin, err)
+	}
+	if !semver.IsValid(version) {
+		return "", fmt.Errorf("pin version %q maps %s to invalid version %q", r.pin, name, version)
+	}
+	return version, nil
+}
+
+// moduleTags returns the published tags of the directories of --module-tags
+// for upstream tag name, like staging/src/k8s.io/api/v0.30.0-mod, for the
+// ones with a rewritten go.mod among modFiles in the tree at root. The
+// others aren't modules at that tag and get no tag.
+func moduleTags(root, name string, modFiles []string) ([]string, error) {
+	var tags []string
+	for _, r := range moduleTagRules {
+		modFile := r.dir + "/go.mod"
+		if !slices.Contains(modFiles, modFile) {
+			continue
+		}
+		version, err := r.version(name)
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(modFile)))
+		if err != nil {
+			return nil, err
+		}
+		// like checkPublishedMajor, a /vN module needs vN tags
+		modPath := modfile.ModulePath(b)
+		_, pathMajor, ok := module.SplitPathVersion(modPath)
+		if !ok {
+			return nil, fmt.Errorf("invalid module path %q in %s", modPath, modFile)
+		}
+		if err = module.CheckPathMajor(version+"-mod", pathMajor); err != nil {
+			return nil, fmt.Errorf("module %s in %s can't be published as %s-mod: %v", modPath, r.dir, version, err)
+		}
+		tags = append(tags, r.dir+"/"+version+"-mod")
+	}
+	return tags, nil
+}
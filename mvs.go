@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// number of go.mod files fetched from the proxy at the same time
+const proxyConcurrency = 16
+
+// modGraph is the module graph of a main module, loaded with the same graph
+// pruning rules as the go command uses for go 1.17+ main modules.
+type modGraph struct {
+	selected map[string]string
+	// modules whose go.mod was loaded, they all need a go.mod checksum
+	loaded map[module.Version]bool
+	// go versions declared by the loaded modules
+	goVersions map[module.Version]string
+}
+
+type graphItem struct {
+	m module.Version
+	// unpruned items have their requirements loaded transitively
+	unpruned bool
+}
+
+func isPruned(f *modfile.File) bool {
+	return f.Go != nil && semver.Compare("v"+f.Go.Version, "v1.17") >= 0
+}
+
+func loadModGraph(p *modProxy, roots []module.Version, pruned bool) (*modGraph, error) {
+	g := &modGraph{
+		selected:   map[string]string{},
+		loaded:     map[module.Version]bool{},
+		goVersions: map[module.Version]string{},
+	}
+	for _, m := range roots {
+		g.selectVersion(m)
+	}
+	seen := map[graphItem]bool{}
+	var frontier []graphItem
+	for _, m := range roots {
+		frontier = append(frontier, graphItem{m, !pruned})
+	}
+	for len(frontier) > 0 {
+		files, err := fetchModFiles(p, frontier)
+		if err != nil {
+			return nil, err
+		}
+		var next []graphItem
+		for i, it := range frontier {
+			g.loaded[it.m] = true
+			if files[i].Go != nil {
+				g.goVersions[it.m] = files[i].Go.Version
+			}
+			unpruned := it.unpruned || !isPruned(files[i])
+			for _, r := range files[i].Require {
+				g.selectVersion(r.Mod)
+				if child := (graphItem{r.Mod, true}); unpruned && !seen[child] {
+					seen[child] = true
+					next = append(next, child)
+				}
+			}
+		}
+		frontier = next
+	}
+	return g, nil
+}
+
+func (g *modGraph) selectVersion(m module.Version) {
+	if semver.Compare(m.Version, g.selected[m.Path]) > 0 {
+		g.selected[m.Path] = m.Version
+	}
+}
+
+// fetchModFiles fetches and parses the go.mod files of items in parallel.
+func fetchModFiles(p *modProxy, items []graphItem) ([]*modfile.File, error) {
+	files := make([]*modfile.File, len(items))
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, proxyConcurrency)
+	var wg sync.WaitGroup
+	for i, it := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			b, err := p.GoMod(it.m)
+			if err == nil {
+				files[i], err = modfile.ParseLax(it.m.String()+"/go.mod", b, nil)
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// mvsSum resolves the requirements of modFile with minimal version selection
+// over go.mod files from the module proxy, raises its requirements to the
+// selected versions, and returns a go.sum covering the loaded module graph
+// with checksums from the checksum database.
+//
+// Unlike go mod tidy it doesn't look at packages, so it never adds or drops
+// requirements, and it records zip checksums of all required modules.
+func mvsSum(modFile *modfile.File) ([]byte, error) {
+	p, err := getModProxy()
+	if err != nil {
+		return nil, err
+	}
+	if len(modFile.Replace) > 0 {
+		return nil, fmt.Errorf("replace of %s is not supported", modFile.Replace[0].Old.Path)
+	}
+	if len(modFile.Exclude) > 0 {
+		return nil, fmt.Errorf("exclude of %s is not supported", modFile.Exclude[0].Mod.Path)
+	}
+	var g *modGraph
+	for {
+		roots := make([]module.Version, 0, len(modFile.Require))
+		for _, r := range modFile.Require {
+			roots = append(roots, r.Mod)
+		}
+		if g, err = loadModGraph(p, roots, isPruned(modFile)); err != nil {
+			return nil, err
+		}
+		// raising a requirement may pull in higher versions of others,
+		// so repeat until the selection is stable
+		var raised []module.Version
+		for _, r := range modFile.Require {
+			if v := g.selected[r.Mod.Path]; v != r.Mod.Version {
+				raised = append(raised, module.Version{Path: r.Mod.Path, Version: v})
+			}
+		}
+		if len(raised) == 0 {
+			break
+		}
+		for _, m := range raised {
+			if err = modFile.AddRequire(m.Path, m.Version); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// the main module can't declare an older go version than its dependencies
+	// since go 1.21, tidy raises it the same way
+	goVersion := ""
+	if modFile.Go != nil {
+		goVersion = modFile.Go.Version
+	}
+	for path, v := range g.selected {
+		if gv := g.goVersions[module.Version{Path: path, Version: v}]; semver.Compare("v"+gv, "v"+goVersion) > 0 {
+			goVersion = gv
+		}
+	}
+	if goVersion != "" && (modFile.Go == nil || goVersion != modFile.Go.Version) {
+		if err = modFile.AddGoStmt(goVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	zips := map[module.Version]bool{}
+	for _, r := range modFile.Require {
+		zips[r.Mod] = true
+		g.loaded[r.Mod] = true
+	}
+	var mods []module.Version
+	for m := range g.loaded {
+		mods = append(mods, m)
+	}
+	module.Sort(mods)
+
+	// the go.sum of each module has its zip line first, then its go.mod line
+	var lookups []module.Version
+	for _, m := range mods {
+		if zips[m] {
+			lookups = append(lookups, m)
+		}
+		lookups = append(lookups, module.Version{Path: m.Path, Version: m.Version + "/go.mod"})
+	}
+	lines := make([][]string, len(lookups))
+	errs := make([]error, len(lookups))
+	sem := make(chan struct{}, proxyConcurrency)
+	var wg sync.WaitGroup
+	for i, m := range lookups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			lines[i], errs[i] = p.Sums(m)
+		}()
+	}
+	wg.Wait()
+
+	var sb strings.Builder
+	for i := range lookups {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, line := range lines[i] {
+			sb.WriteString(line + "\n")
+		}
+	}
+	return []byte(sb.String()), nil
+}
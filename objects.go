@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// writeBlob stores data as a blob in r.
+func writeBlob(r *gogit.Repository, data []byte) (plumbing.Hash, error) {
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if _, err = w.Write(data); err != nil {
+		_ = w.Close()
+		return plumbing.ZeroHash, err
+	}
+	if err = w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// writeTree stores a tree of the files by name in r, without subdirectories.
+func writeTree(r *gogit.Repository, files map[string][]byte) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	for name, data := range files {
+		h, err := writeBlob(r, data)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Regular, Hash: h})
+	}
+	// git orders entries by name, plain byte order without directories
+	slices.SortFunc(tree.Entries, func(a, b object.TreeEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	obj := r.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// writeCommit stores a commit of files with the given parents in r, authored
+// by kksyncer at when.
+func writeCommit(r *gogit.Repository, files map[string][]byte, message string, when time.Time, parents ...plumbing.Hash) (plumbing.Hash, error) {
+	tree, err := writeTree(r, files)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	sig := object.Signature{Name: "kksyncer", When: when}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      message,
+		TreeHash:     tree,
+		ParentHashes: parents,
+	}
+	obj := r.Storer.NewEncodedObject()
+	if err = commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// blobContents returns the contents of blob.
+func blobContents(blob *object.Blob) ([]byte, error) {
+	rd, err := blob.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+	return io.ReadAll(rd)
+}
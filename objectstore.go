@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// objectStore is a state backend in an S3 bucket, or in a GCS bucket through
+// its S3 compatible XML API with an HMAC key, signed with AWS Signature V4.
+type objectStore struct {
+	endpoint, region string
+	bucket, prefix   string
+	accessKey        string
+	secretKey        string
+	sessionToken     string
+	gcs              bool
+	client           *http.Client
+}
+
+// newObjectStore returns the store of prefix in bucket of scheme s3 or gs,
+// with the credentials of the environment.
+func newObjectStore(scheme, bucket, prefix string) (*objectStore, error) {
+	s := &objectStore{bucket: bucket, prefix: prefix, client: &http.Client{Timeout: 5 * time.Minute}}
+	if scheme == "gs" {
+		s.gcs, s.endpoint, s.region = true, "https://storage.googleapis.com", "auto"
+		s.accessKey, s.secretKey = os.Getenv("GCS_HMAC_ACCESS_ID"), os.Getenv("GCS_HMAC_SECRET")
+		if s.accessKey == "" || s.secretKey == "" {
+			return nil, errors.New("the gs state backend needs the HMAC key of a service account in GCS_HMAC_ACCESS_ID and GCS_HMAC_SECRET")
+		}
+		return s, nil
+	}
+	s.region = firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	// AWS_ENDPOINT_URL like the AWS SDKs, for S3 compatible stores like MinIO
+	s.endpoint = strings.TrimSuffix(firstNonEmpty(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL"), "https://s3."+s.region+".amazonaws.com"), "/")
+	s.accessKey, s.secretKey, s.sessionToken = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, errors.New("the s3 state backend needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *objectStore) get(name string) ([]byte, error) {
+	resp, b, err := s.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errStateNotFound
+	}
+	return b, s.check(resp, b)
+}
+
+func (s *objectStore) put(name string, data []byte, create bool) error {
+	header := http.Header{}
+	if create && s.gcs {
+		header.Set("x-goog-if-generation-match", "0")
+	} else if create {
+		header.Set("If-None-Match", "*")
+	}
+	resp, b, err := s.do(http.MethodPut, name, data, header)
+	if err != nil {
+		return err
+	}
+	// S3 answers 409 to a concurrent conditional write
+	if create && (resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict) {
+		return errStateExists
+	}
+	return s.check(resp, b)
+}
+
+func (s *objectStore) delete(name string) error {
+	resp, b, err := s.do(http.MethodDelete, name, nil, nil)
+	if err != nil || resp.StatusCode == http.StatusNotFound {
+		return err
+	}
+	return s.check(resp, b)
+}
+
+func (s *objectStore) check(resp *http.Response, b []byte) error {
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// do sends a signed request for the object name with path-style addressing
+// and returns the response with its body.
+func (s *objectStore) do(method, name string, body []byte, header http.Header) (*http.Response, []byte, error) {
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := path.Join(s.prefix, name)
+	u.Path = "/" + s.bucket + "/" + key
+	var escaped []string
+	for _, seg := range strings.Split(u.Path, "/") {
+		escaped = append(escaped, sigV4Escape(seg))
+	}
+	u.RawPath = strings.Join(escaped, "/")
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return resp, b, err
+}
+
+// sign adds the AWS Signature V4 of req with body at now, with the payload
+// hash header of S3.
+func (s *objectStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+	}
+	signV4(req, payloadHash, now, s.region, "s3", s.accessKey, s.secretKey)
+}
+
+// signV4 adds the x-amz-date of now and the Authorization of AWS Signature
+// V4 to req for service in region, signing all the headers of req.
+func signV4(req *http.Request, payloadHash string, now time.Time, region, service, accessKey, secretKey string) {
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// sigV4Escape escapes all but the unreserved characters of s, like the
+// canonical URI of Signature V4.
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/sirupsen/logrus"
+)
+
+// tagJob is an upstream tag going through the steps of the pipeline.
+type tagJob struct {
+	// canceled to stop the tag, killing its git and go commands
+	ctx     context.Context
+	wk      *worker
+	run     *syncRun
+	name    string
+	kh      plumbing.Hash
+	started time.Time
+	// the rewrite, set by the rewrite step
+	rw      *rewrite
+	tagName string
+	// the release assets of the checksums step
+	assets []releaseAsset
+	// the refs the push step pushes, the tag and the provenance
+	refSpecs []config.RefSpec
+}
+
+// pipelineStep is a named step of handling a tag, also the phase of the
+// worker while it runs.
+type pipelineStep struct {
+	name string
+	// steps the pipeline can't do without, which --skip-steps can't skip
+	required bool
+	// steps that must come before this one when they are in the pipeline
+	after []string
+	// whether the flags enable the step, nil for always
+	enabled func() bool
+	run     func(j *tagJob) error
+}
+
+// pipelineSteps are the steps of handling a tag, in their default order.
+// The rewrite step checks out the upstream tag and commits the rewrite, with
+// the submodules, LFS, hooks, pruning and tidy in between, the steps after it
+// check the rewritten tree and publish it, and the ones after push only
+// report.
+var pipelineSteps = []*pipelineStep{
+	{name: "verify", run: stepVerify},
+	{name: "rewrite", required: true, after: []string{"verify"}, run: stepRewrite},
+	{name: "zip-check", after: []string{"rewrite"}, run: stepZipCheck},
+	{name: "sbom", after: []string{"rewrite"}, enabled: func() bool { return *sbomDir != "" }, run: stepSBOM},
+	{name: "licenses", after: []string{"rewrite"}, enabled: func() bool { return *licenseReportDir != "" }, run: stepLicenses},
+	{name: "vulncheck", after: []string{"rewrite"}, enabled: func() bool { return *vulnCheck }, run: stepVulncheck},
+	{name: "policy", after: []string{"rewrite"}, enabled: func() bool { return len(policyRules) > 0 }, run: stepPolicy},
+	{name: "test", after: []string{"rewrite"}, enabled: func() bool { return *testPackagesFlag != "" }, run: stepTest},
+	{name: "go-versions", after: []string{"rewrite"}, enabled: func() bool { return *goVersions != "" && *goVersionsGate }, run: stepGoVersions},
+	{name: "checksums", after: []string{"rewrite"}, enabled: func() bool { return *releaseChecksums }, run: stepChecksums},
+	{name: "subdir", after: []string{"rewrite"}, enabled: func() bool { return *targetSubdir != "" }, run: stepSubdir},
+	{name: "tag", required: true, after: []string{"rewrite", "subdir"}, run: stepTag},
+	{name: "provenance", after: []string{"tag"}, enabled: func() bool { return *provenanceOn }, run: stepProvenance},
+	{name: "file-sizes", after: []string{"rewrite"}, enabled: func() bool { return targetForge() == forgeGitee }, run: stepFileSizes},
+	{name: "pre-push", after: []string{"tag"}, run: stepPrePush},
+	{name: "push", required: true, after: []string{"tag", "provenance"}, run: stepPush},
+	{name: "go-versions-report", after: []string{"rewrite"}, enabled: func() bool { return *goVersions != "" && !*goVersionsGate }, run: stepGoVersionsReport},
+	{name: "release", after: []string{"push", "checksums"}, enabled: func() bool { return *createReleases }, run: stepRelease},
+	{name: "post-publish", after: []string{"push"}, run: stepPostPublish},
+}
+
+// tagPipeline is the pipeline of --pipeline and --skip-steps.
+var tagPipeline []*pipelineStep
+
+// buildPipeline returns the steps of order, comma separated step names or
+// empty for the default order, without the ones of skip.
+func buildPipeline(order, skip string) ([]*pipelineStep, error) {
+	byName := map[string]*pipelineStep{}
+	for _, s := range pipelineSteps {
+		byName[s.name] = s
+	}
+	lookup := func(list string) ([]*pipelineStep, error) {
+		var steps []*pipelineStep
+		for _, n := range strings.Split(list, ",") {
+			if n = strings.TrimSpace(n); n == "" {
+				continue
+			}
+			s, ok := byName[n]
+			if !ok {
+				return nil, fmt.Errorf("unknown pipeline step %q, known steps are %s", n, stepNames(pipelineSteps))
+			}
+			if slices.Contains(steps, s) {
+				return nil, fmt.Errorf("pipeline step %s is listed twice", n)
+			}
+			steps = append(steps, s)
+		}
+		return steps, nil
+	}
+	steps := pipelineSteps
+	if strings.TrimSpace(order) != "" {
+		var err error
+		if steps, err = lookup(order); err != nil {
+			return nil, err
+		}
+	}
+	skipped, err := lookup(skip)
+	if err != nil {
+		return nil, err
+	}
+	var pipeline []*pipelineStep
+	for _, s := range steps {
+		if slices.Contains(skipped, s) {
+			if s.required {
+				return nil, fmt.Errorf("pipeline step %s can't be skipped", s.name)
+			}
+			continue
+		}
+		pipeline = append(pipeline, s)
+	}
+	for _, s := range pipelineSteps {
+		if s.required && !slices.Contains(pipeline, s) {
+			return nil, fmt.Errorf("the pipeline needs the %s step", s.name)
+		}
+	}
+	for i, s := range pipeline {
+		for _, n := range s.after {
+			if j := slices.IndexFunc(pipeline, func(p *pipelineStep) bool { return p.name == n }); j > i {
+				return nil, fmt.Errorf("pipeline step %s must come after %s", s.name, n)
+			}
+		}
+	}
+	return pipeline, nil
+}
+
+// stepNames returns the names of steps, comma separated.
+func stepNames(steps []*pipelineStep) string {
+	var names []string
+	for _, s := range steps {
+		names = append(names, s.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// runPipeline runs the enabled steps of the pipeline on j, stopping at the
+// first failing one, or before the next step once the context of j is
+// canceled.
+func runPipeline(j *tagJob) error {
+	for _, s := range tagPipeline {
+		if s.enabled != nil && !s.enabled() {
+			continue
+		}
+		if err := j.ctx.Err(); err != nil {
+			return err
+		}
+		j.wk.setPhase(s.name)
+		if err := s.run(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stepVerify(j *tagJob) error {
+	return verifyTag(j.wk.repo, j.name, j.kh)
+}
+
+func stepRewrite(j *tagJob) error {
+	var err error
+	j.rw, err = rewriteTag(j.ctx, j.wk, j.name, j.kh, j.run.retracted, j.run.linearHead)
+	return err
+}
+
+func stepZipCheck(j *tagJob) error {
+	return checkModuleZips(j.rw.root, j.rw.modFiles)
+}
+
+func stepSBOM(j *tagJob) error {
+	if err := writeSBOM(j.ctx, j.rw.root, j.rw.modFiles, j.name, j.wk.env); err != nil {
+		return fmt.Errorf("failed to write SBOM: %v", err)
+	}
+	return nil
+}
+
+func stepLicenses(j *tagJob) error {
+	if err := writeLicenseReport(j.ctx, j.rw.root, j.rw.modFiles, j.name, j.wk.env); err != nil {
+		return fmt.Errorf("failed to write license report: %v", err)
+	}
+	return nil
+}
+
+func stepVulncheck(j *tagJob) error {
+	findings, err := checkVulns(j.ctx, j.rw.root, j.rw.modFiles, j.wk.env)
+	if err != nil {
+		return err
+	}
+	logVulns(j.name, findings)
+	j.run.summary.addVulns(j.name, findings)
+	return vulnGate(j.name, findings)
+}
+
+func stepPolicy(j *tagJob) error {
+	violations, err := checkPolicy(j.ctx, j.rw.root, j.rw.modFiles, j.name, j.wk.env)
+	if err != nil {
+		return fmt.Errorf("failed to check the policy: %v", err)
+	}
+	if len(violations) > 0 {
+		j.run.summary.addPolicyViolations(j.name, violations)
+	}
+	return policyGate(j.name, violations)
+}
+
+func stepTest(j *tagJob) error {
+	err := runTests(j.ctx, j.rw.root, j.wk.env)
+	j.run.summary.addTests(j.name, err == nil)
+	return err
+}
+
+func stepGoVersions(j *tagJob) error {
+	results := buildMatrix(j.ctx, j.rw.root, j.rw.modFiles, j.wk.env)
+	j.run.summary.addGoVersions(j.name, results)
+	return logMatrix(j.name, results)
+}
+
+func stepChecksums(j *tagJob) error {
+	// before the push, a tree the module proxy can't zip fails the tag
+	var err error
+	j.assets, err = moduleChecksums(j.ctx, j.rw.root, j.rw.modFiles, j.name, j.tagName, j.rw.commit.String())
+	return err
+}
+
+func stepSubdir(j *tagJob) error {
+	rewrite, err := j.wk.repo.CommitObject(j.rw.commit)
+	if err != nil {
+		return err
+	}
+	h, branch, err := subdirCommit(j.ctx, j.wk.repo, rewrite)
+	if err != nil {
+		return err
+	}
+	// the published commit, tagged and pushed to the default branch
+	j.rw.commit = h
+	local := plumbing.ReferenceName("refs/kksyncer/subdir")
+	if err = j.wk.repo.Storer.SetReference(plumbing.NewHashReference(local, h)); err != nil {
+		return err
+	}
+	j.refSpecs = append(j.refSpecs, config.RefSpec(local+":"+branch))
+	logrus.Infof("Committing %s under %s of %s", j.tagName, *targetSubdir, branch.Short())
+	return nil
+}
+
+// rollbackPush deletes the local refs of a failed push, the tags and the
+// provenance, and checks out the upstream commit again, so the worker holds
+// nothing half published. The refs of a push to retry are kept in the
+// workdir by keepUnpushed before.
+func rollbackPush(j *tagJob) error {
+	for _, rs := range j.refSpecs {
+		if err := j.wk.repo.Storer.RemoveReference(plumbing.ReferenceName(rs.Src())); err != nil {
+			return err
+		}
+	}
+	if err := j.wk.git.checkout(j.rw.source.Hash); err != nil {
+		return fmt.Errorf("failed to check out %s: %v", j.name, err)
+	}
+	logrus.Infof("Rolled back %s after the failed push", j.tagName)
+	return nil
+}
+
+func stepTag(j *tagJob) error {
+	var tagOpts *gogit.CreateTagOptions
+	if *trailersOn {
+		tagOpts = &gogit.CreateTagOptions{
+			Tagger:  &object.Signature{Name: "kksyncer", When: j.rw.source.Author.When},
+			Message: "Go module release of upstream tag " + j.name + "\n\n" + provenanceTrailers(j.name, j.rw.source.Hash),
+		}
+	}
+	if err := j.wk.git.tag(j.tagName, j.rw.commit, tagOpts); err != nil {
+		return fmt.Errorf("failed to create tag %s: %v", j.name, err)
+	}
+	// a diverged tag published again replaces the published one
+	force := ""
+	if _, ok := j.run.republish[j.name]; ok {
+		force = "+"
+	}
+	j.refSpecs = append(j.refSpecs, config.RefSpec(force+"refs/tags/"+j.tagName+":"+*targetRefPrefix+j.tagName))
+
+	modTags, err := moduleTags(j.rw.root, j.name, j.rw.modFiles)
+	if err != nil {
+		return err
+	}
+	for _, t := range modTags {
+		if tagOpts != nil {
+			tagOpts = &gogit.CreateTagOptions{Tagger: tagOpts.Tagger, Message: "Go module release of " + path.Dir(t) + " of upstream tag " + j.name + "\n\n" + provenanceTrailers(j.name, j.rw.source.Hash)}
+		}
+		if err = j.wk.git.tag(t, j.rw.commit, tagOpts); err != nil {
+			return fmt.Errorf("failed to create tag %s: %v", t, err)
+		}
+		j.refSpecs = append(j.refSpecs, config.RefSpec(force+"refs/tags/"+t+":"+*targetRefPrefix+t))
+	}
+	if len(modTags) > 0 {
+		logrus.Infof("Tagging the nested modules of %s: %s", j.tagName, strings.Join(modTags, ", "))
+	}
+	return nil
+}
+
+func stepProvenance(j *tagJob) error {
+	ref, err := writeProvenance(j.wk.repo, j.name, j.kh, j.rw.source.Hash, j.rw.commit, j.rw.root, j.rw.files, j.started)
+	if err != nil {
+		return fmt.Errorf("failed to write provenance of %s: %v", j.tagName, err)
+	}
+	j.refSpecs = append(j.refSpecs, config.RefSpec(ref+":"+ref))
+	return nil
+}
+
+func stepFileSizes(j *tagJob) error {
+	return checkFileSizes(j.wk.repo, j.rw.commit)
+}
+
+func stepPrePush(j *tagJob) error {
+	hook := hookInfo{worker: j.wk.id, tag: j.name, source: j.rw.source.Hash.String(), commit: j.rw.commit.String()}
+	return runHook(j.ctx, hookPrePush, j.rw.root, hook)
+}
+
+func stepPush(j *tagJob) error {
+	var err error
+	if *publishVia == "github-api" {
+		err = publishViaAPI(j.ctx, j.wk.repo, j.rw, j.tagName)
+		recordPush("github-api", []string{*targetRefPrefix + j.tagName}, err)
+	} else if err = j.wk.git.push(j.ctx, j.tagName, j.refSpecs); err != nil {
+		err = fmt.Errorf("failed to push tag %s: %w", j.tagName, err)
+		if taken, terr := takenRefs(j.ctx, j.wk.repo, j.refSpecs); terr == nil && len(taken) > 0 {
+			err = fmt.Errorf("%w: %s on the target: %w", ErrTagAlreadyPublished, strings.Join(taken, ", "), err)
+		} else if kerr := keepUnpushed(j.run, j.wk, j.refSpecs); kerr != nil {
+			logrus.Warnf("Failed to keep %s to push it again on the next run: %v", j.tagName, kerr)
+		} else {
+			logrus.Infof("Keeping %s to push it again on the next run", j.tagName)
+		}
+	}
+	if err != nil {
+		if rerr := rollbackPush(j); rerr != nil {
+			logrus.Warnf("Failed to roll back %s: %v", j.tagName, rerr)
+		}
+		return err
+	}
+	if *linearHistory {
+		j.run.linearHead = j.rw.commit
+	}
+	j.run.summary.recordCommits(j.name, j.rw.source.Hash.String(), j.rw.commit.String())
+	return nil
+}
+
+func stepGoVersionsReport(j *tagJob) error {
+	// reported only, without delaying the publication
+	results := buildMatrix(j.ctx, j.rw.root, j.rw.modFiles, j.wk.env)
+	j.run.summary.addGoVersions(j.name, results)
+	_ = logMatrix(j.name, results)
+	return nil
+}
+
+func stepRelease(j *tagJob) error {
+	publishRelease(j.ctx, j.name, j.tagName, j.rw.commit.String(), j.assets)
+	return nil
+}
+
+func stepPostPublish(j *tagJob) error {
+	postPublish(publishPayload{
+		Tag:             j.name,
+		PublishedTag:    j.tagName,
+		TagHash:         j.kh.String(),
+		SourceCommit:    j.rw.source.Hash.String(),
+		Commit:          j.rw.commit.String(),
+		Target:          *targetRepo,
+		Ref:             *targetRefPrefix + j.tagName,
+		DurationSeconds: time.Since(j.started).Seconds(),
+		PublishedAt:     time.Now().UTC().Format(time.RFC3339),
+	})
+	return nil
+}
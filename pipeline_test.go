@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// serveTarget serves a repository in memory as the target of the sync, on
+// the mem scheme, the repos of newMemoryRepo pushing to it. Clearing the
+// loader makes the target unreachable.
+func serveTarget(t *testing.T) (*memory.Storage, server.MapLoader) {
+	const url = "mem://target"
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := memory.NewStorage()
+	loader := server.MapLoader{ep.String(): target}
+	client.InstallProtocol("mem", server.NewServer(loader))
+	source, dest := *sourceRepo, *targetRepo
+	*sourceRepo, *targetRepo = "mem://source", url
+	t.Cleanup(func() {
+		client.InstallProtocol("mem", nil)
+		*sourceRepo, *targetRepo = source, dest
+	})
+	return target, loader
+}
+
+// newTestJob returns the job of upstream tag v1.0.0 on a repo of
+// newMemoryRepo, with an upstream commit and its rewrite on top.
+func newTestJob(t *testing.T) *tagJob {
+	g, err := newMemoryRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := g.(goGitRepo).r
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "upstream", Email: "upstream@example.com", When: time.Unix(1700000000, 0)}
+	var commits []plumbing.Hash
+	for _, content := range []string{"module example.com/m\n", "module example.com/m\n\ngo 1.22\n"} {
+		if err = util.WriteFile(w.Filesystem, "go.mod", []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Add("go.mod"); err != nil {
+			t.Fatal(err)
+		}
+		h, err := g.commit("commit", &gogit.CommitOptions{Author: sig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		commits = append(commits, h)
+	}
+	source, err := r.CommitObject(commits[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	workdir, err := newMemoryRepo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tagJob{
+		ctx:     context.Background(),
+		wk:      &worker{repo: r, git: g},
+		run:     &syncRun{summary: newRunSummary(nil), workdir: workdir.(goGitRepo).r},
+		name:    "v1.0.0",
+		started: time.Now(),
+		rw:      &rewrite{source: source, commit: commits[1]},
+		tagName: "v1.0.0-mod",
+	}
+}
+
+// publishedCommit returns the commit of the published tag name of target.
+func publishedCommit(t *testing.T, target *memory.Storage, name string) plumbing.Hash {
+	ref, err := target.Reference(plumbing.NewTagReferenceName(name))
+	if err != nil {
+		t.Fatalf("tag %s isn't on the target: %v", name, err)
+	}
+	// the annotated tags of --trailers, or the commit
+	if tag, err := object.GetTag(target, ref.Hash()); err == nil {
+		return tag.Target
+	}
+	return ref.Hash()
+}
+
+// checkRolledBack checks that the local tag of j is deleted and the upstream
+// commit checked out again.
+func checkRolledBack(t *testing.T, j *tagJob) {
+	if _, err := j.wk.repo.Reference(plumbing.NewTagReferenceName(j.tagName), false); err == nil {
+		t.Errorf("tag %s is kept after the failed push", j.tagName)
+	}
+	head, err := j.wk.repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != j.rw.source.Hash {
+		t.Errorf("checked out %s after the failed push, want the upstream commit %s", head.Hash(), j.rw.source.Hash)
+	}
+}
+
+func TestStepTagPush(t *testing.T) {
+	target, _ := serveTarget(t)
+	j := newTestJob(t)
+	if err := stepTag(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := stepPush(j); err != nil {
+		t.Fatal(err)
+	}
+	if got := publishedCommit(t, target, j.tagName); got != j.rw.commit {
+		t.Errorf("published %s as %s, want the rewrite %s", j.tagName, got, j.rw.commit)
+	}
+}
+
+func TestStepPushAlreadyPublished(t *testing.T) {
+	target, _ := serveTarget(t)
+	j := newTestJob(t)
+	// published by another run with the upstream commit
+	if err := j.wk.git.tag("other", j.rw.source.Hash, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.wk.git.push(j.ctx, "other", []config.RefSpec{"refs/tags/other:refs/tags/" + config.RefSpec(j.tagName)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stepTag(j); err != nil {
+		t.Fatal(err)
+	}
+	if err := stepPush(j); !errors.Is(err, ErrTagAlreadyPublished) {
+		t.Fatalf("push of a published tag failed with %v, want ErrTagAlreadyPublished", err)
+	}
+	checkRolledBack(t, j)
+	if got := publishedCommit(t, target, j.tagName); got != j.rw.source.Hash {
+		t.Errorf("published %s changed to %s", j.tagName, got)
+	}
+	if refs, _ := retryUnpushedRefs(t, j); len(refs) > 0 {
+		t.Errorf("kept %v of a tag published by another run", refs)
+	}
+}
+
+func TestStepPushUnreachable(t *testing.T) {
+	target, loader := serveTarget(t)
+	repos := maps.Clone(loader)
+	clear(loader)
+	j := newTestJob(t)
+	if err := stepTag(j); err != nil {
+		t.Fatal(err)
+	}
+	err := stepPush(j)
+	if err == nil || errors.Is(err, ErrTagAlreadyPublished) {
+		t.Fatalf("push to an unreachable target failed with %v", err)
+	}
+	checkRolledBack(t, j)
+
+	maps.Copy(loader, repos)
+	refs, pushed := retryUnpushedRefs(t, j)
+	if len(refs) != 1 {
+		t.Fatalf("kept %v of the failed push, want the tag", refs)
+	}
+	if _, ok := pushed[j.tagName]; !ok {
+		t.Errorf("pushed %v of the failed push, want %s", pushed, j.tagName)
+	}
+	if got := publishedCommit(t, target, j.tagName); got != j.rw.commit {
+		t.Errorf("published %s as %s, want the rewrite %s", j.tagName, got, j.rw.commit)
+	}
+	if left, _ := retryUnpushedRefs(t, j); len(left) > 0 {
+		t.Errorf("kept %v after pushing them again", left)
+	}
+}
+
+// retryUnpushedRefs returns the unpushed refs of the workdir of j, then
+// pushes them again with retryUnpushed.
+func retryUnpushedRefs(t *testing.T, j *tagJob) ([]string, map[string]plumbing.Hash) {
+	refs, err := j.run.workdir.References()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), unpushedRefPrefix) {
+			names = append(names, ref.Name().String())
+		}
+		return nil
+	})
+	pushed, err := retryUnpushed(j.ctx, j.run.workdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names, pushed
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/mod/semver"
+)
+
+type tagStatus string
+
+const (
+	statusPending   tagStatus = "pending"
+	statusPublished tagStatus = "published"
+	statusSkipped   tagStatus = "skipped"
+)
+
+// tagInfo is the state of an upstream tag on the target.
+type tagInfo struct {
+	Name          string    `json:"name"`
+	Hash          string    `json:"hash"`
+	Published     string    `json:"published,omitempty"`
+	PublishedHash string    `json:"publishedHash,omitempty"`
+	Status        tagStatus `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+// planTags decides what to do with each upstream tag, given the upstream and
+// target tags by name and a way to tell annotated upstream tags apart.
+func planTags(source, target map[string]plumbing.Hash, filter *tagFilter, isAnnotated func(plumbing.Hash) bool) []*tagInfo {
+	var tags []*tagInfo
+	for name, h := range source {
+		t := &tagInfo{Name: name, Hash: h.String(), Status: statusPending}
+		if th, ok := target[name+"-mod"]; ok {
+			t.Status, t.Published, t.PublishedHash = statusPublished, name+"-mod", th.String()
+		} else if reason := filter.skipReason(name, isAnnotated(h)); reason != "" {
+			t.Status, t.Reason = statusSkipped, reason
+		}
+		tags = append(tags, t)
+	}
+	slices.SortFunc(tags, func(a, b *tagInfo) int { return compareTags(a.Name, b.Name) })
+	return tags
+}
+
+// orderTags returns the tags in the order of --order, oldest first by default
+// for the linear history and for a run stopped midway to leave the newest
+// tags to the next one.
+func orderTags(tags iter.Seq[string]) []string {
+	names := slices.SortedFunc(tags, compareTags)
+	if *tagOrder == "newest-first" {
+		slices.Reverse(names)
+	}
+	return names
+}
+
+// compareTags orders tags by version, and by name for tags of the same
+// version or no version, so the tags are always handled in the same order.
+func compareTags(a, b string) int {
+	if c := semver.Compare(a, b); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// pendingTags returns the upstream tags to handle by name.
+func pendingTags(tags []*tagInfo) map[string]plumbing.Hash {
+	pending := map[string]plumbing.Hash{}
+	for _, t := range tags {
+		if t.Status == statusPending {
+			pending[t.Name] = plumbing.NewHash(t.Hash)
+		}
+	}
+	return pending
+}
+
+// listRemoteTags lists the tags of url without fetching them, along with the
+// set of annotated ones.
+func listRemoteTags(ctx context.Context, name, url string) (map[string]plumbing.Hash, map[plumbing.Hash]bool, error) {
+	auth, err := remoteAuth(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	rm := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: name, URLs: []string{url}})
+	refs, err := rm.ListContext(ctx, &gogit.ListOptions{Auth: auth, PeelingOption: gogit.AppendPeeled})
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := map[string]plumbing.Hash{}
+	peeled := map[string]bool{}
+	for _, ref := range refs {
+		n, ok := strings.CutPrefix(ref.Name().String(), remoteRefPrefix(name))
+		if !ok || ref.Type() != plumbing.HashReference {
+			continue
+		}
+		if base, ok := strings.CutSuffix(n, "^{}"); ok {
+			peeled[base] = true
+			continue
+		}
+		tags[n] = ref.Hash()
+	}
+	annotated := map[plumbing.Hash]bool{}
+	for n, h := range tags {
+		if peeled[n] {
+			annotated[h] = true
+		}
+	}
+	return tags, annotated, nil
+}
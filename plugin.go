@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"golang.org/x/mod/modfile"
+)
+
+// Rewrite plugins are executables run for each rewritten go.mod, after the
+// rewrite of kksyncer and before it is tidied. They get a pluginRequest as
+// JSON on stdin and print a pluginResponse, so logic beyond the flags, like
+// conditional replace handling or custom file edits, doesn't need a fork.
+// Plugins run out of process, in any language, rather than as Go plugins
+// tied to the toolchain kksyncer was built with.
+type (
+	pluginRequest struct {
+		Tag string `json:"tag"`
+		// version the replaced modules are pinned to
+		Version string `json:"version"`
+		// absolute directory of the module
+		Dir      string          `json:"dir"`
+		Module   string          `json:"module"`
+		Go       string          `json:"go"`
+		Requires []pluginRequire `json:"requires"`
+		Replaces []pluginReplace `json:"replaces"`
+	}
+	pluginRequire struct {
+		Path     string `json:"path"`
+		Version  string `json:"version"`
+		Indirect bool   `json:"indirect,omitempty"`
+	}
+	pluginReplace struct {
+		Old        string `json:"old"`
+		OldVersion string `json:"oldVersion,omitempty"`
+		New        string `json:"new"`
+		NewVersion string `json:"newVersion,omitempty"`
+	}
+	pluginResponse struct {
+		Go           string          `json:"go,omitempty"`
+		SetRequires  []pluginRequire `json:"setRequires,omitempty"`
+		DropRequires []string        `json:"dropRequires,omitempty"`
+		AddReplaces  []pluginReplace `json:"addReplaces,omitempty"`
+		DropReplaces []string        `json:"dropReplaces,omitempty"`
+		// files to write relative to the module directory, null deletes them
+		Files map[string]*string `json:"files,omitempty"`
+	}
+)
+
+// pluginList returns the executables of --rewrite-plugins.
+func pluginList() []string {
+	var plugins []string
+	for _, p := range strings.Split(*rewritePlugins, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
+}
+
+// applyPlugins runs the rewrite plugins in order on the go.mod of the module
+// in fileSystem, applying their changes to modFile and the module files.
+func applyPlugins(ctx context.Context, fileSystem billy.Filesystem, modFile *modfile.File, tag, version string) error {
+	for _, plugin := range pluginList() {
+		req := pluginRequest{Tag: tag, Version: version, Dir: fileSystem.Root(), Module: modFile.Module.Mod.Path}
+		if modFile.Go != nil {
+			req.Go = modFile.Go.Version
+		}
+		for _, r := range modFile.Require {
+			req.Requires = append(req.Requires, pluginRequire{r.Mod.Path, r.Mod.Version, r.Indirect})
+		}
+		for _, r := range modFile.Replace {
+			req.Replaces = append(req.Replaces, pluginReplace{r.Old.Path, r.Old.Version, r.New.Path, r.New.Version})
+		}
+		in, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		var stderr bytes.Buffer
+		cmd := commandContext(ctx, plugin)
+		cmd.Dir = fileSystem.Root()
+		cmd.Stdin = bytes.NewReader(in)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("rewrite plugin %s failed: %v\n%s", plugin, err, stderr.Bytes())
+		}
+		var resp pluginResponse
+		if err = json.Unmarshal(out, &resp); err != nil {
+			return fmt.Errorf("invalid response of rewrite plugin %s: %v", plugin, err)
+		}
+		if err = resp.apply(fileSystem, modFile); err != nil {
+			return fmt.Errorf("failed to apply rewrite plugin %s: %v", plugin, err)
+		}
+	}
+	return nil
+}
+
+func (resp *pluginResponse) apply(fileSystem billy.Filesystem, modFile *modfile.File) error {
+	if resp.Go != "" {
+		if err := modFile.AddGoStmt(resp.Go); err != nil {
+			return err
+		}
+	}
+	for _, r := range resp.SetRequires {
+		if err := modFile.AddRequire(r.Path, r.Version); err != nil {
+			return err
+		}
+	}
+	for _, p := range resp.DropRequires {
+		if err := modFile.DropRequire(p); err != nil {
+			return err
+		}
+	}
+	for _, p := range resp.DropReplaces {
+		for _, r := range modFile.Replace {
+			if r.Old.Path == p {
+				if err := modFile.DropReplace(r.Old.Path, r.Old.Version); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, r := range resp.AddReplaces {
+		if err := modFile.AddReplace(r.Old, r.OldVersion, r.New, r.NewVersion); err != nil {
+			return err
+		}
+	}
+	for name, content := range resp.Files {
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("file %s is outside the module", name)
+		}
+		if content == nil {
+			if err := fileSystem.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+		if err := fileSystem.MkdirAll(filepath.Dir(filepath.FromSlash(name)), 0755); err != nil {
+			return err
+		}
+		if err := writeFile(fileSystem, name, []byte(*content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
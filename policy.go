@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// policyRule is a rule of --policy, checked against the module graph of each
+// rewritten module before publishing.
+type policyRule struct {
+	op string
+	// module pattern of deny-module and deny-version, license patterns of
+	// deny-license and allow-license
+	patterns []string
+	versions versionRange
+	// the rule text, for violations and errors
+	text string
+}
+
+// policyRules are the parsed --policy.
+var policyRules []*policyRule
+
+// parsePolicy parses rules separated by semicolons or newlines, like
+// "deny-module github.com/evil/*; deny-license AGPL-*":
+//
+//	deny-module <module pattern>                  denies matching modules
+//	deny-version <module pattern> <version range> denies matching modules in the range
+//	deny-license <license pattern>                denies modules with a matching license
+//	allow-license <license pattern>...            denies modules with no matching license
+//
+// A # starts a comment. Patterns have path.Match syntax, licenses are the SPDX ids of the license
+// report or unknown, and version ranges the syntax of --version-range, like
+// "<0.23.0 || >=0.25.0 <0.25.2".
+func parsePolicy(s string) ([]*policyRule, error) {
+	var rules []*policyRule
+	for _, text := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '\n' }) {
+		text, _, _ = strings.Cut(text, "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		rule := &policyRule{op: fields[0], patterns: fields[1:], text: text}
+		switch rule.op {
+		case "deny-module", "deny-license":
+			if len(rule.patterns) != 1 {
+				return nil, fmt.Errorf("invalid policy rule %q: %s takes 1 argument", text, rule.op)
+			}
+		case "deny-version":
+			if len(rule.patterns) < 2 {
+				return nil, fmt.Errorf("invalid policy rule %q: deny-version takes a module pattern and a version range", text)
+			}
+			var err error
+			if rule.versions, err = parseVersionRange(strings.Join(rule.patterns[1:], " ")); err != nil {
+				return nil, fmt.Errorf("invalid policy rule %q: %v", text, err)
+			}
+			rule.patterns = rule.patterns[:1]
+		case "allow-license":
+			if len(rule.patterns) == 0 {
+				return nil, fmt.Errorf("invalid policy rule %q: allow-license takes at least 1 argument", text)
+			}
+		default:
+			return nil, fmt.Errorf("invalid policy rule %q: unknown rule %s, known rules are deny-module, deny-version, deny-license and allow-license", text, rule.op)
+		}
+		for _, p := range rule.patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("invalid policy rule %q: %v", text, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// policyNeedsLicenses returns whether the policy has license rules, which
+// need the modules downloaded.
+func policyNeedsLicenses() bool {
+	return slices.ContainsFunc(policyRules, func(r *policyRule) bool {
+		return r.op == "deny-license" || r.op == "allow-license"
+	})
+}
+
+// matchAny returns whether s matches any of patterns.
+func matchAny(patterns []string, s string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool {
+		ok, _ := path.Match(p, s)
+		return ok
+	})
+}
+
+// checkPolicy returns the violations of the policy by the module graphs of
+// the rewritten modules of modFiles in the tree at root, the rewritten
+// modules themselves aren't checked.
+func checkPolicy(ctx context.Context, root string, modFiles []string, tag string, env []string) ([]string, error) {
+	var violations []string
+	seen := map[string]bool{}
+	for _, modFile := range modFiles {
+		mods, _, err := publishedGraph(ctx, root, modFile, tag, env)
+		if err != nil {
+			return nil, err
+		}
+		licenses := map[string]string{}
+		if policyNeedsLicenses() {
+			report, err := graphLicenses(ctx, root, modFile, mods, env)
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range report {
+				licenses[l.Module] = l.License
+			}
+		}
+		for _, m := range mods[1:] {
+			for _, r := range policyRules {
+				var denied bool
+				switch r.op {
+				case "deny-module":
+					denied = matchAny(r.patterns, m.Path)
+				case "deny-version":
+					denied = matchAny(r.patterns, m.Path) && r.versions.match(m.Version)
+				case "deny-license":
+					denied = matchAny(r.patterns, licenses[m.Path])
+				case "allow-license":
+					denied = !matchAny(r.patterns, licenses[m.Path])
+				}
+				if !denied {
+					continue
+				}
+				v := fmt.Sprintf("%s@%s", m.Path, m.Version)
+				if licenses[m.Path] != "" {
+					v += " (" + licenses[m.Path] + ")"
+				}
+				v += " violates " + r.text
+				if !seen[v] {
+					seen[v] = true
+					violations = append(violations, v)
+				}
+			}
+		}
+	}
+	return violations, nil
+}
+
+// policyGate logs the violations of tag and fails it if there are any.
+func policyGate(tag string, violations []string) error {
+	for _, v := range violations {
+		logrus.WithField("tag", tag).Warnf("Policy violation: %s", v)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("tag %s has %d policy violations: %s", tag, len(violations), strings.Join(violations, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// runRewritePreview prints the diff of the go.mod files prepareModFile
+// produces for an upstream tag. The tag is written to a temporary directory, so nothing in
+// the workdir changes, and nothing is committed or pushed.
+func runRewritePreview(ctx context.Context) error {
+	name := flag.Arg(0)
+	if name == "" {
+		return errors.New("usage: rewrite-preview [flags] <tag>")
+	}
+	r, err := openWorkdir(ctx, sourceRemote)
+	if err != nil {
+		return err
+	}
+	ref, err := r.Reference(plumbing.NewTagReferenceName(sourceRemote+"/"+name), true)
+	if err != nil {
+		return fmt.Errorf("failed to find tag %s: %v", name, err)
+	}
+	commit, err := tagCommit(r, ref.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to get commit of %s: %v", name, err)
+	}
+
+	dir, err := os.MkdirTemp("", "kksyncer-preview-")
+	if err != nil {
+		return err
+	}
+	defer removeAll(dir)
+	if err = materializeTree(commit, dir); err != nil {
+		return fmt.Errorf("failed to write %s to %s: %v", name, dir, err)
+	}
+	pruned, err := findPrunePaths(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find paths to prune: %v", err)
+	}
+	for _, p := range pruned {
+		if err = os.RemoveAll(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+			return err
+		}
+	}
+	modFiles, err := findModFiles(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find go.mod files: %v", err)
+	}
+	files := slices.Concat(modFiles, goWorkFiles)
+	before := map[string][]byte{}
+	for _, f := range files {
+		// the workspace files may not exist
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		before[f] = b
+	}
+	fileSystem := osfs.New(dir)
+	if _, err = prepareModFiles(ctx, fileSystem, modFiles, name, tagEnv(name)); err != nil {
+		return err
+	}
+	if _, err = prepareGoWork(fileSystem); err != nil {
+		return fmt.Errorf("failed to prepare go.work: %v", err)
+	}
+	for _, f := range files {
+		after, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Print(unifiedDiff("a/"+f, "b/"+f, string(before[f]), string(after)))
+	}
+	return nil
+}
+
+// tagCommit returns the commit a tag points to, h being either the tag object
+// or the commit itself.
+func tagCommit(r *gogit.Repository, h plumbing.Hash) (*object.Commit, error) {
+	if tag, err := r.TagObject(h); err == nil {
+		return tag.Commit()
+	}
+	return r.CommitObject(h)
+}
+
+// materializeTree writes the files of commit to dir.
+func materializeTree(commit *object.Commit, dir string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	return tree.Files().ForEach(func(f *object.File) error {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, path)
+		}
+		perm := os.FileMode(0644)
+		if f.Mode == filemode.Executable {
+			perm = 0755
+		}
+		src, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(dst, src); err != nil {
+			dst.Close()
+			return err
+		}
+		return dst.Close()
+	})
+}
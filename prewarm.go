@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+// prewarmModCache downloads the module graphs of the newest of tags with
+// go mod download all into the GOMODCACHE of workers, once per distinct
+// cache, so the tidy runs of the tags mostly find their modules there. The
+// older tags share most of their dependencies with the newest one. It is an
+// optimization, so failures are only logged.
+func prewarmModCache(ctx context.Context, r *gogit.Repository, workers []*worker, tags map[string]plumbing.Hash) {
+	if len(tags) == 0 {
+		return
+	}
+	defer timePhase("prewarm")()
+	// the newest whatever the --order of the tags
+	name := slices.MaxFunc(slices.Collect(maps.Keys(tags)), compareTags)
+	commit, err := tagCommit(r, tags[name])
+	if err != nil {
+		logrus.Warnf("Not prewarming the module cache, failed to get commit of %s: %v", name, err)
+		return
+	}
+	dir, err := os.MkdirTemp("", "kksyncer-prewarm-")
+	if err != nil {
+		logrus.Warnf("Not prewarming the module cache: %v", err)
+		return
+	}
+	defer removeAll(dir)
+	if err = materializeTree(commit, dir); err != nil {
+		logrus.Warnf("Not prewarming the module cache, failed to write %s: %v", name, err)
+		return
+	}
+	modFiles, err := findModFiles(dir)
+	if err != nil {
+		logrus.Warnf("Not prewarming the module cache, failed to find the go.mod files of %s: %v", name, err)
+		return
+	}
+
+	// workers share the cache of the environment unless --isolate-gomodcache
+	envs := [][]string{workers[0].env}
+	if *isolateMod {
+		envs = nil
+		for _, wk := range workers {
+			envs = append(envs, wk.env)
+		}
+	}
+	logrus.Infof("Prewarming the module cache with the dependencies of %s", name)
+	var wg sync.WaitGroup
+	for _, env := range envs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, modFile := range modFiles {
+				if err := downloadModules(ctx, filepath.Join(dir, filepath.FromSlash(path.Dir(modFile))), env); err != nil {
+					logrus.Warnf("Failed to prewarm the module cache with %s of %s: %v", modFile, name, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// downloadModules runs go mod download all in the module directory dir.
+func downloadModules(ctx context.Context, dir string, env []string) error {
+	cmd := commandContext(ctx, "go", "mod", "download", "all")
+	cmd.Dir = dir
+	cmd.Env = goEnv(env)
+	release := acquireGo()
+	out, err := cmd.CombinedOutput()
+	release()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// profile holds what is specific to an upstream project. It is a preset of
+// profiles, the fields set in --profile-file and the flags override it.
+type profile struct {
+	// default of --source-repo, empty if it must be given
+	SourceRepo string `json:"sourceRepo,omitempty"`
+	// first upstream version handled, empty for all
+	MinVersion string `json:"minVersion,omitempty"`
+	// how the requires of replaced modules are pinned, see pinVersion
+	Pin string `json:"pinVersion,omitempty"`
+	// which replaces are dropped: all, or local for the ones with a
+	// directory, keeping replaces with forks
+	Replaces string `json:"replaces,omitempty"`
+}
+
+var profiles = map[string]profile{
+	"kubernetes": {
+		SourceRepo: "https://github.com/kubernetes/kubernetes.git",
+		// first version whose go.mod can be rewritten, after
+		// https://github.com/kubernetes/kubernetes/commit/0737e92da613568379d29db8ec18f2ecc240898d
+		MinVersion: "v1.26.0",
+		// the staging modules of kubernetes v1.X.Y are tagged v0.X.Y
+		Pin:      "v0",
+		Replaces: "all",
+	},
+	// the kubernetes fork of OpenShift, which also replaces dependencies
+	// with OpenShift forks that must be kept
+	"openshift": {
+		SourceRepo: "https://github.com/openshift/kubernetes.git",
+		MinVersion: "v1.26.0",
+		Pin:        "v0",
+		Replaces:   "local",
+	},
+	// monorepos like istio, whose modules are tagged along with the repo and
+	// which replace some dependencies with forks
+	"monorepo": {
+		Pin:      "tag",
+		Replaces: "local",
+	},
+	// any monorepo whose replaces all point to its own modules
+	"generic": {
+		Pin:      "tag",
+		Replaces: "all",
+	},
+}
+
+// the profile selected by --profile, with --profile-file and the flags
+// applied
+var activeProfile profile
+
+func setupProfile() error {
+	p, ok := profiles[*profileName]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, known profiles are %s", *profileName, strings.Join(slices.Sorted(maps.Keys(profiles)), ", "))
+	}
+	if *profileFile != "" {
+		b, err := os.ReadFile(*profileFile)
+		if err != nil {
+			return fmt.Errorf("failed to read profile file: %v", err)
+		}
+		// only the fields in the file are overridden
+		if err = json.Unmarshal(b, &p); err != nil {
+			return fmt.Errorf("failed to parse profile file %s: %v", *profileFile, err)
+		}
+	}
+	if *minVersionFlag != "" {
+		p.MinVersion = *minVersionFlag
+	}
+	if *pinVersionFlag != "" {
+		p.Pin = *pinVersionFlag
+	}
+	if *sourceRepo != "" {
+		p.SourceRepo = *sourceRepo
+	}
+	if p.MinVersion != "" {
+		p.MinVersion = "v" + strings.TrimPrefix(p.MinVersion, "v")
+		if !semver.IsValid(p.MinVersion) {
+			return fmt.Errorf("invalid min version %q", p.MinVersion)
+		}
+	}
+	mapping, err := parseVersionMapping(p.Pin)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains([]string{"all", "local"}, p.Replaces) {
+		return fmt.Errorf("invalid replaces %q", p.Replaces)
+	}
+	if p.SourceRepo == "" {
+		return fmt.Errorf("--source-repo is required with --profile=%s", *profileName)
+	}
+	*sourceRepo = p.SourceRepo
+	activeProfile = p
+	versionMapping = mapping
+	return nil
+}
+
+// versionMapping maps upstream tags to the versions the requires of the
+// replaced modules are pinned to, parsed from the pin version of the profile.
+var versionMapping func(tag string) (string, error)
+
+// parseVersionMapping parses a pin version:
+//
+//	v0                   v1.X.Y to v0.X.Y, like the kubernetes staging modules
+//	tag                  the upstream tag itself
+//	offset:<maj>.<min>   adds the offsets to the major and minor versions,
+//	                     offset:0.-25 maps v1.30.2 to v1.5.2
+//	template:<template>  a text/template of .Major, .Minor, .Patch,
+//	                     .Prerelease and .Tag, like v0.{{.Minor}}.{{.Patch}}
+func parseVersionMapping(pin string) (func(string) (string, error), error) {
+	kind, arg, _ := strings.Cut(pin, ":")
+	switch kind {
+	case "v0":
+		return func(tag string) (string, error) { return "v0" + strings.TrimPrefix(tag, "v1"), nil }, nil
+	case "tag":
+		return func(tag string) (string, error) { return tag, nil }, nil
+	case "offset":
+		maj, min, ok := strings.Cut(arg, ".")
+		dMaj, err1 := strconv.Atoi(maj)
+		dMin, err2 := strconv.Atoi(min)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid pin version %q, want offset:<major>.<minor>", pin)
+		}
+		return func(tag string) (string, error) {
+			v := parseTagVersion(tag)
+			return fmt.Sprintf("v%d.%d.%d%s", v.Major+dMaj, v.Minor+dMin, v.Patch, v.Prerelease), nil
+		}, nil
+	case "template":
+		t, err := template.New("pin").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pin version template: %v", err)
+		}
+		return func(tag string) (string, error) {
+			var b strings.Builder
+			if err := t.Execute(&b, parseTagVersion(tag)); err != nil {
+				return "", err
+			}
+			return b.String(), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid pin version %q, want v0, tag, offset:<major>.<minor> or template:<template>", pin)
+}
+
+// tagVersion is an upstream tag split for version mappings.
+type tagVersion struct {
+	Major, Minor, Patch int
+	// with the leading -, like -rc.1, or empty
+	Prerelease string
+	Tag        string
+}
+
+func parseTagVersion(tag string) tagVersion {
+	v := tagVersion{Prerelease: semver.Prerelease(tag), Tag: tag}
+	core := strings.TrimPrefix(strings.TrimSuffix(semver.Canonical(tag), v.Prerelease), "v")
+	parts := strings.SplitN(core, ".", 3)
+	for i, n := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if i < len(parts) {
+			*n, _ = strconv.Atoi(parts[i])
+		}
+	}
+	return v
+}
+
+// pinVersion returns the version the requires of the replaced modules are
+// pinned to when rewriting tag.
+func pinVersion(tag string) (string, error) {
+	version, err := versionMapping(tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to map %s with pin version %q: %v", tag, activeProfile.Pin, err)
+	}
+	if !semver.IsValid(version) {
+		return "", fmt.Errorf("pin version %q maps %s to invalid version %q", activeProfile.Pin, tag, version)
+	}
+	return version, nil
+}
+
+// dropReplace returns whether the profile drops replace r.
+func dropReplace(r *modfile.Replace) bool {
+	return activeProfile.Replaces == "all" || modfile.IsDirectoryPath(r.New.Path)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// minimum time between two progress records of the same phase
+const progressInterval = 5 * time.Second
+
+// git progress lines, like "remote: Counting objects:  45% (1234/2742)" or
+// "Receiving objects:  12% (345/2742), 1.20 MiB | 2.40 MiB/s"
+var progressLine = regexp.MustCompile(`^(?:remote: )?([A-Za-z ]+):\s+(\d+)% \((\d+)/(\d+)\)(.*)$`)
+
+// progressLogger turns the progress output of git into log records, with an
+// ETA, at most every progressInterval per phase and when a phase ends.
+type progressLogger struct {
+	op    string
+	buf   []byte
+	phase string
+	start time.Time
+	last  time.Time
+	done  bool
+}
+
+// newProgress returns a writer logging the git progress of op, or nil with
+// --progress=false.
+func newProgress(op string) io.Writer {
+	if !*showProgress {
+		return nil
+	}
+	return &progressLogger{op: op}
+}
+
+func (p *progressLogger) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	// git redraws progress lines with \r
+	for {
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			break
+		}
+		p.line(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *progressLogger) line(l string) {
+	m := progressLine.FindStringSubmatch(l)
+	if m == nil {
+		if l != "" {
+			logrus.WithField("op", p.op).Debug(l)
+		}
+		return
+	}
+	now := time.Now()
+	if m[1] != p.phase {
+		p.phase, p.start, p.last, p.done = m[1], now, time.Time{}, false
+	}
+	pct, _ := strconv.Atoi(m[2])
+	if live != nil {
+		// the live view shows the progress instead
+		live.progress(p.op, pct)
+		return
+	}
+	if p.done || pct < 100 && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last, p.done = now, pct == 100
+	fields := logrus.Fields{"op": p.op, "percent": pct}
+	if pct > 0 && pct < 100 {
+		elapsed := now.Sub(p.start)
+		fields["eta"] = (elapsed * time.Duration(100-pct) / time.Duration(pct)).Round(time.Second).String()
+	}
+	logrus.WithFields(fields).Infof("%s %d%% (%s/%s)%s", m[1], pct, m[3], m[4], strings.TrimRight(m[5], " "))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// provenanceRefPrefix is where the provenance of published tags is pushed,
+// as a commit with provenance.json and its signature provenance.json.asc.
+const provenanceRefPrefix = "refs/kksyncer/provenance/"
+
+// in-toto statement with a SLSA v1 provenance predicate, see
+// https://slsa.dev/spec/v1.0/provenance
+type (
+	statement struct {
+		Type          string               `json:"_type"`
+		Subject       []resourceDescriptor `json:"subject"`
+		PredicateType string               `json:"predicateType"`
+		Predicate     provenance           `json:"predicate"`
+	}
+	resourceDescriptor struct {
+		Name   string            `json:"name,omitempty"`
+		URI    string            `json:"uri,omitempty"`
+		Digest map[string]string `json:"digest"`
+	}
+	provenance struct {
+		BuildDefinition struct {
+			BuildType            string               `json:"buildType"`
+			ExternalParameters   map[string]any       `json:"externalParameters"`
+			InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+			ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				ID      string            `json:"id"`
+				Version map[string]string `json:"version,omitempty"`
+			} `json:"builder"`
+			Metadata struct {
+				StartedOn  time.Time `json:"startedOn"`
+				FinishedOn time.Time `json:"finishedOn"`
+			} `json:"metadata"`
+		} `json:"runDetails"`
+	}
+)
+
+var (
+	signerOnce sync.Once
+	signer     *openpgp.Entity
+	signerErr  error
+)
+
+// getSigner returns the key of --provenance-key, or nil if the provenance
+// isn't signed.
+func getSigner() (*openpgp.Entity, error) {
+	signerOnce.Do(func() {
+		if *provenanceKey == "" {
+			return
+		}
+		b, err := os.ReadFile(*provenanceKey)
+		if err != nil {
+			signerErr = fmt.Errorf("failed to read provenance key: %v", err)
+			return
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+		if err != nil {
+			signerErr = fmt.Errorf("failed to parse provenance key %s: %v", *provenanceKey, err)
+			return
+		}
+		for _, key := range keys {
+			if key.PrivateKey == nil {
+				continue
+			}
+			if key.PrivateKey.Encrypted {
+				signerErr = fmt.Errorf("provenance key %s is encrypted", *provenanceKey)
+				return
+			}
+			signer = key
+			return
+		}
+		signerErr = fmt.Errorf("no private key in %s", *provenanceKey)
+	})
+	return signer, signerErr
+}
+
+// toolVersion returns the module version and VCS revision kksyncer was built
+// from.
+func toolVersion() map[string]string {
+	version := map[string]string{}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version
+	}
+	version["kksyncer"] = info.Main.Version
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			version["revision"] = s.Value
+		}
+	}
+	return version
+}
+
+// writeProvenance records how the published commit of tag was made from the
+// upstream tag object tagHash and its commit, and stores it as a commit for
+// the returned ref. files are the rewritten files in the worktree at root.
+func writeProvenance(r *gogit.Repository, tag string, tagHash, source, published plumbing.Hash, root string, files []string, started time.Time) (plumbing.ReferenceName, error) {
+	st := statement{
+		Type:          "https://in-toto.io/Statement/v1",
+		PredicateType: "https://slsa.dev/provenance/v1",
+		Subject: []resourceDescriptor{{
+			Name:   tag + "-mod",
+			URI:    "git+" + *targetRepo + "@" + *targetRefPrefix + tag + "-mod",
+			Digest: map[string]string{"gitCommit": published.String()},
+		}},
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(f)))
+		if errors.Is(err, os.ErrNotExist) {
+			// pruned or removed workspace files
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(b)
+		st.Subject = append(st.Subject, resourceDescriptor{Name: f, Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])}})
+	}
+
+	p := &st.Predicate
+	p.BuildDefinition.BuildType = "https://github.com/hunshcn/kksyncer/rewrite@v1"
+	p.BuildDefinition.ExternalParameters = map[string]any{
+		"sourceRepo":           *sourceRepo,
+		"tag":                  tag,
+		"sumMode":              *sumMode,
+		"modfileGlob":          *modfileGlob,
+		"goWork":               *goWork,
+		"prunePaths":           *prunePaths,
+		"propagateRetractions": *propagateRetract,
+	}
+	if out, err := exec.Command("go", "env", "GOVERSION").Output(); err == nil {
+		p.BuildDefinition.InternalParameters = map[string]any{"goVersion": strings.TrimSpace(string(out))}
+	}
+	digest := map[string]string{"gitCommit": source.String()}
+	if tagHash != source {
+		digest["gitTag"] = tagHash.String()
+	}
+	p.BuildDefinition.ResolvedDependencies = []resourceDescriptor{{
+		URI:    "git+" + *sourceRepo + "@refs/tags/" + tag,
+		Digest: digest,
+	}}
+	p.RunDetails.Builder.ID = "https://github.com/hunshcn/kksyncer"
+	p.RunDetails.Builder.Version = toolVersion()
+	p.RunDetails.Metadata.StartedOn = started.UTC()
+	p.RunDetails.Metadata.FinishedOn = time.Now().UTC()
+
+	doc, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	doc = append(doc, '\n')
+	objects := map[string][]byte{"provenance.json": doc}
+	key, err := getSigner()
+	if err != nil {
+		return "", err
+	}
+	if key != nil {
+		var sig bytes.Buffer
+		if err = openpgp.ArmoredDetachSign(&sig, key, bytes.NewReader(doc), nil); err != nil {
+			return "", fmt.Errorf("failed to sign provenance: %v", err)
+		}
+		objects["provenance.json.asc"] = sig.Bytes()
+	}
+
+	h, err := writeCommit(r, objects, conventional("chore(provenance): record the provenance of ", "Provenance of ")+tag+"-mod", time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to write provenance: %v", err)
+	}
+	ref := plumbing.ReferenceName(provenanceRefPrefix + tag + "-mod")
+	if err = r.Storer.SetReference(plumbing.NewHashReference(ref, h)); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
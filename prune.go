@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// prunePatterns returns the patterns of --prune-paths.
+func prunePatterns() []string {
+	var patterns []string
+	for _, p := range strings.Split(*prunePaths, ",") {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// findPrunePaths returns the slash separated paths of the files and
+// directories under root matching --prune-paths, like test,hack,cluster.
+func findPrunePaths(root string) ([]string, error) {
+	patterns := prunePatterns()
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if rel == ".git" {
+			return filepath.SkipDir
+		}
+		for _, pattern := range patterns {
+			if matchGlob(pattern, rel) {
+				paths = append(paths, rel)
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// appendUnpruned appends the paths of extra that aren't under one of the
+// pruned paths to pruned.
+func appendUnpruned(pruned, extra []string) []string {
+	n := len(pruned)
+	for _, p := range extra {
+		if !slices.ContainsFunc(pruned[:n], func(q string) bool { return p == q || strings.HasPrefix(p, q+"/") }) {
+			pruned = append(pruned, p)
+		}
+	}
+	return pruned
+}
+
+// verifyBuild builds the packages of the modules of modFiles in the tree at
+// root, so pruning can't publish a broken module.
+func verifyBuild(ctx context.Context, root string, modFiles []string, env []string) error {
+	for _, modFile := range modFiles {
+		cmd := commandContext(ctx, "go", append(append([]string{"build"}, goModFlags()...), "./...")...)
+		cmd.Dir = filepath.Join(root, filepath.FromSlash(path.Dir(modFile)))
+		cmd.Env = goEnv(env)
+		logrus.Infof("Verifying the build of %s", modFile)
+		release := acquireGo()
+		out, err := cmd.CombinedOutput()
+		release()
+		if err != nil {
+			return fmt.Errorf("failed to build the module of %s: %v\n%s", modFile, err, out)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// longest upstream release notes copied, leaving room in the 125000
+// characters of a GitHub release body for the rest
+const maxReleaseNotes = 120000
+
+// releaser creates releases of the published tags on the target.
+type releaser interface {
+	createRelease(ctx context.Context, tag, target, body string, assets []releaseAsset) error
+}
+
+// getReleaser returns the releaser of the target host, or nil if it has no
+// known API.
+func getReleaser() releaser {
+	if targetForge() == forgeGitee {
+		return giteeAPI{}
+	}
+	if g := getGitHubAPI(); g != nil {
+		return g
+	}
+	return nil
+}
+
+// publishRelease creates the release of the published tagName of upstream tag
+// name with assets. The tag is already pushed, so failures are only logged,
+// and releases hitting the rate limit are left to be created by hand.
+func publishRelease(ctx context.Context, name, tagName, commit string, assets []releaseAsset) {
+	rel := getReleaser()
+	if rel == nil {
+		logrus.Warnf("Not creating a release of %s, the target %s has no known API", tagName, targetHost())
+		return
+	}
+	body := fmt.Sprintf("Go module release of upstream tag %s, with the replaced modules pinned to published versions.", name)
+	if *copyReleaseNotes {
+		body += upstreamReleaseNotes(ctx, name)
+	}
+	err := rel.createRelease(ctx, tagName, commit, body, assets)
+	if errors.Is(err, errRateLimited) {
+		logrus.Warnf("Deferred the release of %s: %v", tagName, err)
+		return
+	}
+	if err != nil {
+		logrus.Warnf("Failed to create the release of %s: %v", tagName, err)
+		return
+	}
+	logrus.Infof("Created the release of %s", tagName)
+}
+
+// createRelease creates a release of tag on the target.
+func (g *githubAPI) createRelease(ctx context.Context, tag, target, body string, assets []releaseAsset) error {
+	var created struct {
+		UploadURL string `json:"upload_url"`
+	}
+	err := g.do(ctx, http.MethodPost, "/repos/"+targetRepoPath()+"/releases", map[string]any{
+		"tag_name":         tag,
+		"name":             tag,
+		"body":             body,
+		"target_commitish": target,
+	}, &created)
+	if err != nil {
+		return err
+	}
+	// like https://uploads.github.com/repos/o/r/releases/1/assets{?name,label}
+	upload, _, _ := strings.Cut(created.UploadURL, "{")
+	for _, a := range assets {
+		if err = g.send(ctx, http.MethodPost, upload+"?name="+url.QueryEscape(a.name), "application/octet-stream", a.data, nil); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", a.name, err)
+		}
+	}
+	return nil
+}
+
+// upstreamReleaseNotes returns the notes of the upstream release of tag with
+// their source, to append to the body of the published release, or "" if
+// they can't be fetched.
+func upstreamReleaseNotes(ctx context.Context, tag string) string {
+	g := getUpstreamAPI()
+	if g == nil {
+		logrus.Warnf("Not copying the release notes of %s, the upstream %s is not on GitHub", tag, repoHost(*sourceRepo))
+		return ""
+	}
+	var rel struct {
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := g.do(ctx, http.MethodGet, "/repos/"+repoPath(*sourceRepo)+"/releases/tags/"+url.PathEscape(tag), nil, &rel); err != nil {
+		logrus.Warnf("Failed to get the upstream release notes of %s: %v", tag, err)
+		return ""
+	}
+	if strings.TrimSpace(rel.Body) == "" {
+		return ""
+	}
+	notes := fmt.Sprintf("\n\n## Upstream release notes\n\nCopied from %s:\n\n%s", rel.HTMLURL, rel.Body)
+	if len(notes) > maxReleaseNotes {
+		notes = strings.ToValidUTF8(notes[:maxReleaseNotes], "") + fmt.Sprintf("\n\n[Truncated, see %s for the full notes.]", rel.HTMLURL)
+	}
+	return notes
+}
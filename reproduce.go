@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// runReproduce rewrites the upstream tag of a published tag again in a
+// temporary worktree, and checks the result matches the published
+// commit. Nothing is pushed.
+func runReproduce(ctx context.Context) error {
+	published := *tagFlag
+	if published == "" {
+		published = flag.Arg(0)
+	}
+	name, ok := strings.CutSuffix(published, "-mod")
+	if !ok {
+		return errors.New("usage: reproduce [flags] --tag <tag>-mod")
+	}
+	r, err := openWorkdir(ctx, sourceRemote, targetRemote)
+	if err != nil {
+		return err
+	}
+	source, err := remoteTags(r, sourceRemote)
+	if err != nil {
+		return fmt.Errorf("failed to iterate through %s tags: %v", sourceRemote, err)
+	}
+	target, err := remoteTags(r, targetRemote)
+	if err != nil {
+		return fmt.Errorf("failed to iterate through %s tags: %v", targetRemote, err)
+	}
+	kh, ok := source[name]
+	if !ok {
+		return fmt.Errorf("upstream tag %s not found", name)
+	}
+	ph, ok := target[published]
+	if !ok {
+		return fmt.Errorf("tag %s not found on the target", published)
+	}
+	want, err := tagCommit(r, ph)
+	if err != nil {
+		return fmt.Errorf("failed to get commit of %s: %v", published, err)
+	}
+	// the deleted tags retracted when it was published, later deletions
+	// aren't in its go.mod
+	var retracted []string
+	if *propagateRetract {
+		if retracted, err = publishedRetractions(want); err != nil {
+			return fmt.Errorf("failed to read the retractions of %s: %v", published, err)
+		}
+	}
+
+	got, remove, err := rebuildTag(ctx, name, kh, want, retracted)
+	if err != nil {
+		return err
+	}
+	defer remove()
+	wantTree, err := publishedTree(want)
+	if err != nil {
+		return err
+	}
+	gotTree, err := got.Tree()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("published: commit %s tree %s\n", want.Hash, wantTree.Hash)
+	fmt.Printf("rebuilt:   commit %s tree %s\n", got.Hash, gotTree.Hash)
+	if gotTree.Hash != wantTree.Hash {
+		if err = printTreeDiff(wantTree, gotTree); err != nil {
+			return err
+		}
+		return fmt.Errorf("tree of %s doesn't match the rebuilt one, check the rewrite flags", published)
+	}
+	if got.Hash != want.Hash {
+		fmt.Printf("the trees match, the commits differ in metadata, like author, time or parents\n")
+		return nil
+	}
+	fmt.Printf("%s is reproducible\n", published)
+	return nil
+}
+
+// rebuildTag rewrites upstream tag name at kh again in a temporary worktree,
+// on top of the same previous commit as the published commit want with
+// --linear-history, and returns the rewrite commit and a function removing
+// the worktree, which holds the objects of the commit.
+func rebuildTag(ctx context.Context, name string, kh plumbing.Hash, want *object.Commit, retracted []string) (*object.Commit, func(), error) {
+	wk, remove, err := tempWorker(&worker{env: tagEnv(name)}, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the first parent of a linear history commit is the previous one
+	var prev plumbing.Hash
+	if *linearHistory && len(want.ParentHashes) == 2 {
+		prev = want.ParentHashes[0]
+	}
+	rw, err := rewriteTag(ctx, wk, name, kh, retracted, prev)
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	got, err := wk.repo.CommitObject(rw.commit)
+	if err != nil {
+		remove()
+		return nil, nil, err
+	}
+	return got, remove, nil
+}
+
+// publishedTree returns the rewritten tree of the published commit c, the
+// one under --target-subdir if set.
+func publishedTree(c *object.Commit) (*object.Tree, error) {
+	tree, err := c.Tree()
+	if err != nil || *targetSubdir == "" {
+		return tree, err
+	}
+	return tree.Tree(*targetSubdir)
+}
+
+// printTreeDiff prints the files that differ between the published and the
+// rebuilt tree, with the diffs of go.mod files and friends.
+func printTreeDiff(wantTree, gotTree *object.Tree) error {
+	changes, err := object.DiffTree(wantTree, gotTree)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		name := c.To.Name
+		if name == "" {
+			name = c.From.Name
+		}
+		fmt.Printf("differs: %s\n", name)
+		switch path.Base(name) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+		default:
+			continue
+		}
+		from, to, err := c.Files()
+		if err != nil {
+			return err
+		}
+		var a, b string
+		if from != nil {
+			if a, err = from.Contents(); err != nil {
+				return err
+			}
+		}
+		if to != nil {
+			if b, err = to.Contents(); err != nil {
+				return err
+			}
+		}
+		fmt.Print(unifiedDiff("published/"+name, "rebuilt/"+name, a, b))
+	}
+	return nil
+}
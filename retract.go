@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// deletedTags returns the upstream tags published on the target which no
+// longer exist upstream. Only the tags of upstream versions count, the tags
+// of nested modules like api/v0.30.0-mod, of --module-tags rules removed
+// since too, and other tags can't be retracted by the root go.mod.
+func deletedTags(source, target map[string]plumbing.Hash) []string {
+	var deleted []string
+	for name := range target {
+		base, ok := strings.CutSuffix(name, "-mod")
+		if !ok || strings.Contains(base, "/") || !semver.IsValid(base) {
+			continue
+		}
+		if _, ok = source[base]; !ok {
+			deleted = append(deleted, base)
+		}
+	}
+	slices.SortFunc(deleted, semver.Compare)
+	return deleted
+}
+
+// propagateRetractions maps the retract directives of the rewritten root
+// go.mod to the published versions and retracts the published versions of
+// the deleted upstream tags. The go command only reads retractions from the
+// latest version, so they take effect with the next published tag.
+func propagateRetractions(fileSystem billy.Filesystem, deleted []string) error {
+	b, err := os.ReadFile(filepath.Join(fileSystem.Root(), "go.mod"))
+	if err != nil {
+		return fmt.Errorf("failed to read go.mod: %v", err)
+	}
+	modFile, err := modfile.Parse("go.mod", b, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse go.mod: %v", err)
+	}
+	for _, retract := range slices.Clone(modFile.Retract) {
+		// DropRetract clears retract
+		vi, rationale := retract.VersionInterval, retract.Rationale
+		if strings.HasSuffix(vi.Low, "-mod") {
+			continue
+		}
+		if err = modFile.DropRetract(vi); err != nil {
+			return fmt.Errorf("failed to drop retract %s: %v", vi.Low, err)
+		}
+		mapped := modfile.VersionInterval{Low: vi.Low + "-mod", High: vi.High + "-mod"}
+		if err = modFile.AddRetract(mapped, rationale); err != nil {
+			return fmt.Errorf("failed to retract %s: %v", mapped.Low, err)
+		}
+	}
+	for _, name := range deleted {
+		v := name + "-mod"
+		if err = modFile.AddRetract(modfile.VersionInterval{Low: v, High: v}, deletedRationale(name)); err != nil {
+			return fmt.Errorf("failed to retract %s: %v", v, err)
+		}
+	}
+	modFile.Cleanup()
+	out, err := modFile.Format()
+	if err != nil {
+		return fmt.Errorf("failed to format go.mod: %v", err)
+	}
+	return writeFile(fileSystem, "go.mod", out)
+}
+
+// deletedRationale returns the rationale of the retraction of the published
+// version of the deleted upstream tag name.
+func deletedRationale(name string) string {
+	return "upstream tag " + name + " was deleted"
+}
+
+// publishedRetractions returns the deleted upstream tags retracted by the
+// root go.mod of the published commit c, the ones known when it was
+// published, to rewrite its upstream tag again the same way.
+func publishedRetractions(c *object.Commit) ([]string, error) {
+	tree, err := publishedTree(c)
+	if err != nil {
+		return nil, err
+	}
+	f, err := tree.File("go.mod")
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	b, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return deletedRetractions([]byte(b))
+}
+
+// deletedRetractions returns the deleted upstream tags retracted by the
+// go.mod b, by the rationales of propagateRetractions.
+func deletedRetractions(b []byte) ([]string, error) {
+	modFile, err := modfile.Parse("go.mod", b, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go.mod: %v", err)
+	}
+	var deleted []string
+	for _, r := range modFile.Retract {
+		name, ok := strings.CutPrefix(r.Rationale, "upstream tag ")
+		if name, ok2 := strings.CutSuffix(name, " was deleted"); ok && ok2 && r.Low == name+"-mod" {
+			deleted = append(deleted, name)
+		}
+	}
+	slices.SortFunc(deleted, semver.Compare)
+	return deleted, nil
+}
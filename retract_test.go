@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestDeletedTags(t *testing.T) {
+	source := map[string]plumbing.Hash{"v1.30.0": {}, "v1.30.1": {}}
+	target := map[string]plumbing.Hash{
+		"v1.29.0-mod": {},
+		"v1.30.0-mod": {},
+		"v1.30.1-mod": {},
+		"v1.28.3-mod": {},
+		// nested modules, also of removed --module-tags rules
+		"staging/src/k8s.io/api/v0.30.0-mod": {},
+		"api/v0.29.0-mod":                    {},
+		// made by hand
+		"latest-mod": {},
+		"v1.30":      {},
+	}
+	want := []string{"v1.28.3", "v1.29.0"}
+	if got := deletedTags(source, target); !slices.Equal(got, want) {
+		t.Errorf("deleted tags are %v, want %v", got, want)
+	}
+}
+
+func TestDeletedRetractions(t *testing.T) {
+	dir := t.TempDir()
+	upstream := "module example.com/m\n\ngo 1.22\n\n// broken build\nretract v1.1.0\n"
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(upstream), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deleted := []string{"v1.0.1", "v1.2.0-rc.1"}
+	if err := propagateRetractions(osfs.New(dir), deleted); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := deletedRetractions(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not the upstream retraction, which the rewrite maps again
+	if !slices.Equal(got, deleted) {
+		t.Errorf("retracted deleted tags of\n%s\nare %v, want %v", b, got, deleted)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// runRewrite rewrites a go.mod for an upstream tag outside of git: the one on
+// stdin to stdout, or with --dir the go.mod files of a local checkout in
+// place.
+func runRewrite(ctx context.Context) error {
+	name := *tagFlag
+	if name == "" {
+		name = flag.Arg(0)
+	}
+	if name == "" {
+		return errors.New("usage: rewrite [flags] --tag <tag> [--dir <dir>] [< go.mod]")
+	}
+	if *rewriteDir != "" {
+		return rewriteCheckout(ctx, *rewriteDir, name)
+	}
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read go.mod from stdin: %v", err)
+	}
+	version, err := pinVersion(name)
+	if err != nil {
+		return err
+	}
+	rw, err := rewriteModFile(b, name, version)
+	if err != nil {
+		return err
+	}
+	rw.file.Cleanup()
+	out, err := rw.file.Format()
+	if err != nil {
+		return fmt.Errorf("failed to format go.mod: %v", err)
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+// rewriteCheckout rewrites the go.mod and go.work files of the checkout of
+// upstream tag name at dir in place, like the sync does before committing,
+// plugins and tidy included.
+func rewriteCheckout(ctx context.Context, dir, name string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	modFiles, err := findModFiles(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find go.mod files: %v", err)
+	}
+	if len(modFiles) == 0 {
+		return fmt.Errorf("no go.mod matching --modfile-glob %s in %s", *modfileGlob, dir)
+	}
+	fileSystem := osfs.New(dir)
+	if _, err = prepareModFiles(ctx, fileSystem, modFiles, name, tagEnv(name)); err != nil {
+		return err
+	}
+	workFiles, err := prepareGoWork(fileSystem)
+	if err != nil {
+		return fmt.Errorf("failed to prepare go.work: %v", err)
+	}
+	for _, f := range append(modFiles, workFiles...) {
+		fmt.Println(f)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// rewriteRule is a rule of --rewrite-rules, applied to each rewritten go.mod
+// of the upstream tags in its version range.
+type rewriteRule struct {
+	op   string
+	args []string
+	when versionRange
+	// module path pattern of the go.mod files the rule applies to, empty
+	// for all
+	in string
+	// the rule text, for errors
+	text string
+}
+
+// rewriteRules are the parsed --rewrite-rules.
+var rewriteRules []*rewriteRule
+
+// parseRules parses rules separated by semicolons or newlines, like
+// "drop-replace k8s.io/*; pin golang.org/x/net v0.23.0 if >=1.30.0":
+//
+//	drop-replace <module pattern>   drops the replaces of matching modules
+//	pin <module pattern> <version>  sets the version of matching requires
+//	require <module> <version>      adds or sets a require
+//	exclude <module> <version>      adds an exclude
+//	drop-godebug <key pattern>      drops matching godebug settings
+//	drop-tool <package pattern>     drops matching tool directives
+//	go <version>                    sets the go directive
+//
+// Patterns have path.Match syntax, versions can use ${version}, the version
+// the replaced modules are pinned to, and ${tag}, the upstream tag. A rule
+// followed by "in <module pattern>" only applies to the go.mod files of
+// matching modules, like the require of an import added by a site patch, and
+// one ending with "if <version range>" only to the tags in that range.
+func parseRules(s string) ([]*rewriteRule, error) {
+	var rules []*rewriteRule
+	for _, text := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '\n' }) {
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := &rewriteRule{text: text}
+		body, cond, ok := strings.Cut(text, " if ")
+		if ok {
+			var err error
+			if rule.when, err = parseVersionRange(cond); err != nil {
+				return nil, fmt.Errorf("invalid rule %q: %v", text, err)
+			}
+		}
+		if body, rule.in, ok = strings.Cut(body, " in "); ok {
+			rule.in = strings.TrimSpace(rule.in)
+			if _, err := path.Match(rule.in, ""); err != nil || rule.in == "" {
+				return nil, fmt.Errorf("invalid rule %q: invalid module pattern %q", text, rule.in)
+			}
+		}
+		fields := strings.Fields(body)
+		rule.op, rule.args = fields[0], fields[1:]
+		want := map[string]int{"drop-replace": 1, "pin": 2, "require": 2, "exclude": 2, "drop-godebug": 1, "drop-tool": 1, "go": 1}
+		n, known := want[rule.op]
+		if !known {
+			return nil, fmt.Errorf("invalid rule %q: unknown rule %s, known rules are drop-replace, pin, require, exclude, drop-godebug, drop-tool and go", text, rule.op)
+		}
+		if len(rule.args) != n {
+			return nil, fmt.Errorf("invalid rule %q: %s takes %d arguments", text, rule.op, n)
+		}
+		if rule.op != "go" && rule.op != "require" && rule.op != "exclude" {
+			if _, err := path.Match(rule.args[0], ""); err != nil {
+				return nil, fmt.Errorf("invalid rule %q: %v", text, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// rulesVaryByTag returns whether the rules give different results for tags
+// with the same upstream go.mod, so tidy results can't be reused.
+func rulesVaryByTag() bool {
+	for _, r := range rewriteRules {
+		if r.when != nil || strings.Contains(strings.Join(r.args, " "), "${tag}") {
+			return true
+		}
+	}
+	return false
+}
+
+// applyRules applies the rules matching tag to modFile, and returns the
+// requires set by pin rules.
+func applyRules(modFile *modfile.File, tag, version string) ([]module.Version, error) {
+	expand := strings.NewReplacer("${version}", version, "${tag}", tag)
+	pins := map[string]string{}
+	modPath := ""
+	if modFile.Module != nil {
+		modPath = modFile.Module.Mod.Path
+	}
+	for _, r := range rewriteRules {
+		if r.when != nil && !r.when.match(tag) {
+			continue
+		}
+		if ok, _ := path.Match(r.in, modPath); r.in != "" && !ok {
+			continue
+		}
+		var err error
+		switch r.op {
+		case "drop-replace":
+			for _, rep := range modFile.Replace {
+				if ok, _ := path.Match(r.args[0], rep.Old.Path); ok && rep.Old.Path != "" {
+					if err = modFile.DropReplace(rep.Old.Path, rep.Old.Version); err != nil {
+						break
+					}
+				}
+			}
+		case "pin":
+			for _, req := range modFile.Require {
+				if ok, _ := path.Match(r.args[0], req.Mod.Path); ok {
+					v := expand.Replace(r.args[1])
+					if err = modFile.AddRequire(req.Mod.Path, v); err != nil {
+						break
+					}
+					pins[req.Mod.Path] = v
+				}
+			}
+		case "require":
+			err = modFile.AddRequire(r.args[0], expand.Replace(r.args[1]))
+		case "exclude":
+			err = modFile.AddExclude(r.args[0], expand.Replace(r.args[1]))
+		case "drop-godebug":
+			for _, g := range modFile.Godebug {
+				// dropped entries are zeroed until the cleanup
+				if ok, _ := path.Match(r.args[0], g.Key); ok && g.Key != "" {
+					if err = modFile.DropGodebug(g.Key); err != nil {
+						break
+					}
+				}
+			}
+		case "drop-tool":
+			for _, t := range modFile.Tool {
+				if ok, _ := path.Match(r.args[0], t.Path); ok && t.Path != "" {
+					if err = modFile.DropTool(t.Path); err != nil {
+						break
+					}
+				}
+			}
+		case "go":
+			err = modFile.AddGoStmt(strings.TrimPrefix(expand.Replace(r.args[0]), "go"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %v", r.text, err)
+		}
+	}
+	var pinned []module.Version
+	for _, p := range slices.Sorted(maps.Keys(pins)) {
+		pinned = append(pinned, module.Version{Path: p, Version: pins[p]})
+	}
+	return pinned, nil
+}
+
+// heldPins returns a line for each of pins as required by the go.mod at
+// modFile under root, noting the pins raised by tidy to satisfy other
+// requirements.
+func heldPins(root, modFile string, pins []module.Version) ([]string, error) {
+	if len(pins) == 0 {
+		return nil, nil
+	}
+	b, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(modFile)))
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.ParseLax(modFile, b, nil)
+	if err != nil {
+		return nil, err
+	}
+	required := map[string]string{}
+	for _, r := range f.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	var lines []string
+	for _, p := range pins {
+		got, ok := required[p.Path]
+		switch {
+		case !ok:
+			// dropped by tidy, nothing imports it
+		case got != p.Version:
+			logrus.Warnf("Pinned %s %s raised to %s in %s to satisfy other requirements", p.Path, p.Version, got, modFile)
+			lines = append(lines, fmt.Sprintf("%s %s (pinned %s)", p.Path, got, p.Version))
+		default:
+			lines = append(lines, p.Path+" "+got)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// phaseTime is the time spent in a phase.
+type phaseTime struct {
+	wall, cpu time.Duration
+}
+
+// runProfile records the time of each phase of each tag with --profile-run.
+// The CPU time is the one of the whole process and the go commands it ran,
+// so it is only attributed to the right tag with one worker.
+var runProfile = struct {
+	mu sync.Mutex
+	// phases in the order they were first seen
+	phases []string
+	// by tag, then phase, "" for the phases of the run like the fetch
+	times map[string]map[string]*phaseTime
+}{times: map[string]map[string]*phaseTime{}}
+
+// recordPhase adds the time since started, and the CPU time since cpu, to
+// the phase of tag.
+func recordPhase(tag, phase string, started time.Time, cpu time.Duration) {
+	if !*profileRun || phase == "" {
+		return
+	}
+	runProfile.mu.Lock()
+	defer runProfile.mu.Unlock()
+	if !slices.Contains(runProfile.phases, phase) {
+		runProfile.phases = append(runProfile.phases, phase)
+	}
+	if runProfile.times[tag] == nil {
+		runProfile.times[tag] = map[string]*phaseTime{}
+	}
+	t := runProfile.times[tag][phase]
+	if t == nil {
+		t = &phaseTime{}
+		runProfile.times[tag][phase] = t
+	}
+	t.wall += time.Since(started)
+	t.cpu += cpuTime() - cpu
+}
+
+// timePhase records the time of the run phase from now until the returned
+// function is called.
+func timePhase(phase string) func() {
+	started, cpu := time.Now(), cpuTime()
+	return func() { recordPhase("", phase, started, cpu) }
+}
+
+// printRunProfile prints the wall and CPU time of every phase by tag, and
+// the total of each phase with its share of the time of the tags, and starts
+// over for the next run of the service.
+func printRunProfile() {
+	if !*profileRun {
+		return
+	}
+	runProfile.mu.Lock()
+	defer runProfile.mu.Unlock()
+	defer func() {
+		runProfile.phases, runProfile.times = nil, map[string]map[string]*phaseTime{}
+	}()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "TAG\t"+strings.Join(runProfile.phases, "\t")+"\t")
+	// of all tags and the run, and of the tags only for the shares
+	total, tags := map[string]*phaseTime{}, map[string]time.Duration{}
+	var all time.Duration
+	row := func(name string, times map[string]*phaseTime) {
+		cells := []string{name}
+		for _, p := range runProfile.phases {
+			t := times[p]
+			if t == nil {
+				cells = append(cells, "-")
+				continue
+			}
+			cells = append(cells, fmt.Sprintf("%s (cpu %s)", t.wall.Round(time.Millisecond), t.cpu.Round(time.Millisecond)))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t")+"\t")
+	}
+	for _, tag := range slices.Sorted(maps.Keys(runProfile.times)) {
+		name := tag
+		if tag == "" {
+			name = "(run)"
+		}
+		row(name, runProfile.times[tag])
+		for p, t := range runProfile.times[tag] {
+			if total[p] == nil {
+				total[p] = &phaseTime{}
+			}
+			total[p].wall += t.wall
+			total[p].cpu += t.cpu
+			if tag != "" {
+				tags[p] += t.wall
+				all += t.wall
+			}
+		}
+	}
+	row("TOTAL", total)
+	_ = tw.Flush()
+	if all == 0 {
+		return
+	}
+	var shares []string
+	for _, p := range runProfile.phases {
+		if d, ok := tags[p]; ok {
+			shares = append(shares, fmt.Sprintf("%s %.0f%%", p, 100*float64(d)/float64(all)))
+		}
+	}
+	fmt.Println("Share of the time of the tags:", strings.Join(shares, ", "))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// buildModule is a module of the build list, as printed by go list -m -json.
+type buildModule struct {
+	Path    string
+	Version string
+}
+
+func (m buildModule) purl() string {
+	return "pkg:golang/" + m.Path + "@" + m.Version
+}
+
+// moduleGraph returns the build list of the module in dir, the main module
+// first, and the requirements between the selected versions.
+func moduleGraph(ctx context.Context, dir string, env []string) ([]buildModule, map[string][]string, error) {
+	cmd := commandContext(ctx, "go", append(append([]string{"list", "-m", "-json"}, goModFlags()...), "all")...)
+	cmd.Dir, cmd.Env = dir, goEnv(env)
+	release := acquireGo()
+	out, err := cmd.Output()
+	release()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list modules: %v", err)
+	}
+	var mods []buildModule
+	selected := map[string]string{}
+	for dec := json.NewDecoder(bytes.NewReader(out)); ; {
+		var m buildModule
+		if err = dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse modules: %v", err)
+		}
+		mods = append(mods, m)
+		selected[m.Path] = m.Version
+	}
+
+	cmd = commandContext(ctx, "go", "mod", "graph")
+	cmd.Dir, cmd.Env = dir, goEnv(env)
+	release = acquireGo()
+	out, err = cmd.Output()
+	release()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get module graph: %v", err)
+	}
+	// keep the edges between selected versions, by module path
+	deps := map[string][]string{}
+	for sc := bufio.NewScanner(bytes.NewReader(out)); sc.Scan(); {
+		from, to, ok := strings.Cut(sc.Text(), " ")
+		if !ok {
+			continue
+		}
+		fromPath, fromVersion, _ := strings.Cut(from, "@")
+		toPath, toVersion, _ := strings.Cut(to, "@")
+		if selected[fromPath] == fromVersion && selected[toPath] == toVersion {
+			deps[fromPath] = append(deps[fromPath], toPath)
+		}
+	}
+	return mods, deps, nil
+}
+
+// publishedGraph returns the module graph of the rewritten module of modFile
+// in the tree at root, as published for tag.
+func publishedGraph(ctx context.Context, root, modFile, tag string, env []string) ([]buildModule, map[string][]string, error) {
+	dir := filepath.Join(root, filepath.FromSlash(path.Dir(modFile)))
+	// go list adds go.sum lines for the whole module graph, which must not
+	// end up in the worktree
+	restore, err := preserveFiles(filepath.Join(dir, "go.mod"), filepath.Join(dir, "go.sum"), filepath.Join(root, "go.work.sum"))
+	if err != nil {
+		return nil, nil, err
+	}
+	mods, deps, err := moduleGraph(ctx, dir, env)
+	if rerr := restore(); rerr != nil && err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get module graph of %s: %v", modFile, err)
+	}
+	// the main module has no version in the build list
+	mods[0].Version = tag + "-mod"
+	return mods, deps, nil
+}
+
+// writeSBOM writes the SBOM of each of the rewritten modules of tag to
+// <sbom-dir>/<tag>-mod/<module dir>/sbom.<format>.json.
+func writeSBOM(ctx context.Context, root string, modFiles []string, tag string, env []string) error {
+	for _, modFile := range modFiles {
+		mods, deps, err := publishedGraph(ctx, root, modFile, tag, env)
+		if err != nil {
+			return err
+		}
+		var doc any
+		switch *sbomFormat {
+		case "cyclonedx":
+			doc = cycloneDX(mods, deps)
+		default:
+			doc = spdx(mods, deps)
+		}
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(*sbomDir, tag+"-mod", filepath.FromSlash(path.Dir(modFile)), "sbom."+*sbomFormat+".json")
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err = os.WriteFile(name, append(b, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", name, err)
+		}
+		logrus.Infof("Wrote SBOM of %s with %d modules to %s", mods[0].Path, len(mods), name)
+	}
+	return nil
+}
+
+// preserveFiles saves the content of files, and returns a function restoring
+// them, removing the ones that didn't exist.
+func preserveFiles(files ...string) (func() error, error) {
+	saved := map[string][]byte{}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		saved[f] = b
+	}
+	return func() error {
+		for f, b := range saved {
+			var err error
+			if b == nil {
+				err = os.Remove(f)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				err = os.WriteFile(f, b, 0644)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %v", f, err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// spdx returns an SPDX 2.3 document of the modules, see
+// https://spdx.github.io/spdx-spec/v2.3/
+func spdx(mods []buildModule, deps map[string][]string) map[string]any {
+	ids := map[string]string{}
+	var packages, relationships []map[string]any
+	for i, m := range mods {
+		ids[m.Path] = fmt.Sprintf("SPDXRef-Package-%d", i)
+		packages = append(packages, map[string]any{
+			"name":             m.Path,
+			"SPDXID":           ids[m.Path],
+			"versionInfo":      m.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  m.purl(),
+			}},
+		})
+	}
+	relationships = append(relationships, map[string]any{
+		"spdxElementId":      "SPDXRef-DOCUMENT",
+		"relationshipType":   "DESCRIBES",
+		"relatedSpdxElement": ids[mods[0].Path],
+	})
+	for _, m := range mods {
+		for _, dep := range deps[m.Path] {
+			relationships = append(relationships, map[string]any{
+				"spdxElementId":      ids[m.Path],
+				"relationshipType":   "DEPENDS_ON",
+				"relatedSpdxElement": ids[dep],
+			})
+		}
+	}
+	return map[string]any{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              mods[0].Path + "@" + mods[0].Version,
+		"documentNamespace": "https://github.com/hunshcn/kksyncer/sbom/" + mods[0].Path + "@" + mods[0].Version + "/" + newUUID(),
+		"creationInfo": map[string]any{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: kksyncer"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// cycloneDX returns a CycloneDX 1.5 BOM of the modules, see
+// https://cyclonedx.org/docs/1.5/json/
+func cycloneDX(mods []buildModule, deps map[string][]string) map[string]any {
+	refs := map[string]string{}
+	for _, m := range mods {
+		refs[m.Path] = m.purl()
+	}
+	component := func(m buildModule) map[string]any {
+		return map[string]any{
+			"type":    "library",
+			"bom-ref": m.purl(),
+			"name":    m.Path,
+			"version": m.Version,
+			"purl":    m.purl(),
+		}
+	}
+	var components, dependencies []map[string]any
+	for i, m := range mods {
+		if i > 0 {
+			components = append(components, component(m))
+		}
+		dependsOn := []string{}
+		for _, dep := range deps[m.Path] {
+			dependsOn = append(dependsOn, refs[dep])
+		}
+		dependencies = append(dependencies, map[string]any{"ref": m.purl(), "dependsOn": dependsOn})
+	}
+	return map[string]any{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newUUID(),
+		"version":      1,
+		"metadata": map[string]any{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools": map[string]any{
+				"components": []map[string]any{{"type": "application", "name": "kksyncer"}},
+			},
+			"component": component(mods[0]),
+		},
+		"components":   components,
+		"dependencies": dependencies,
+	}
+}
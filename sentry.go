@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sentryHook reports error logs, like the failures of tags and fatal errors,
+// to a Sentry compatible store API, so failures of scheduled runs are
+// aggregated instead of lost in CI logs. The fields of the entries, like the
+// tag and phase, become tags of the events.
+type sentryHook struct {
+	storeURL string
+	auth     string
+	client   *http.Client
+}
+
+// setupSentry adds the hook reporting errors to --sentry-dsn, like
+// https://<key>@o0.ingest.sentry.io/<project>.
+func setupSentry() error {
+	if *sentryDSN == "" {
+		return nil
+	}
+	u, err := url.Parse(*sentryDSN)
+	if err != nil || u.User == nil || u.Host == "" {
+		return fmt.Errorf("invalid Sentry DSN, want <scheme>://<key>@<host>/<project>")
+	}
+	dir, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if project == "" {
+		return fmt.Errorf("invalid Sentry DSN, no project")
+	}
+	auth := "Sentry sentry_version=7, sentry_client=kksyncer, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	logrus.AddHook(&sentryHook{
+		storeURL: u.Scheme + "://" + u.Host + dir + "api/" + project + "/store/",
+		auth:     auth,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	})
+	return nil
+}
+
+func (h *sentryHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+// Fire sends the event synchronously, logrus exits right after fatal entries.
+func (h *sentryHook) Fire(entry *logrus.Entry) error {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	tags := map[string]string{"config_hash": configHash()[:12]}
+	for k, v := range entry.Data {
+		tags[k] = fmt.Sprint(v)
+	}
+	event := map[string]any{
+		"event_id":  hex.EncodeToString(id),
+		"timestamp": entry.Time.UTC().Format(time.RFC3339),
+		"level":     entry.Level.String(),
+		"platform":  "go",
+		"logger":    "kksyncer",
+		"message":   entry.Message,
+		"tags":      tags,
+		"extra":     map[string]string{"sourceRepo": *sourceRepo, "targetRepo": redactURL(*targetRepo)},
+	}
+	if v := toolVersion()["kksyncer"]; v != "" {
+		event["release"] = "kksyncer@" + v
+	}
+	if host, err := os.Hostname(); err == nil {
+		event["server_name"] = host
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.storeURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", h.auth)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to report to Sentry: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to report to Sentry: %s", resp.Status)
+	}
+	return nil
+}
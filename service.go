@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how often the service checks whether the --config file changed
+const configPollInterval = 10 * time.Second
+
+// syncCommand runs sync once, or as a service with --interval.
+func syncCommand(ctx context.Context) error {
+	if *syncInterval <= 0 {
+		return runSync(ctx)
+	}
+	return serve(ctx)
+}
+
+// serve runs sync every --interval until ctx is canceled, which stops the
+// running sync. On SIGHUP, or when the --config file changes, the config is
+// reloaded once the running sync is done, so a run never sees two configs.
+func serve(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+	configTime := modTime(*configFile)
+	for {
+		if err := runSync(ctx); errors.Is(err, errNothingToDo) {
+			logrus.Info(err)
+		} else if err != nil {
+			logrus.Errorf("Sync failed: %v", err)
+		}
+		if ctx.Err() != nil {
+			logrus.Info("Interrupted, stopping")
+			return nil
+		}
+		if *syncInterval <= 0 {
+			logrus.Info("--interval is no longer set, stopping")
+			return nil
+		}
+		logrus.Infof("Next sync in %s", *syncInterval)
+		next := time.NewTimer(*syncInterval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				next.Stop()
+				logrus.Info("Interrupted, stopping")
+				return nil
+			case <-next.C:
+				break wait
+			case <-hup:
+				logrus.Info("Reloading the config on SIGHUP")
+			case <-poll.C:
+				t := modTime(*configFile)
+				if t.Equal(configTime) {
+					continue
+				}
+				logrus.Infof("Reloading the changed config %s", *configFile)
+			}
+			if err := reloadConfig(); err != nil {
+				logrus.Errorf("Failed to reload the config, keeping the previous one: %v", err)
+			}
+			configTime = modTime(*configFile)
+		}
+	}
+}
+
+// reloadConfig sets the flags not given on the command line again from their
+// defaults, environment variables and the --config file, restoring the
+// previous values if the new ones are invalid.
+func reloadConfig() error {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	previous := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		previous[f.Name] = f.Value.String()
+		if !set[f.Name] {
+			_ = f.Value.Set(f.DefValue)
+		}
+	})
+	err := applyConfig()
+	if err == nil {
+		err = setupFlags()
+	}
+	if err != nil {
+		flag.VisitAll(func(f *flag.Flag) {
+			_ = f.Value.Set(previous[f.Name])
+		})
+		_ = setupFlags()
+		return err
+	}
+	resetClients()
+	logrus.Infof("Reloaded the config, hash %s", configHash()[:12])
+	return nil
+}
+
+// resetClients drops the GitHub API clients, the module proxy and the target
+// credentials set up from the previous config, so the next run sets them up
+// again for the repos of the reloaded one.
+func resetClients() {
+	githubOnce, githubClient = sync.Once{}, nil
+	upstreamOnce, upstreamClient = sync.Once{}, nil
+	proxyOnce, sharedProxy, proxyErr = sync.Once{}, nil, nil
+	authMu.Lock()
+	auth, authFetched = nil, time.Time{}
+	authMu.Unlock()
+}
+
+// modTime returns the modification time of name, zero if it can't be read.
+func modTime(name string) time.Time {
+	if name == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
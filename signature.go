@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	keyringOnce sync.Once
+	keyring     string
+	keyringErr  error
+)
+
+// getKeyring returns the armored keyring of --tag-keyring, or "" if upstream
+// tags aren't verified.
+func getKeyring() (string, error) {
+	keyringOnce.Do(func() {
+		if *tagKeyring == "" {
+			return
+		}
+		b, err := os.ReadFile(*tagKeyring)
+		if err != nil {
+			keyringErr = fmt.Errorf("failed to read keyring: %v", err)
+			return
+		}
+		if _, err = openpgp.ReadArmoredKeyRing(strings.NewReader(string(b))); err != nil {
+			keyringErr = fmt.Errorf("failed to parse keyring %s: %v", *tagKeyring, err)
+			return
+		}
+		keyring = string(b)
+	})
+	return keyring, keyringErr
+}
+
+// verifyTag checks the signature of the upstream tag object h against the
+// keyring, so a compromised upstream URL can't get unsigned tags published.
+func verifyTag(r *gogit.Repository, name string, h plumbing.Hash) error {
+	keys, err := getKeyring()
+	if err != nil || keys == "" {
+		return err
+	}
+	tag, err := r.TagObject(h)
+	if err != nil {
+		return fmt.Errorf("tag %s is not an annotated tag, it can't be signed", name)
+	}
+	if tag.PGPSignature == "" {
+		return fmt.Errorf("tag %s is not signed", name)
+	}
+	entity, err := tag.Verify(keys)
+	if err != nil {
+		return fmt.Errorf("failed to verify signature of tag %s: %v", name, err)
+	}
+	logrus.Infof("Tag %s is signed by %s", name, entity.PrimaryIdentity().Name)
+	return nil
+}
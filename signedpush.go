@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/sirupsen/logrus"
+)
+
+// pushSigned pushes refSpecs to the target with a push certificate, which
+// go-git can't send, by running git push --signed in the repo of r. The
+// credentials of the target are passed in the environment, never on the
+// command line.
+func pushSigned(ctx context.Context, r *gogit.Repository, auth transport.AuthMethod, refSpecs []config.RefSpec) error {
+	st, ok := r.Storer.(*filesystem.Storage)
+	if !ok {
+		return fmt.Errorf("signed pushes need a repo on disk")
+	}
+	args := []string{"--git-dir", st.Filesystem().Root(), "push", "--signed=" + *signedPush, "--porcelain", *targetRepo}
+	for _, rs := range refSpecs {
+		args = append(args, string(rs))
+	}
+	var gitConfig [][2]string
+	if *pushSigningKey != "" {
+		gitConfig = append(gitConfig, [2]string{"user.signingKey", *pushSigningKey})
+	}
+	if *pushSigningFmt != "" {
+		gitConfig = append(gitConfig, [2]string{"gpg.format", *pushSigningFmt})
+	}
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	switch a := auth.(type) {
+	case *http.BasicAuth:
+		basic := base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + a.Password))
+		gitConfig = append(gitConfig, [2]string{"http.extraHeader", "Authorization: Basic " + basic})
+	case nil:
+	default:
+		// the key is never encrypted, see setupSignedPush
+		env = append(env, "GIT_SSH_COMMAND=ssh -i "+strconv.Quote(*sshKeyFile)+" -o IdentitiesOnly=yes -o BatchMode=yes")
+	}
+	env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(len(gitConfig)))
+	for i, kv := range gitConfig {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, kv[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, kv[1]))
+	}
+	cmd := commandContext(ctx, "git", args...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	logrus.Debugf("git push --signed=%s: %s", *signedPush, out)
+	if err != nil {
+		return fmt.Errorf("failed to push with a push certificate: %v: %s", err, out)
+	}
+	return nil
+}
+
+// setupSignedPush checks --signed-push.
+func setupSignedPush() error {
+	switch *signedPush {
+	case "":
+		if *pushSigningKey != "" || *pushSigningFmt != "" {
+			return fmt.Errorf("--push-signing-key and --push-signing-format need --signed-push")
+		}
+		return nil
+	case "yes", "if-asked":
+	default:
+		return fmt.Errorf("invalid signed push mode %q, want yes or if-asked", *signedPush)
+	}
+	if *pushSigningFmt != "" && !slices.Contains([]string{"openpgp", "x509", "ssh"}, *pushSigningFmt) {
+		return fmt.Errorf("invalid push signing format %q", *pushSigningFmt)
+	}
+	if *publishVia != "git" {
+		return fmt.Errorf("--signed-push needs --publish-via=git")
+	}
+	if *sshPassFile != "" {
+		// git push runs ssh without a terminal to ask the passphrase on
+		return fmt.Errorf("--signed-push can't use an SSH key with a passphrase, load it in an ssh-agent instead")
+	}
+	return nil
+}
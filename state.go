@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/sirupsen/logrus"
+)
+
+// namespace of the objects of the git state backend on the target
+const stateRefPrefix = "refs/kksyncer/state/"
+
+var (
+	errStateNotFound = errors.New("state object not found")
+	errStateExists   = errors.New("state object already exists")
+)
+
+// stateBackend stores the state of the runs that isn't on the target, for
+// runners that don't keep the workdir between runs.
+type stateBackend interface {
+	// get returns the object name, or errStateNotFound.
+	get(name string) ([]byte, error)
+	// put writes the object name, failing with errStateExists if it
+	// exists and create is set.
+	put(name string, data []byte, create bool) error
+	delete(name string) error
+}
+
+// checkStateBackend validates --state-backend.
+func checkStateBackend() error {
+	switch s := *stateBackendFlag; {
+	case s == "", s == "git":
+		return nil
+	case strings.HasPrefix(s, "s3://"), strings.HasPrefix(s, "gs://"):
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid state backend %q, want s3://<bucket>/<prefix> or gs://<bucket>/<prefix>", s)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid state backend %q, want git, s3://<bucket>/<prefix> or gs://<bucket>/<prefix>", s)
+	}
+}
+
+// openState returns the --state-backend, nil for none, with the objects of
+// the git backend written to r.
+func openState(r *gogit.Repository) (stateBackend, error) {
+	s := *stateBackendFlag
+	switch {
+	case s == "":
+		return nil, nil
+	case s == "git":
+		return gitState{r}, nil
+	}
+	u, _ := url.Parse(s)
+	return newObjectStore(u.Scheme, u.Host, strings.Trim(u.Path, "/"))
+}
+
+// openStateOnly returns the --state-backend for commands without a workdir,
+// the git backend fetching into memory.
+func openStateOnly() (stateBackend, error) {
+	r, err := gogit.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = r.CreateRemote(&config.RemoteConfig{Name: targetRemote, URLs: []string{*targetRepo}}); err != nil {
+		return nil, err
+	}
+	return openState(r)
+}
+
+// stateLock is the in-progress marker of a run, lock.json in the state.
+type stateLock struct {
+	Holder    string    `json:"holder"`
+	StartedAt time.Time `json:"startedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// lockState marks a run in progress in state, failing if another run holds
+// an unexpired marker, and returns the func removing it.
+func lockState(state stateBackend) (func(), error) {
+	host, _ := os.Hostname()
+	now := time.Now().UTC()
+	lock := stateLock{Holder: fmt.Sprintf("%s:%d", host, os.Getpid()), StartedAt: now, ExpiresAt: now.Add(*stateLockTTL)}
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+	err = state.put("lock.json", b, true)
+	if errors.Is(err, errStateExists) {
+		var held stateLock
+		prev, gerr := state.get("lock.json")
+		if gerr != nil {
+			return nil, fmt.Errorf("failed to read the state lock: %v", gerr)
+		}
+		if err = json.Unmarshal(prev, &held); err == nil && now.Before(held.ExpiresAt) {
+			return nil, fmt.Errorf("another run holds the state lock since %s, %s, until %s", held.StartedAt.Format(time.RFC3339), held.Holder, held.ExpiresAt.Format(time.RFC3339))
+		}
+		logrus.Warnf("Taking over the expired state lock of %s", held.Holder)
+		err = state.put("lock.json", b, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the state lock: %v", err)
+	}
+	return func() {
+		if err := state.delete("lock.json"); err != nil {
+			logrus.Warnf("Failed to remove the state lock: %v", err)
+		}
+	}, nil
+}
+
+// loadHistory replaces the --history-db with the one in state, if any.
+func loadHistory(state stateBackend) error {
+	b, err := state.get("history.db")
+	if errors.Is(err, errStateNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(*historyDB), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(*historyDB, b, 0644)
+}
+
+// saveHistory writes the --history-db to state.
+func saveHistory(state stateBackend) error {
+	b, err := os.ReadFile(*historyDB)
+	if err != nil {
+		return err
+	}
+	return state.put("history.db", b, false)
+}
+
+// gitState stores each object as a commit of a single file under
+// stateRefPrefix on the target. Creating an object pushes without force, so
+// it fails if another run created it first.
+type gitState struct {
+	r *gogit.Repository
+}
+
+func (s gitState) get(name string) ([]byte, error) {
+	auth, err := getAuth()
+	if err != nil {
+		return nil, err
+	}
+	ref := plumbing.ReferenceName(stateRefPrefix + name)
+	err = s.r.Fetch(&gogit.FetchOptions{
+		RemoteName:   targetRemote,
+		Auth:         auth,
+		RefSpecs:     []config.RefSpec{config.RefSpec("+" + ref + ":" + ref)},
+		Force:        true,
+		ProxyOptions: transferProxy(targetRemote),
+	})
+	if errors.Is(err, gogit.NoMatchingRefSpecError{}) {
+		return nil, errStateNotFound
+	}
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return nil, err
+	}
+	h, err := s.r.Reference(ref, true)
+	if err != nil {
+		return nil, err
+	}
+	files, err := commitFiles(s.r, h.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return files[name], nil
+}
+
+func (s gitState) put(name string, data []byte, create bool) error {
+	ref := plumbing.ReferenceName(stateRefPrefix + name)
+	h, err := writeCommit(s.r, map[string][]byte{name: data}, "kksyncer state "+name, time.Now())
+	if err != nil {
+		return err
+	}
+	if err = s.r.Storer.SetReference(plumbing.NewHashReference(ref, h)); err != nil {
+		return err
+	}
+	spec := ref + ":" + ref
+	if !create {
+		spec = "+" + spec
+	}
+	// not canceled with the run, so a canceled run still releases its lock
+	err = pushRefs(context.Background(), s.r, name, []config.RefSpec{config.RefSpec(spec)})
+	// the orphan commit never matches an existing object
+	if create && err != nil {
+		if taken, terr := takenRefs(context.Background(), s.r, []config.RefSpec{config.RefSpec(spec)}); terr == nil && len(taken) > 0 {
+			return errStateExists
+		}
+	}
+	return err
+}
+
+func (s gitState) delete(name string) error {
+	return pushRefs(context.Background(), s.r, name, []config.RefSpec{config.RefSpec(":" + stateRefPrefix + name)})
+}
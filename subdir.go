@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// the ref the default branch of the target is fetched to with --target-subdir
+const subdirBaseRef = "refs/kksyncer/subdir-base"
+
+// checkTargetSubdir validates --target-subdir and points --target-ref-prefix
+// at its tags, the ones the go command resolves the module in it from.
+func checkTargetSubdir() error {
+	if *targetSubdir == "" {
+		return nil
+	}
+	dir := strings.Trim(*targetSubdir, "/")
+	if dir == "" || dir == "." || path.Clean(dir) != dir || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("invalid target subdir %q, want a directory of the target like third_party/kubernetes", *targetSubdir)
+	}
+	*targetSubdir = dir
+	prefix := "refs/tags/" + dir + "/"
+	switch *targetRefPrefix {
+	case "refs/tags/":
+		*targetRefPrefix = prefix
+	case prefix:
+	default:
+		return fmt.Errorf("--target-subdir=%s publishes the tags under %s, not --target-ref-prefix %s", dir, prefix, *targetRefPrefix)
+	}
+	if *numWorkers != 1 {
+		// every tag is a commit on the default branch
+		return fmt.Errorf("--target-subdir needs --workers=1, to commit the tags to the default branch in order")
+	}
+	if *linearHistory || *publishVia == "github-api" || *mirrorAll {
+		// --mirror would push the upstream branches and tags over the ones of
+		// the monorepo
+		return fmt.Errorf("--target-subdir can't be used with --linear-history, --publish-via=github-api or --mirror")
+	}
+	if !slices.ContainsFunc(tagPipeline, func(s *pipelineStep) bool { return s.name == "subdir" }) {
+		return fmt.Errorf("--target-subdir needs the subdir step in the pipeline")
+	}
+	return nil
+}
+
+// targetDefaultBranch returns the default branch of the target, the one its
+// HEAD points to, like refs/heads/main.
+func targetDefaultBranch(ctx context.Context) (plumbing.ReferenceName, error) {
+	auth, err := getAuth()
+	if err != nil {
+		return "", err
+	}
+	rm := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: targetRemote, URLs: []string{*targetRepo}})
+	refs, err := rm.ListContext(ctx, &gogit.ListOptions{Auth: auth, ProxyOptions: transferProxy(targetRemote)})
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+	return "", errors.New("the target has no default branch")
+}
+
+// subdirCommit fetches the default branch of the target into r and returns a
+// commit on top of it with the tree of the rewrite commit under
+// --target-subdir, replacing the previous one, and the branch to push it to.
+func subdirCommit(ctx context.Context, r *gogit.Repository, rewrite *object.Commit) (plumbing.Hash, plumbing.ReferenceName, error) {
+	branch, err := targetDefaultBranch(ctx)
+	if err != nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("failed to find the default branch of the target: %v", err)
+	}
+	auth, err := getAuth()
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	err = r.FetchContext(ctx, &gogit.FetchOptions{
+		RemoteName:   targetRemote,
+		Auth:         auth,
+		RefSpecs:     []config.RefSpec{config.RefSpec("+" + branch + ":" + subdirBaseRef)},
+		Tags:         gogit.NoTags,
+		Force:        true,
+		ProxyOptions: transferProxy(targetRemote),
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, "", fmt.Errorf("failed to fetch %s of the target: %v", branch.Short(), err)
+	}
+	ref, err := r.Reference(subdirBaseRef, true)
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	base, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	tree, err := graftTree(r, base.TreeHash, *targetSubdir, rewrite.TreeHash)
+	if err != nil {
+		return plumbing.ZeroHash, "", fmt.Errorf("failed to write the tree of %s: %v", branch.Short(), err)
+	}
+	commit := &object.Commit{
+		Author:       rewrite.Author,
+		Committer:    rewrite.Committer,
+		Message:      rewrite.Message,
+		TreeHash:     tree,
+		ParentHashes: []plumbing.Hash{base.Hash},
+	}
+	obj := r.Storer.NewEncodedObject()
+	if err = commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	h, err := r.Storer.SetEncodedObject(obj)
+	return h, branch, err
+}
+
+// graftTree stores the tree base, zero for an empty one, with the tree sub at
+// the slash separated dir in r, replacing what was there.
+func graftTree(r *gogit.Repository, base plumbing.Hash, dir string, sub plumbing.Hash) (plumbing.Hash, error) {
+	name, rest, nested := strings.Cut(dir, "/")
+	tree := &object.Tree{}
+	var child plumbing.Hash
+	if !base.IsZero() {
+		t, err := r.TreeObject(base)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		for _, e := range t.Entries {
+			if e.Name != name {
+				tree.Entries = append(tree.Entries, e)
+			} else if e.Mode == filemode.Dir {
+				child = e.Hash
+			}
+		}
+	}
+	h := sub
+	if nested {
+		var err error
+		if h, err = graftTree(r, child, rest, sub); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: h})
+	// git orders the entries by name, directories as if they ended with /
+	sortName := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	slices.SortFunc(tree.Entries, func(a, b object.TreeEntry) int {
+		return strings.Compare(sortName(a), sortName(b))
+	})
+	obj := r.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// submoduleRewrites returns the <prefix>=<replacement> pairs of
+// --submodule-url-rewrites.
+func submoduleRewrites() [][2]string {
+	var rewrites [][2]string
+	for _, s := range strings.Split(*submoduleURLs, ",") {
+		if from, to, ok := strings.Cut(strings.TrimSpace(s), "="); ok {
+			rewrites = append(rewrites, [2]string{from, to})
+		}
+	}
+	return rewrites
+}
+
+// checkSubmoduleRewrites returns an error if a rewrite of
+// --submodule-url-rewrites isn't <prefix>=<replacement>.
+func checkSubmoduleRewrites() error {
+	for _, s := range strings.Split(*submoduleURLs, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if from, to, ok := strings.Cut(s, "="); !ok || from == "" || to == "" {
+			return fmt.Errorf("invalid submodule URL rewrite %q, want <prefix>=<replacement>", s)
+		}
+	}
+	if *submoduleURLs != "" && !*submodulesOn {
+		return fmt.Errorf("--submodule-url-rewrites needs --submodules")
+	}
+	return nil
+}
+
+// updateSubmodules checks out the submodules of the tree at root recursively
+// at the commits it records, with the extra variables env and the URLs of
+// its .gitmodules rewritten by --submodule-url-rewrites like git's
+// url.<base>.insteadOf. They are synced first, as the worktrees of the
+// workers are reused by tags whose .gitmodules may differ.
+func updateSubmodules(ctx context.Context, root string, env []string) error {
+	if !fileExists(filepath.Join(root, ".gitmodules")) {
+		return nil
+	}
+	var config []string
+	for _, rw := range submoduleRewrites() {
+		config = append(config, "-c", "url."+rw[1]+".insteadOf="+rw[0])
+	}
+	for _, args := range [][]string{{"submodule", "sync", "--recursive", "--quiet"}, {"submodule", "update", "--init", "--recursive", "--quiet"}} {
+		cmd := commandContext(ctx, "git", append(append([]string{"-C", root}, config...), args...)...)
+		// never wait for credentials of a private submodule
+		cmd.Env = append(append(os.Environ(), env...), "GIT_TERMINAL_PROMPT=0")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to run git %s %s: %v: %s", args[0], args[1], err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runSummary is the outcome of a sync run.
+type runSummary struct {
+	Published []string `json:"published"`
+	Failed    []string `json:"failed"`
+	// pending tags not started before --max-duration
+	Deferred []string   `json:"deferred,omitempty"`
+	Skipped  []*tagInfo `json:"skipped"`
+	// published tags differing from a fresh rewrite with --tag-divergence
+	Diverged []string `json:"diverged,omitempty"`
+	// known vulnerabilities by published tag
+	Vulnerabilities map[string][]vulnFinding `json:"vulnerabilities,omitempty"`
+	// whether the --test-packages passed, by tag
+	Tests map[string]bool `json:"tests,omitempty"`
+	// outcome of building each tag with the --go-versions, by tag and version
+	GoVersions map[string]map[string]string `json:"goVersions,omitempty"`
+	// the --policy violations of the failed tags, by tag
+	PolicyViolations map[string][]string `json:"policyViolations,omitempty"`
+	// how long the published and failed tags took
+	durations map[string]time.Duration
+	// the outcomes of the handled tags for the --history-db
+	history map[string]*tagHistory
+
+	mu sync.Mutex
+}
+
+func newRunSummary(tags []*tagInfo) *runSummary {
+	s := &runSummary{durations: map[string]time.Duration{}, history: map[string]*tagHistory{}}
+	for _, t := range tags {
+		if t.Status == statusSkipped {
+			s.Skipped = append(s.Skipped, t)
+		}
+	}
+	return s
+}
+
+func (s *runSummary) addVulns(tag string, findings []vulnFinding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Vulnerabilities == nil {
+		s.Vulnerabilities = map[string][]vulnFinding{}
+	}
+	s.Vulnerabilities[tag] = findings
+}
+
+func (s *runSummary) addTests(tag string, passed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Tests == nil {
+		s.Tests = map[string]bool{}
+	}
+	s.Tests[tag] = passed
+}
+
+func (s *runSummary) addGoVersions(tag string, results map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.GoVersions == nil {
+		s.GoVersions = map[string]map[string]string{}
+	}
+	s.GoVersions[tag] = results
+}
+
+func (s *runSummary) addPolicyViolations(tag string, violations []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.PolicyViolations == nil {
+		s.PolicyViolations = map[string][]string{}
+	}
+	s.PolicyViolations[tag] = violations
+}
+
+// recordTag records the outcome of tag, started at started and failed with
+// err in phase if not nil.
+func (s *runSummary) recordTag(tag string, started time.Time, phase string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tagEntry(tag)
+	t.StartedAt, t.DurationSeconds, t.Outcome = started.UTC(), time.Since(started).Seconds(), "published"
+	if err != nil {
+		t.Outcome, t.Phase, t.Error = "failed", phase, err.Error()
+	}
+}
+
+// recordCommits records the upstream commit of tag and the published one.
+func (s *runSummary) recordCommits(tag, source, commit string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tagEntry(tag)
+	t.SourceCommit, t.Commit, t.PublishedTag = source, commit, tag+"-mod"
+}
+
+func (s *runSummary) tagEntry(tag string) *tagHistory {
+	t, ok := s.history[tag]
+	if !ok {
+		t = &tagHistory{Tag: tag}
+		s.history[tag] = t
+	}
+	return t
+}
+
+// tagHistory returns the recorded outcomes of the handled tags.
+func (s *runSummary) tagHistory() []tagHistory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tags []tagHistory
+	for _, tag := range slices.SortedFunc(maps.Keys(s.history), compareTags) {
+		tags = append(tags, *s.history[tag])
+	}
+	return tags
+}
+
+// logSkipped emits a record for every skipped tag.
+func (s *runSummary) logSkipped() {
+	for _, t := range s.Skipped {
+		logrus.WithFields(logrus.Fields{"tag": t.Name, "reason": t.Reason}).Info("Skipped tag")
+	}
+}
+
+func (s *runSummary) log() {
+	logrus.WithFields(logrus.Fields{
+		"published": len(s.Published),
+		"failed":    len(s.Failed),
+		"skipped":   len(s.Skipped),
+	}).Info("Sync finished")
+	if len(s.Published) > 0 {
+		logrus.Infof("Published %d tags: %s", len(s.Published), strings.Join(s.Published, ", "))
+	}
+	if len(s.Failed) > 0 {
+		logrus.Infof("Failed %d tags: %s", len(s.Failed), strings.Join(s.Failed, ", "))
+	}
+	if len(s.Deferred) > 0 {
+		logrus.Infof("Deferred %d tags to the next run: %s", len(s.Deferred), strings.Join(s.Deferred, ", "))
+	}
+	if len(s.Diverged) > 0 {
+		logrus.Infof("Diverged %d published tags from the rewrite: %s", len(s.Diverged), strings.Join(s.Diverged, ", "))
+	}
+	var reasons []string
+	byReason := map[string][]string{}
+	for _, t := range s.Skipped {
+		if _, ok := byReason[t.Reason]; !ok {
+			reasons = append(reasons, t.Reason)
+		}
+		byReason[t.Reason] = append(byReason[t.Reason], t.Name)
+	}
+	for _, reason := range reasons {
+		logrus.Infof("Skipped %d tags, %s: %s", len(byReason[reason]), reason, strings.Join(byReason[reason], ", "))
+	}
+	var passed, failed []string
+	for _, tag := range slices.SortedFunc(maps.Keys(s.Tests), compareTags) {
+		if s.Tests[tag] {
+			passed = append(passed, tag)
+		} else {
+			failed = append(failed, tag)
+		}
+	}
+	if len(passed) > 0 {
+		logrus.Infof("Tests passed on %d tags: %s", len(passed), strings.Join(passed, ", "))
+	}
+	if len(failed) > 0 {
+		logrus.Infof("Tests failed on %d tags: %s", len(failed), strings.Join(failed, ", "))
+	}
+	for _, tag := range slices.SortedFunc(maps.Keys(s.GoVersions), compareTags) {
+		var cells []string
+		for _, v := range goVersionsList() {
+			if r, ok := s.GoVersions[tag][v]; ok && r == "ok" {
+				cells = append(cells, v+" ok")
+			} else if ok {
+				cells = append(cells, v+" failed")
+			}
+		}
+		logrus.Infof("Tag %s with Go %s", tag, strings.Join(cells, ", "))
+	}
+	for _, tag := range slices.SortedFunc(maps.Keys(s.PolicyViolations), compareTags) {
+		logrus.Infof("Tag %s has %d policy violations: %s", tag, len(s.PolicyViolations[tag]), strings.Join(s.PolicyViolations[tag], "; "))
+	}
+	for _, tag := range slices.Sorted(maps.Keys(s.Vulnerabilities)) {
+		var ids []string
+		for _, f := range s.Vulnerabilities[tag] {
+			ids = append(ids, f.ID+" ("+f.Level+")")
+		}
+		if len(ids) > 0 {
+			logrus.Infof("Tag %s has %d known vulnerabilities: %s", tag, len(ids), strings.Join(ids, ", "))
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// setupSyslog fails for --syslog, there is no syslog on this platform.
+func setupSyslog() error {
+	if *syslogTarget != "" {
+		return fmt.Errorf("--syslog is not supported on this platform")
+	}
+	return nil
+}
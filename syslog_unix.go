@@ -0,0 +1,143 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// journalSocket is where journald receives native protocol datagrams.
+const journalSocket = "/run/systemd/journal/socket"
+
+// syslogHook writes the logs to syslog with the priority of their level.
+type syslogHook struct {
+	w *syslog.Writer
+}
+
+func (h *syslogHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	msg := logLine(entry)
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.w.Crit(msg)
+	case logrus.ErrorLevel:
+		return h.w.Err(msg)
+	case logrus.WarnLevel:
+		return h.w.Warning(msg)
+	case logrus.InfoLevel:
+		return h.w.Info(msg)
+	default:
+		return h.w.Debug(msg)
+	}
+}
+
+// logLine returns the message of entry followed by its fields, like
+// "Failed to handle tag v1.30.0: ... phase=push tag=v1.30.0".
+func logLine(entry *logrus.Entry) string {
+	line := entry.Message
+	for _, k := range slices.Sorted(maps.Keys(entry.Data)) {
+		line += fmt.Sprintf(" %s=%v", k, entry.Data[k])
+	}
+	return line
+}
+
+// journalHook writes the logs to journald with its native protocol, the
+// fields of the entries, like the tag, become journal fields.
+type journalHook struct {
+	conn *net.UnixConn
+}
+
+func (h *journalHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *journalHook) Fire(entry *logrus.Entry) error {
+	var b bytes.Buffer
+	writeJournalField(&b, "MESSAGE", entry.Message)
+	writeJournalField(&b, "PRIORITY", strconv.Itoa(int(journalPriority(entry.Level))))
+	writeJournalField(&b, "SYSLOG_IDENTIFIER", *syslogTag)
+	for k, v := range entry.Data {
+		writeJournalField(&b, journalFieldName(k), fmt.Sprint(v))
+	}
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+// writeJournalField writes a field of the native protocol, values with
+// newlines are written with their length.
+func writeJournalField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+	b.WriteString(key + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName returns k as a journal field name: uppercase letters,
+// digits and underscores, not starting with an underscore.
+func journalFieldName(k string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, k)
+	return "KKSYNCER_" + name
+}
+
+func journalPriority(level logrus.Level) syslog.Priority {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return syslog.LOG_CRIT
+	case logrus.ErrorLevel:
+		return syslog.LOG_ERR
+	case logrus.WarnLevel:
+		return syslog.LOG_WARNING
+	case logrus.InfoLevel:
+		return syslog.LOG_INFO
+	}
+	return syslog.LOG_DEBUG
+}
+
+// setupSyslog adds the hook writing the logs to --syslog: local for the
+// local syslog daemon, journald, or <network>://<address> of a remote one.
+func setupSyslog() error {
+	switch *syslogTarget {
+	case "":
+		return nil
+	case "journald":
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+		if err != nil {
+			return fmt.Errorf("failed to connect to journald: %v", err)
+		}
+		logrus.AddHook(&journalHook{conn: conn})
+		return nil
+	}
+	network, addr := "", ""
+	if *syslogTarget != "local" {
+		var ok bool
+		if network, addr, ok = strings.Cut(*syslogTarget, "://"); !ok {
+			return fmt.Errorf("invalid syslog %q, want local, journald or <network>://<address>", *syslogTarget)
+		}
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, *syslogTag)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %v", err)
+	}
+	logrus.AddHook(&syslogHook{w: w})
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagEnvRule is an entry of --tag-env, a variable set for the git and go
+// commands of the upstream tags in its version range.
+type tagEnvRule struct {
+	name, value string
+	when        versionRange
+}
+
+// tagEnvRules are the parsed --tag-env.
+var tagEnvRules []*tagEnvRule
+
+// parseTagEnv parses entries separated by semicolons or newlines, like
+// "GOFLAGS=-mod=mod if <1.20.0; GOPROXY=https://proxy.example.com":
+//
+//	<name>=<value>                     for every tag
+//	<name>=<value> if <version range>  for the tags in the range
+//
+// The value is the rest of the entry, spaces included, and a later entry
+// for the same tag wins. Lines starting with # are comments.
+func parseTagEnv(s string) ([]*tagEnvRule, error) {
+	var rules []*tagEnvRule
+	for _, text := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '\n' }) {
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := &tagEnvRule{}
+		body, cond, ok := strings.Cut(text, " if ")
+		if ok {
+			var err error
+			if rule.when, err = parseVersionRange(cond); err != nil {
+				return nil, fmt.Errorf("invalid tag env %q: %v", text, err)
+			}
+		}
+		if rule.name, rule.value, ok = strings.Cut(strings.TrimSpace(body), "="); !ok || rule.name == "" || strings.ContainsAny(rule.name, " \t") {
+			return nil, fmt.Errorf("invalid tag env %q, want <name>=<value> [if <version range>]", text)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// tagEnv returns the variables of --tag-env for tag, as NAME=value.
+func tagEnv(tag string) []string {
+	var env []string
+	for _, r := range tagEnvRules {
+		if r.when.match(tag) {
+			env = append(env, r.name+"="+r.value)
+		}
+	}
+	return env
+}
+
+// tagEnvVaries returns whether --tag-env sets different variables for
+// different tags.
+func tagEnvVaries() bool {
+	for _, r := range tagEnvRules {
+		if r.when != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+// This is a generated file. Do not edit directly.
+// Ensure you've carefully read
+// https://git.k8s.io/community/contributors/devel/sig-architecture/vendor.md
+// Run hack/pin-dependency.sh to change pinned dependency versions.
+// Run hack/update-vendor.sh to update go.mod files and the vendor directory.
+
+module k8s.io/kubernetes
+
+go 1.19
+
+require (
+	github.com/blang/semver/v4 v4.0.0
+	github.com/google/go-cmp v0.5.9
+	github.com/spf13/cobra v1.6.0
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/net v0.1.1-0.20221027164007-c63010009c80
+	k8s.io/api v0.0.0
+	k8s.io/apimachinery v0.0.0
+	k8s.io/client-go v0.0.0
+	k8s.io/component-base v0.0.0
+	k8s.io/klog/v2 v2.80.1
+	k8s.io/utils v0.0.0-20221107191617-1a15be271d1d
+)
+
+require (
+	github.com/go-logr/logr v1.2.3 // indirect
+	github.com/gogo/protobuf v1.3.2 // indirect
+	k8s.io/code-generator v0.0.0 // indirect
+	sigs.k8s.io/json v0.0.0-20220713155537-f223a00ba0e2 // indirect
+)
+
+replace (
+	k8s.io/api => ./staging/src/k8s.io/api
+	k8s.io/apiextensions-apiserver => ./staging/src/k8s.io/apiextensions-apiserver
+	k8s.io/apimachinery => ./staging/src/k8s.io/apimachinery
+	k8s.io/client-go => ./staging/src/k8s.io/client-go
+	k8s.io/code-generator => ./staging/src/k8s.io/code-generator
+	k8s.io/component-base => ./staging/src/k8s.io/component-base
+)
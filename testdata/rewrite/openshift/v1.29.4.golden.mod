@@ -0,0 +1,27 @@
+// This is a generated file. Do not edit directly.
+// Ensure you've carefully read
+// https://git.k8s.io/community/contributors/devel/sig-architecture/vendor.md
+// Run hack/pin-dependency.sh to change pinned dependency versions.
+// Run hack/update-vendor.sh to update go.mod files and the vendor directory.
+
+module k8s.io/kubernetes
+
+go 1.21
+
+require (
+	github.com/onsi/ginkgo/v2 v2.13.0
+	github.com/openshift/api v0.0.0-20240301093301-ce10821dc999
+	github.com/openshift/library-go v0.0.0-20240312085907-ce8ba5e8e8a1
+	github.com/spf13/cobra v1.7.0
+	k8s.io/api v0.29.4
+	k8s.io/apimachinery v0.29.4
+	k8s.io/client-go v0.29.4
+	k8s.io/klog/v2 v2.110.1
+)
+
+require (
+	github.com/go-logr/logr v1.3.0 // indirect
+	k8s.io/kms v0.29.4 // indirect
+)
+
+replace github.com/onsi/ginkgo/v2 => github.com/openshift/onsi-ginkgo/v2 v2.6.1-0.20231031162821-c5e24be53ea7
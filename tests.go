@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lines of go test output kept in the error of a failed tag
+const testOutputLines = 30
+
+// testPackages returns the package patterns of --test-packages.
+func testPackages() []string {
+	var patterns []string
+	for _, p := range strings.Split(*testPackagesFlag, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// runTests runs go test on the --test-packages of the rewritten tree at
+// root, and returns an error with the end of the output if they fail.
+func runTests(ctx context.Context, root string, env []string) error {
+	args := append(append([]string{"test"}, goModFlags()...), "-timeout", testTimeout.String())
+	cmd := commandContext(ctx, "go", append(args, testPackages()...)...)
+	cmd.Dir, cmd.Env = root, goEnv(env)
+	logrus.Infof("Testing %s", strings.Join(testPackages(), " "))
+	release := acquireGo()
+	out, err := cmd.CombinedOutput()
+	release()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+		if len(lines) > testOutputLines {
+			lines = lines[len(lines)-testOutputLines:]
+		}
+		return fmt.Errorf("tests failed: %v\n%s", err, strings.Join(lines, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"golang.org/x/net/proxy"
+)
+
+// scheme of the proxy URL routing SSH connections through throttledDial,
+// go-git having no other way to dial them
+const throttledScheme = "kksyncer-throttled"
+
+// largest read or write waited for at once, so transfers stay smooth
+const throttleChunk = 16 << 10
+
+var rateRe = regexp.MustCompile(`^([0-9]+)(B|KB|KiB|MB|MiB|GB|GiB)?$`)
+
+var rateUnits = map[string]int64{"": 1, "B": 1, "KB": 1e3, "KiB": 1 << 10, "MB": 1e6, "MiB": 1 << 20, "GB": 1e9, "GiB": 1 << 30}
+
+// the limiters of the received and sent bytes of all git connections
+var downLimiter, upLimiter rateLimiter
+
+func init() {
+	proxy.RegisterDialerType(throttledScheme, func(*url.URL, proxy.Dialer) (proxy.Dialer, error) {
+		return throttledDialer{}, nil
+	})
+}
+
+// rateLimiter spreads transfers to at most rate bytes per second, zero not
+// limiting them.
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	l.rate, l.next = rate, time.Time{}
+	l.mu.Unlock()
+}
+
+// wait blocks until n more bytes can be transferred.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// throttledConn is a connection limited by downLimiter and upLimiter.
+type throttledConn struct {
+	net.Conn
+}
+
+func (c throttledConn) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := c.Conn.Read(p)
+	downLimiter.wait(n)
+	return n, err
+}
+
+func (c throttledConn) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		upLimiter.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// throttledDial dials a throttled connection, through the proxy of
+// ALL_PROXY if set like go-git does.
+func throttledDial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := proxy.Dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return throttledConn{conn}, nil
+}
+
+type throttledDialer struct{}
+
+func (throttledDialer) Dial(network, addr string) (net.Conn, error) {
+	return throttledDial(context.Background(), network, addr)
+}
+
+func (throttledDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return throttledDial(ctx, network, addr)
+}
+
+// parseRate parses a rate in bytes per second, like 2MiB.
+func parseRate(s string) (int64, error) {
+	m := rateRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid transfer rate %q, want bytes per second like 512KiB or 2MiB", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid transfer rate %q: %v", s, err)
+	}
+	return n * rateUnits[m[2]], nil
+}
+
+// setupTransferRate limits the git connections of go-git to
+// --max-transfer-rate in each direction.
+func setupTransferRate() error {
+	var rate int64
+	if *maxTransferRate != "" {
+		var err error
+		if rate, err = parseRate(*maxTransferRate); err != nil {
+			return err
+		}
+	}
+	downLimiter.setRate(rate)
+	upLimiter.setRate(rate)
+	if rate == 0 {
+		client.InstallProtocol("http", githttp.DefaultClient)
+		client.InstallProtocol("https", githttp.DefaultClient)
+		return nil
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return throttledConn{conn}, nil
+	}
+	c := githttp.NewClient(&http.Client{Transport: tr})
+	client.InstallProtocol("http", c)
+	client.InstallProtocol("https", c)
+	return nil
+}
+
+// transferProxy returns the proxy options throttling the SSH connections to
+// remote name, go-git dialing HTTP ones with the client of
+// setupTransferRate.
+func transferProxy(name string) transport.ProxyOptions {
+	return transferProxyURL(remoteURL(name))
+}
+
+// transferProxyURL returns the proxy options of the remote at url, like
+// transferProxy.
+func transferProxyURL(url string) transport.ProxyOptions {
+	if *maxTransferRate == "" {
+		return transport.ProxyOptions{}
+	}
+	ep, err := transport.NewEndpoint(url)
+	if err != nil || ep.Protocol != "ssh" {
+		return transport.ProxyOptions{}
+	}
+	return transport.ProxyOptions{URL: throttledScheme + "://local"}
+}
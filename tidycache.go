@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// tidyResult is the go.mod and go.sum a rewrite of version produced, where
+// the modules in pinned were required at version.
+type tidyResult struct {
+	version  string
+	pinned   []string
+	mod, sum []byte
+}
+
+// tidyCache holds tidy results by the hash of the upstream go.mod. Patch
+// releases of a minor usually share the upstream go.mod, and only differ in
+// the version the replaced modules are pinned to.
+var tidyCache = struct {
+	sync.Mutex
+	m map[[sha256.Size]byte]*tidyResult
+}{m: map[[sha256.Size]byte]*tidyResult{}}
+
+func storeTidyResult(upstreamMod []byte, version string, pinned []string, mod, sum []byte) {
+	tidyCache.Lock()
+	defer tidyCache.Unlock()
+	tidyCache.m[sha256.Sum256(upstreamMod)] = &tidyResult{version: version, pinned: pinned, mod: mod, sum: sum}
+}
+
+// reuseTidyResult derives the go.mod and go.sum of a rewrite from a cached
+// result for the same upstream go.mod. The result is only reused if the go.mod
+// files of the pinned modules at version have the same requirements as at the
+// cached version, so that the module graph only differs in the pinned versions.
+func reuseTidyResult(upstreamMod []byte, version string, pinned []string) (mod, sum []byte, ok bool) {
+	tidyCache.Lock()
+	c := tidyCache.m[sha256.Sum256(upstreamMod)]
+	tidyCache.Unlock()
+	if c == nil || !slices.Equal(c.pinned, pinned) {
+		return nil, nil, false
+	}
+	if c.version == version {
+		return c.mod, c.sum, true
+	}
+	mod, sum, err := c.rebase(version)
+	if err != nil {
+		logrus.Infof("Not reusing tidy result of %s for %s: %v", c.version, version, err)
+		return nil, nil, false
+	}
+	logrus.Infof("Reusing tidy result of %s for %s", c.version, version)
+	return mod, sum, true
+}
+
+func (c *tidyResult) rebase(version string) ([]byte, []byte, error) {
+	p, err := getModProxy()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, path := range c.pinned {
+		oldMod, err := p.GoMod(module.Version{Path: path, Version: c.version})
+		if err != nil {
+			return nil, nil, err
+		}
+		newMod, err := p.GoMod(module.Version{Path: path, Version: version})
+		if err != nil {
+			return nil, nil, err
+		}
+		if !sameRequirements(oldMod, newMod, c.pinned) {
+			return nil, nil, fmt.Errorf("requirements of %s changed", path)
+		}
+	}
+
+	modFile, err := modfile.Parse("go.mod", c.mod, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, path := range c.pinned {
+		if err = modFile.AddRequire(path, version); err != nil {
+			return nil, nil, err
+		}
+	}
+	mod, err := modFile.Format()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// replace the checksums of the pinned modules, keeping go.sum order
+	sums := map[module.Version]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(c.sum)), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			return nil, nil, fmt.Errorf("malformed go.sum line %q", line)
+		}
+		v := strings.TrimSuffix(f[1], "/go.mod")
+		if slices.Contains(c.pinned, f[0]) && v == c.version {
+			continue
+		}
+		sums[module.Version{Path: f[0], Version: f[1]}] = line
+	}
+	for _, path := range c.pinned {
+		for _, v := range []string{version, version + "/go.mod"} {
+			lines, err := p.Sums(module.Version{Path: path, Version: v})
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, line := range lines {
+				sums[module.Version{Path: path, Version: v}] = line
+			}
+		}
+	}
+	keys := make([]module.Version, 0, len(sums))
+	for k := range sums {
+		keys = append(keys, k)
+	}
+	module.Sort(keys)
+	var sb strings.Builder
+	for _, k := range keys {
+		sb.WriteString(sums[k] + "\n")
+	}
+	return mod, []byte(sb.String()), nil
+}
+
+// sameRequirements reports whether two go.mod files have the same go version
+// and requirements, ignoring the versions of the pinned modules.
+func sameRequirements(a, b []byte, pinned []string) bool {
+	reqs := func(data []byte) []string {
+		f, err := modfile.ParseLax("go.mod", data, nil)
+		if err != nil {
+			return nil
+		}
+		list := []string{}
+		if f.Go != nil {
+			list = append(list, "go "+f.Go.Version)
+		}
+		for _, r := range f.Require {
+			v := r.Mod.Version
+			if slices.Contains(pinned, r.Mod.Path) {
+				v = "pinned"
+			}
+			list = append(list, r.Mod.Path+"@"+v)
+		}
+		slices.Sort(list)
+		return list
+	}
+	ra, rb := reqs(a), reqs(b)
+	return ra != nil && rb != nil && slices.Equal(ra, rb)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// provenanceTrailers returns the git trailers of the commit and tag
+// published for upstream tag name at commit source, one "Key: value" per
+// line, for tools to read with git interpret-trailers --parse.
+func provenanceTrailers(name string, source plumbing.Hash) string {
+	version := toolVersion()["kksyncer"]
+	if version == "" {
+		version = "(devel)"
+	}
+	return fmt.Sprintf("Kksyncer-Version: %s\nSource-Repo: %s\nSource-Tag: %s\nSource-Commit: %s\n", version, redactURL(*sourceRepo), name, source)
+}
+
+// commitMessage returns the message of the rewrite commit of upstream tag
+// name at commit source in the --commit-style, listing the pinned requires
+// and ending with the trailers.
+func commitMessage(name string, source plumbing.Hash, pinned []string) string {
+	message := conventional("chore(release): publish ", "Prepare ") + name + "-mod"
+	if len(pinned) > 0 {
+		message += "\n\nPinned requires:\n\n" + strings.Join(pinned, "\n") + "\n"
+	}
+	if *trailersOn {
+		message = strings.TrimSuffix(message, "\n") + "\n\n" + provenanceTrailers(name, source)
+	}
+	return message
+}
+
+// conventional returns the prefix of a commit subject in the --commit-style.
+func conventional(prefix, plain string) string {
+	if *commitStyle == "conventional" {
+		return prefix
+	}
+	return plain
+}
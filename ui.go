@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how often the live view redraws its status line
+const liveInterval = 200 * time.Millisecond
+
+// liveView is the compact UI of manual runs on a terminal: a status line
+// with the tag, phase and elapsed time of each worker below the logs, which
+// are written above it.
+type liveView struct {
+	mu    sync.Mutex
+	out   *os.File
+	width int
+	total int
+	done  int
+	tasks map[int]*liveTask
+	// whether the status line is on screen
+	drawn bool
+	// git progress by operation
+	ops  map[string]int
+	stop chan struct{}
+}
+
+type liveTask struct {
+	tag, phase string
+	start      time.Time
+}
+
+// live is the live view, nil when the logs are plain.
+var live *liveView
+
+// setupUI enables the live view for --ui=live, or --ui=auto on a terminal.
+func setupUI() {
+	if *uiMode == "plain" || *uiMode == "auto" && !isTerminal(os.Stderr) {
+		return
+	}
+	width, _ := strconv.Atoi(os.Getenv("COLUMNS"))
+	if width <= 0 {
+		width = 100
+	}
+	live = &liveView{out: os.Stderr, width: width, tasks: map[int]*liveTask{}, ops: map[string]int{}, stop: make(chan struct{})}
+	// logrus can't tell the view is a terminal
+	logrus.SetFormatter(&logrus.TextFormatter{ForceColors: true})
+	logrus.SetOutput(live)
+	go live.loop()
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+func (v *liveView) loop() {
+	t := time.NewTicker(liveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-t.C:
+			v.mu.Lock()
+			v.draw()
+			v.mu.Unlock()
+		}
+	}
+}
+
+// Write writes log records above the status line.
+func (v *liveView) Write(b []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.drawn {
+		fmt.Fprint(v.out, "\r\033[K")
+		v.drawn = false
+	}
+	n, err := v.out.Write(b)
+	v.draw()
+	return n, err
+}
+
+// draw redraws the status line, cut to the width of the terminal. Callers
+// hold v.mu.
+func (v *liveView) draw() {
+	if len(v.tasks) == 0 && len(v.ops) == 0 {
+		if v.drawn {
+			fmt.Fprint(v.out, "\r\033[K")
+			v.drawn = false
+		}
+		return
+	}
+	line := fmt.Sprintf("[%d/%d]", v.done, v.total)
+	visible := len(line)
+	add := func(plain, colored string) bool {
+		if visible+1+len(plain) > v.width {
+			return false
+		}
+		line += " " + colored
+		visible += 1 + len(plain)
+		return true
+	}
+	for _, id := range slices.Sorted(maps.Keys(v.tasks)) {
+		t := v.tasks[id]
+		elapsed := time.Since(t.start).Round(time.Second).String()
+		if !add(t.tag+" "+t.phase+" "+elapsed, "\033[1;36m"+t.tag+"\033[0m \033[33m"+t.phase+"\033[0m "+elapsed) {
+			break
+		}
+	}
+	for _, op := range slices.Sorted(maps.Keys(v.ops)) {
+		s := fmt.Sprintf("%s %d%%", op, v.ops[op])
+		if !add(s, "\033[2m"+s+"\033[0m") {
+			break
+		}
+	}
+	fmt.Fprint(v.out, "\r\033[K"+line)
+	v.drawn = true
+}
+
+// setTotal sets the number of tags of the run.
+func (v *liveView) setTotal(n int) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.total = n
+}
+
+// start shows worker id handling tag.
+func (v *liveView) start(id int, tag string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.tasks[id] = &liveTask{tag: tag, phase: "start", start: time.Now()}
+}
+
+// setPhase shows the phase of the tag of worker id, like tidy or push.
+func (v *liveView) setPhase(id int, phase string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok := v.tasks[id]; ok {
+		t.phase = phase
+	}
+}
+
+// finish removes the tag of worker id from the status line.
+func (v *liveView) finish(id int) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.tasks, id)
+	v.done++
+}
+
+// progress shows the git progress of op until it reaches 100%.
+func (v *liveView) progress(op string, pct int) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if pct >= 100 {
+		delete(v.ops, op)
+	} else {
+		v.ops[op] = pct
+	}
+}
+
+// close clears the status line and writes the logs plainly again.
+func (v *liveView) close() {
+	if v == nil {
+		return
+	}
+	close(v.stop)
+	// logrus holds its lock while writing to the view
+	logrus.SetOutput(v.out)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.drawn {
+		fmt.Fprint(v.out, "\r\033[K")
+	}
+}
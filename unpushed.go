@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/sirupsen/logrus"
+)
+
+// the namespace of the workdir keeping the refs of failed pushes, as the
+// refs they were pushed to without refs/, to push them again on the next run
+const unpushedRefPrefix = "refs/kksyncer/unpushed/"
+
+// keepUnpushed copies the refs of refSpecs from the repo of wk to the
+// unpushed refs of the workdir of run, with their objects, the worker repos
+// being cleaned before their next tag. Forced refSpecs aren't kept, a
+// diverged tag is published again by the next check. The workers failing
+// pushes at once keep their refs one after the other.
+func keepUnpushed(run *syncRun, wk *worker, refSpecs []config.RefSpec) error {
+	run.workdirMu.Lock()
+	defer run.workdirMu.Unlock()
+	r := run.workdir
+	for _, rs := range refSpecs {
+		if rs.IsForceUpdate() {
+			continue
+		}
+		ref, err := wk.repo.Reference(plumbing.ReferenceName(rs.Src()), true)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", rs.Src(), err)
+		}
+		if err = copyObjects(r.Storer, wk.repo.Storer, ref.Hash()); err != nil {
+			return fmt.Errorf("failed to copy the objects of %s: %v", rs.Src(), err)
+		}
+		name := plumbing.ReferenceName(unpushedRefPrefix + strings.TrimPrefix(rs.Dst("").String(), "refs/"))
+		if err = r.Storer.SetReference(plumbing.NewHashReference(name, ref.Hash())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyObjects copies object h and the objects it refers to from src to dst,
+// stopping at the ones dst has, like the upstream history of a rewrite
+// commit. Submodule commits aren't followed.
+func copyObjects(dst, src storer.EncodedObjectStorer, h plumbing.Hash) error {
+	if dst.HasEncodedObject(h) == nil {
+		return nil
+	}
+	obj, err := src.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return err
+	}
+	var refs []plumbing.Hash
+	switch obj.Type() {
+	case plumbing.TagObject:
+		t, err := object.DecodeTag(src, obj)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, t.Target)
+	case plumbing.CommitObject:
+		c, err := object.DecodeCommit(src, obj)
+		if err != nil {
+			return err
+		}
+		refs = append(append(refs, c.TreeHash), c.ParentHashes...)
+	case plumbing.TreeObject:
+		t, err := object.DecodeTree(src, obj)
+		if err != nil {
+			return err
+		}
+		for _, e := range t.Entries {
+			if e.Mode != filemode.Submodule {
+				refs = append(refs, e.Hash)
+			}
+		}
+	}
+	for _, ref := range refs {
+		if err = copyObjects(dst, src, ref); err != nil {
+			return err
+		}
+	}
+	// after the objects it refers to, so an interrupted copy is resumed
+	_, err = dst.SetEncodedObject(obj)
+	return err
+}
+
+// retryUnpushed pushes the unpushed refs of the workdir r again, each on its
+// own, and returns the published tags now on the target by name. The refs
+// pushed and the ones rejected because the target has them with another
+// commit, like published by another run, are dropped, the others are kept
+// for the next run.
+func retryUnpushed(ctx context.Context, r *gogit.Repository) (map[string]plumbing.Hash, error) {
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	var unpushed []*plumbing.Reference
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), unpushedRefPrefix) {
+			unpushed = append(unpushed, ref)
+		}
+		return nil
+	})
+	refs.Close()
+	if err != nil || len(unpushed) == 0 {
+		return nil, err
+	}
+	pushed := map[string]plumbing.Hash{}
+	for _, ref := range unpushed {
+		dst := "refs/" + strings.TrimPrefix(ref.Name().String(), unpushedRefPrefix)
+		spec := []config.RefSpec{config.RefSpec(ref.Name().String() + ":" + dst)}
+		err := pushRefs(ctx, r, dst, spec)
+		var taken []string
+		if err != nil {
+			taken, _ = takenRefs(ctx, r, spec)
+		}
+		switch {
+		case err == nil:
+			logrus.Infof("Pushed %s of a failed push of a previous run", dst)
+			if name, ok := strings.CutPrefix(dst, *targetRefPrefix); ok {
+				pushed[name] = ref.Hash()
+				// like fetched from the target
+				local := plumbing.ReferenceName("refs/tags/" + targetRemote + "/" + name)
+				if err = r.Storer.SetReference(plumbing.NewHashReference(local, ref.Hash())); err != nil {
+					return pushed, err
+				}
+			}
+		case len(taken) > 0:
+			logrus.Warnf("Dropping %s of a failed push of a previous run, the target has it with another commit", dst)
+		default:
+			logrus.Warnf("Failed to push %s of a failed push of a previous run again, keeping it for the next run: %v", dst, err)
+			continue
+		}
+		if err = r.Storer.RemoveReference(ref.Name()); err != nil {
+			return pushed, err
+		}
+	}
+	return pushed, nil
+}
+
+// repairWorkdir reconciles the workdir r and the worker repos with the
+// target for --repair: the unpushed refs are pushed again, and the local
+// tags left by unfinished tags, the ones outside of the fetched tags of the
+// remotes, are deleted, as the sync rewrites their upstream tags again.
+func repairWorkdir(ctx context.Context, r *gogit.Repository) error {
+	pushed, err := retryUnpushed(ctx, r)
+	if err != nil {
+		return fmt.Errorf("failed to push the refs of failed pushes: %v", err)
+	}
+	target, err := remoteTags(r, targetRemote)
+	if err != nil {
+		return fmt.Errorf("failed to iterate through %s tags: %v", targetRemote, err)
+	}
+	repos := map[string]*gogit.Repository{*workdir: r}
+	base, err := workerBase()
+	if err != nil {
+		return err
+	}
+	dirs, _ := filepath.Glob(filepath.Join(base, "worker-*", "repo"))
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+			continue
+		}
+		if repos[dir], err = openWorkerRepo(dir); err != nil {
+			return fmt.Errorf("failed to open worker repo %s: %v", dir, err)
+		}
+	}
+	deleted := 0
+	for dir, repo := range repos {
+		tags, err := strayTags(repo)
+		if err != nil {
+			return err
+		}
+		for _, ref := range tags {
+			name := ref.Name().Short()
+			switch h, ok := target[name]; {
+			case !ok:
+				logrus.Infof("Deleting local tag %s of %s, not on the target", name, dir)
+			case h != ref.Hash():
+				logrus.Warnf("Deleting local tag %s of %s, the target has it with another commit", name, dir)
+			}
+			if err = repo.Storer.RemoveReference(ref.Name()); err != nil {
+				return fmt.Errorf("failed to delete tag %s: %v", name, err)
+			}
+			deleted++
+		}
+	}
+	logrus.WithFields(logrus.Fields{"pushed": len(pushed), "deleted": deleted}).Info("Repair finished")
+	return nil
+}
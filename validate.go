@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runValidateConfig checks the config beyond the flag values main already
+// validated: the tag filters, the credentials references and the
+// executables, without fetching secrets or touching git. With --schema it
+// prints the JSON schema of the --config file instead.
+func runValidateConfig(context.Context) error {
+	if *printSchema {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configSchema())
+	}
+	var problems []error
+	if _, err := newTagFilter(); err != nil {
+		problems = append(problems, err)
+	}
+	if *targetRepo == "" {
+		problems = append(problems, errors.New("--target-repo is required"))
+	}
+	problems = append(problems, checkCredentials()...)
+	if _, err := getKeyring(); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := getSigner(); err != nil {
+		problems = append(problems, err)
+	}
+	for _, point := range []string{hookPreRewrite, hookPostRewrite, hookPrePush} {
+		if err := checkExecutable(hookCommand(point)); err != nil {
+			problems = append(problems, fmt.Errorf("hook %s: %v", point, err))
+		}
+	}
+	if !strings.HasPrefix(*postPublishHook, "http://") && !strings.HasPrefix(*postPublishHook, "https://") {
+		if err := checkExecutable(*postPublishHook); err != nil {
+			problems = append(problems, fmt.Errorf("hook post-publish: %v", err))
+		}
+	}
+	for _, p := range pluginList() {
+		if err := checkExecutable(p); err != nil {
+			problems = append(problems, fmt.Errorf("rewrite plugin: %v", err))
+		}
+	}
+	for _, p := range problems {
+		fmt.Println("invalid:", p)
+	}
+	if len(problems) > 0 {
+		return withExitCode(exitConfig, fmt.Errorf("%d problems in the config", len(problems)))
+	}
+	fmt.Println("The config is valid")
+	return nil
+}
+
+// checkCredentials returns the problems of the credentials references,
+// reading no secret.
+func checkCredentials() []error {
+	var problems []error
+	if *targetTokenFile != "" && *tokenSource != "" {
+		problems = append(problems, errors.New("--target-token-file and --target-token-source are mutually exclusive"))
+	}
+	if (*targetTokenFile != "" || *tokenSource != "") && *sshKeyFile != "" {
+		problems = append(problems, errors.New("a target token and --ssh-key-file are mutually exclusive"))
+	}
+	if *tokenSource != "" {
+		if _, err := newTokenProvider(*tokenSource); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	files := [][2]string{{"target-token-file", *targetTokenFile}, {"ssh-key-file", *sshKeyFile}, {"ssh-key-passphrase-file", *sshPassFile}}
+	for _, f := range files {
+		if f[1] == "" {
+			continue
+		}
+		if _, err := os.Stat(f[1]); err != nil {
+			problems = append(problems, fmt.Errorf("--%s: %v", f[0], err))
+		}
+	}
+	return problems
+}
+
+// checkExecutable returns an error if the command isn't an executable, ""
+// is fine.
+func checkExecutable(command string) error {
+	if command == "" {
+		return nil
+	}
+	_, err := exec.LookPath(command)
+	return err
+}
+
+// configSchema returns the JSON schema of the --config file, with a property
+// for every flag.
+func configSchema() map[string]any {
+	properties := map[string]any{
+		"$schema": map[string]any{"type": "string"},
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		p := map[string]any{"description": f.Usage}
+		switch f.Value.(flag.Getter).Get().(type) {
+		case bool:
+			p["type"] = "boolean"
+			p["default"], _ = strconv.ParseBool(f.DefValue)
+		case int, int64, uint, uint64:
+			p["type"] = "integer"
+			p["default"], _ = strconv.ParseInt(f.DefValue, 10, 64)
+		case float64:
+			p["type"] = "number"
+			p["default"], _ = strconv.ParseFloat(f.DefValue, 64)
+		case time.Duration:
+			p["type"] = "string"
+			p["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$|^0$`
+			p["default"] = f.DefValue
+		default:
+			p["type"] = "string"
+			if f.DefValue != "" {
+				p["default"] = f.DefValue
+			}
+		}
+		properties[f.Name] = p
+	})
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"title":                "kksyncer config",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
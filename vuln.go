@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the reachability of a vulnerability, which is used as its severity as the
+// Go vulnerability database has no severity scores
+var vulnLevels = []string{"none", "required", "imported", "called"}
+
+// vulnFinding is a known vulnerability of a published tag.
+type vulnFinding struct {
+	ID           string `json:"id"`
+	Summary      string `json:"summary,omitempty"`
+	Module       string `json:"module"`
+	Version      string `json:"version"`
+	FixedVersion string `json:"fixedVersion,omitempty"`
+	Level        string `json:"level"`
+}
+
+// govulncheckMessage is a message of govulncheck -json, see
+// https://pkg.go.dev/golang.org/x/vuln/internal/govulncheck
+type govulncheckMessage struct {
+	OSV *struct {
+		ID      string `json:"id"`
+		Summary string `json:"summary"`
+	} `json:"osv"`
+	Finding *struct {
+		OSV          string `json:"osv"`
+		FixedVersion string `json:"fixed_version"`
+		Trace        []struct {
+			Module   string `json:"module"`
+			Version  string `json:"version"`
+			Package  string `json:"package"`
+			Function string `json:"function"`
+		} `json:"trace"`
+	} `json:"finding"`
+}
+
+// checkVulns runs govulncheck on the rewritten modules of modFiles in the tree
+// at root, and returns the findings, one per vulnerability at its highest
+// level.
+func checkVulns(ctx context.Context, root string, modFiles []string, env []string) ([]vulnFinding, error) {
+	var findings []vulnFinding
+	for _, modFile := range modFiles {
+		dir := filepath.Join(root, filepath.FromSlash(path.Dir(modFile)))
+		args := []string{"-json"}
+		if *vulnDB != "" {
+			args = append(args, "-db", *vulnDB)
+		}
+		cmd := commandContext(ctx, "govulncheck", append(args, "./...")...)
+		cmd.Dir, cmd.Env = dir, goEnv(env)
+		if flags := goModFlags(); len(flags) > 0 {
+			cmd.Env = append(cmd.Env, "GOFLAGS="+flags[0])
+		}
+		restore, err := preserveFiles(filepath.Join(dir, "go.mod"), filepath.Join(dir, "go.sum"), filepath.Join(root, "go.work.sum"))
+		if err != nil {
+			return nil, err
+		}
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		release := acquireGo()
+		out, err := cmd.Output()
+		release()
+		if rerr := restore(); rerr != nil && err == nil {
+			err = rerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to run govulncheck on %s: %v\n%s", modFile, err, stderr.Bytes())
+		}
+		found, err := parseGovulncheck(out)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse govulncheck output of %s: %v", modFile, err)
+		}
+		findings = append(findings, found...)
+	}
+	return findings, nil
+}
+
+func parseGovulncheck(out []byte) ([]vulnFinding, error) {
+	summaries := map[string]string{}
+	byID := map[string]*vulnFinding{}
+	var ids []string
+	for dec := json.NewDecoder(bytes.NewReader(out)); ; {
+		var msg govulncheckMessage
+		if err := dec.Decode(&msg); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if msg.OSV != nil {
+			summaries[msg.OSV.ID] = msg.OSV.Summary
+		}
+		if msg.Finding == nil || len(msg.Finding.Trace) == 0 {
+			continue
+		}
+		// the first frame is the vulnerable symbol, package or module
+		frame := msg.Finding.Trace[0]
+		level := "required"
+		if frame.Function != "" {
+			level = "called"
+		} else if frame.Package != "" {
+			level = "imported"
+		}
+		f := byID[msg.Finding.OSV]
+		if f == nil {
+			f = &vulnFinding{ID: msg.Finding.OSV, Module: frame.Module, Version: frame.Version, FixedVersion: msg.Finding.FixedVersion}
+			byID[f.ID] = f
+			ids = append(ids, f.ID)
+		}
+		if slices.Index(vulnLevels, level) > slices.Index(vulnLevels, f.Level) {
+			f.Level = level
+		}
+	}
+	var findings []vulnFinding
+	for _, id := range ids {
+		f := byID[id]
+		f.Summary = summaries[id]
+		findings = append(findings, *f)
+	}
+	return findings, nil
+}
+
+// vulnGate returns an error if any of findings is at or above --vuln-fail-on.
+func vulnGate(tag string, findings []vulnFinding) error {
+	threshold := slices.Index(vulnLevels, *vulnFailOn)
+	if threshold <= 0 {
+		return nil
+	}
+	var failing []string
+	for _, f := range findings {
+		if slices.Index(vulnLevels, f.Level) >= threshold {
+			failing = append(failing, f.ID)
+		}
+	}
+	if len(failing) > 0 {
+		return fmt.Errorf("tag %s has vulnerabilities at level %s or above: %v", tag, *vulnFailOn, failing)
+	}
+	return nil
+}
+
+func logVulns(tag string, findings []vulnFinding) {
+	for _, f := range findings {
+		logrus.WithFields(logrus.Fields{
+			"tag":          tag,
+			"id":           f.ID,
+			"module":       f.Module + "@" + f.Version,
+			"fixed":        f.FixedVersion,
+			"reachability": f.Level,
+			"summary":      f.Summary,
+		}).Warn("Known vulnerability")
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/sirupsen/logrus"
+)
+
+// worker handles tags in its own repository, so that checkouts and go mod
+// tidy runs of different tags don't interfere with each other.
+//
+// Worker 0 uses the main workdir. Other workers use a clone of it sharing its
+// objects, located at <worker-dir>/worker-N/repo. With -isolate-gomodcache
+// every worker gets its own GOMODCACHE at <worker-dir>/worker-N/gomodcache.
+type worker struct {
+	id   int
+	dir  string
+	repo *gogit.Repository
+	// the checkout, commit, tag and push of the pipeline on repo
+	git gitRepo
+	env []string
+	// phase of the tag being handled, for the live view and error reports
+	phase string
+	// the tag and when its phase started, for --profile-run
+	tag        string
+	phaseStart time.Time
+	phaseCPU   time.Duration
+}
+
+func (wk *worker) setPhase(phase string) {
+	wk.endPhase()
+	wk.phase = phase
+	wk.phaseStart, wk.phaseCPU = time.Now(), cpuTime()
+	live.setPhase(wk.id, phase)
+}
+
+// endPhase records the time of the current phase, keeping its name for the
+// error reports.
+func (wk *worker) endPhase() {
+	if !wk.phaseStart.IsZero() {
+		recordPhase(wk.tag, wk.phase, wk.phaseStart, wk.phaseCPU)
+		wk.phaseStart = time.Time{}
+	}
+}
+
+// workerBase returns the absolute directory of the worker repos, by default
+// a sibling of the workdir, like ../repo.workers with --workdir=. It can't be
+// inside the workdir, whose worktree is worker 0's.
+func workerBase() (string, error) {
+	wd, err := filepath.Abs(*workdir)
+	if err != nil {
+		return "", err
+	}
+	base := wd + ".workers"
+	if *workerDir != "" {
+		if base, err = filepath.Abs(*workerDir); err != nil {
+			return "", err
+		}
+	}
+	if insideDir(wd, base) {
+		return "", fmt.Errorf("worker dir %s is inside the workdir %s", base, wd)
+	}
+	return base, nil
+}
+
+// checkOutputPaths returns an error if the config or a file or directory
+// kksyncer writes to is inside the workdir, whose worktree worker 0 resets
+// and cleans between tags.
+func checkOutputPaths() error {
+	wd, err := filepath.Abs(*workdir)
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct{ flag, path string }{
+		{"config", *configFile},
+		{"log-file", *logFile},
+		{"audit-log", *auditLog},
+		{"history-db", *historyDB},
+		{"sbom-dir", *sbomDir},
+		{"license-report-dir", *licenseReportDir},
+	} {
+		if f.path == "" {
+			continue
+		}
+		p, err := filepath.Abs(f.path)
+		if err != nil {
+			return err
+		}
+		if insideDir(wd, p) {
+			return fmt.Errorf("--%s %s is inside the workdir %s, which is cleaned between tags", f.flag, f.path, wd)
+		}
+	}
+	return nil
+}
+
+// insideDir returns whether the absolute path p is dir or inside it.
+func insideDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func setupWorkers(r *gogit.Repository) ([]*worker, error) {
+	if *numWorkers < 1 {
+		return nil, fmt.Errorf("invalid worker count %d", *numWorkers)
+	}
+	base, err := workerBase()
+	if err != nil {
+		return nil, err
+	}
+	workers := make([]*worker, *numWorkers)
+	for i := range workers {
+		wk := &worker{id: i, dir: *workdir, repo: r}
+		// with temporary worktrees workers only keep their id and env
+		if i > 0 && !*tempWorktrees {
+			wk.dir = filepath.Join(base, fmt.Sprintf("worker-%d", i), "repo")
+			if wk.repo, err = openWorkerRepo(wk.dir); err != nil {
+				return nil, fmt.Errorf("failed to open worker %d repo: %v", i, err)
+			}
+		}
+		if *isolateMod {
+			cache := filepath.Join(base, fmt.Sprintf("worker-%d", i), "gomodcache")
+			wk.env = append(wk.env, "GOMODCACHE="+cache)
+		}
+		wk.git = goGitRepo{wk.repo}
+		workers[i] = wk
+	}
+	return workers, nil
+}
+
+// openWorkerRepo opens the worker repo at dir, creating it as a shared clone
+// of the main workdir if needed.
+func openWorkerRepo(dir string) (*gogit.Repository, error) {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); os.IsNotExist(err) {
+		src, err := filepath.Abs(*workdir)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Infof("Creating worker repo %s", dir)
+		cmd := exec.Command("git", "clone", "--shared", "--no-checkout", "--quiet", src, dir)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("failed to clone %s: %v", src, err)
+		}
+	}
+	// the shared clone refers to the main workdir objects by absolute path,
+	// on the volume of the workdir on Windows
+	abs, err := filepath.Abs(*workdir)
+	if err != nil {
+		return nil, err
+	}
+	st := filesystem.NewStorageWithOptions(osfs.New(filepath.Join(dir, ".git")), cache.NewObjectLRUDefault(), filesystem.Options{
+		AlternatesFS: osfs.New(filepath.VolumeName(abs) + string(filepath.Separator)),
+	})
+	r, err := gogit.Open(st, osfs.New(dir))
+	if err != nil {
+		return nil, err
+	}
+	// the clone's origin points at the main workdir, redirect it to the target
+	if err = ensureRemote(r, targetRemote, *targetRepo); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// tempWorker returns a worker with the id and env of wk handling a single tag
+// in a shared clone of the workdir in a new directory under the worker dir,
+// and a function removing it. The workdir is left untouched.
+func tempWorker(wk *worker, name string) (*worker, func(), error) {
+	base, err := workerBase()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = os.MkdirAll(base, 0755); err != nil {
+		return nil, nil, err
+	}
+	dir, err := os.MkdirTemp(base, "tag-"+strings.ReplaceAll(name, "/", "-")+"-")
+	if err != nil {
+		return nil, nil, err
+	}
+	remove := func() {
+		if err := removeAll(dir); err != nil {
+			logrus.Warnf("Failed to remove %s: %v", dir, err)
+		}
+	}
+	repo, err := openWorkerRepo(filepath.Join(dir, "repo"))
+	if err != nil {
+		remove()
+		return nil, nil, fmt.Errorf("failed to create temporary worktree: %v", err)
+	}
+	return &worker{id: wk.id, dir: filepath.Join(dir, "repo"), repo: repo, git: goGitRepo{repo}, env: wk.env}, remove, nil
+}
+
+// cleanWorker resets the worktree of wk to HEAD, removes the untracked files
+// of the module directories and the pruned paths, the ones a rewrite
+// creates, and drops local tags, like the one of a failed push, so a failed
+// tag doesn't break the next ones. Ignored files and nested repos are kept,
+// the worktree of worker 0 is the workdir, the current directory by default.
+// Remote tags are kept under refs/tags/<remote>/.
+func cleanWorker(wk *worker) error {
+	git := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = wk.dir
+		out, err := cmd.Output()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("failed to run git %s: %v: %s", args[0], err, exitErr.Stderr)
+		}
+		return out, err
+	}
+	if _, err := git("reset", "--hard", "--quiet"); err != nil {
+		return err
+	}
+	out, err := git("ls-files", "-z", "--", ":(glob)**/go.mod")
+	if err != nil {
+		return err
+	}
+	var pathspecs []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			pathspecs = append(pathspecs, ":(literal)"+path.Dir(f))
+		}
+	}
+	for _, p := range prunePatterns() {
+		pathspecs = append(pathspecs, ":(glob)"+p)
+	}
+	if len(pathspecs) > 0 {
+		if _, err = git(append([]string{"clean", "-fdq", "--"}, slices.Compact(pathspecs)...)...); err != nil {
+			return err
+		}
+	}
+	stray, err := strayTags(wk.repo)
+	if err != nil {
+		return err
+	}
+	for _, ref := range stray {
+		if err = wk.repo.Storer.RemoveReference(ref.Name()); err != nil {
+			return fmt.Errorf("failed to delete tag %s: %v", ref.Name().Short(), err)
+		}
+	}
+	return nil
+}
+
+// strayTags returns the local tags of r, the ones outside of the fetched
+// tags of the remotes, created for a tag being handled.
+func strayTags(r *gogit.Repository) ([]*plumbing.Reference, error) {
+	refs, err := r.Storer.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+	var stray []*plumbing.Reference
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		n := ref.Name().String()
+		if ref.Name().IsTag() && !strings.HasPrefix(n, "refs/tags/"+sourceRemote+"/") && !strings.HasPrefix(n, "refs/tags/"+targetRemote+"/") {
+			stray = append(stray, ref)
+		}
+		return nil
+	})
+	return stray, err
+}
+
+// handleTempTag handles a tag in a temporary worktree of wk.
+func handleTempTag(ctx context.Context, wk *worker, name string, kh plumbing.Hash, run *syncRun) error {
+	twk, remove, err := tempWorker(wk, name)
+	if err != nil {
+		return err
+	}
+	defer remove()
+	err = handleTag(ctx, twk, name, kh, run)
+	wk.phase = twk.phase
+	return err
+}
+
+// syncRun is the state of a sync run shared by the workers.
+type syncRun struct {
+	// deleted upstream tags to retract
+	retracted []string
+	summary   *runSummary
+	// last published commit with --linear-history, which runs one worker
+	linearHead plumbing.Hash
+	// no tags are started after it with --max-duration
+	deadline time.Time
+	// diverged tags published again with --tag-divergence=force, replacing
+	// the published tags
+	republish map[string]plumbing.Hash
+	// the workdir, keeping the refs of failed pushes
+	workdir *gogit.Repository
+	// serializes the writes of the workers to workdir
+	workdirMu sync.Mutex
+}
+
+// runWorkers handles tags with all workers, recording the outcomes in the
+// summary of run. Workers are cleaned before each tag and after failures.
+// Once a tag fails no new tags are started unless --keep-going is set, nor
+// after the deadline of run or once ctx is canceled, which also stops the
+// running tags, and the first error is returned after running tags are done.
+func runWorkers(ctx context.Context, workers []*worker, tags map[string]plumbing.Hash, run *syncRun) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	jobs := make(chan string)
+	for _, wk := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				live.start(wk.id, name)
+				wk.phase = "checkout"
+				tagStarted := time.Now()
+				var err error
+				if *tempWorktrees {
+					err = handleTempTag(ctx, wk, name, tags[name], run)
+				} else if err = cleanWorker(wk); err == nil {
+					err = handleTag(ctx, wk, name, tags[name], run)
+				}
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"tag": name, "phase": wk.phase}).Errorf("Failed to handle tag %s: %v", name, err)
+					// a temporary worktree is gone already
+					if !*tempWorktrees {
+						if cerr := cleanWorker(wk); cerr != nil {
+							logrus.Warnf("Failed to clean worker %d: %v", wk.id, cerr)
+						}
+					}
+				}
+				live.finish(wk.id)
+				run.summary.recordTag(name, tagStarted, wk.phase, err)
+				mu.Lock()
+				run.summary.durations[name] = time.Since(tagStarted)
+				if err != nil {
+					run.summary.Failed = append(run.summary.Failed, name)
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to handle tag %s: %w", name, err)
+					}
+				} else {
+					run.summary.Published = append(run.summary.Published, name)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	names := orderTags(maps.Keys(tags))
+	live.setTotal(len(names))
+	for i, name := range names {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed && !*keepGoing {
+			logrus.Warnf("Stopping after a failure, leaving %d tags to the next run", len(names)-i)
+			run.summary.Deferred = names[i:]
+			break
+		}
+		if !run.deadline.IsZero() && time.Now().After(run.deadline) {
+			logrus.Warnf("Reached --max-duration, leaving %d tags to the next run", len(names)-i)
+			run.summary.Deferred = names[i:]
+			break
+		}
+		// waiting for a free worker until interrupted
+		if ctx.Err() == nil {
+			select {
+			case jobs <- name:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		logrus.Warnf("Interrupted, leaving %d tags to the next run", len(names)-i)
+		run.summary.Deferred = names[i:]
+		break
+	}
+	close(jobs)
+	wg.Wait()
+	// parallel workers finish in any order
+	run.summary.Published = orderTags(slices.Values(run.summary.Published))
+	run.summary.Failed = orderTags(slices.Values(run.summary.Failed))
+	return firstErr
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkerBase(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, dir := *workdir, *workerDir
+	t.Cleanup(func() { *workdir, *workerDir = wd, dir })
+	for _, c := range []struct {
+		workdir, workerDir, want string
+	}{
+		{".", "", cwd + ".workers"},
+		{"repo/", "", filepath.Join(cwd, "repo") + ".workers"},
+		{"repo", "workers", filepath.Join(cwd, "workers")},
+		{"repo", "repo2", filepath.Join(cwd, "repo2")},
+		{".", "workers", ""},
+		{"repo", "repo/workers", ""},
+		{"repo", "repo", ""},
+	} {
+		*workdir, *workerDir = c.workdir, c.workerDir
+		got, err := workerBase()
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("worker dir %q of workdir %q is %s, want an error", c.workerDir, c.workdir, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("worker dir %q of workdir %q is %s, %v, want %s", c.workerDir, c.workdir, got, err, c.want)
+		}
+	}
+}